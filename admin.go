@@ -0,0 +1,241 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// defaultReenrichThreshold is used when ?threshold= is not given
+const defaultReenrichThreshold = 0.7
+
+// reenrichLowConfidence re-runs enrichment for people whose stored gender probability is below the threshold
+func reenrichLowConfidence(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	threshold := defaultReenrichThreshold
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(raw, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			respondError(w, http.StatusBadRequest, "Invalid threshold, expected a number between 0 and 1")
+			return
+		}
+	}
+
+	people, err := findLowConfidencePeople(threshold)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	errs := runBounded(len(people), func(i int) error {
+		return reenrichPerson(r, &people[i])
+	})
+	failed := countErrors(errs)
+
+	respondJSON(w, http.StatusOK, map[string]int{"reenriched": len(people) - failed, "failed": failed})
+}
+
+func findLowConfidencePeople(threshold float64) ([]Person, error) {
+	var people []Person
+	err := db.Where("gender_probability < ? AND NOT manual_override", threshold).Find(&people).Error
+	return people, err
+}
+
+// reenrichPerson refreshes the derived fields of person, recording the change in its
+// history, and leaves the stored values alone if enrichment fails
+func reenrichPerson(r *http.Request, person *Person) error {
+	before := *person
+	if err := enrichPersonData(r.Context(), person); err != nil {
+		return err
+	}
+	return savePersonWithHistory(r, &before, person)
+}
+
+// patronymicGenderBatch is how many people correctPatronymicGenders loads per query
+const patronymicGenderBatch = 500
+
+// correctPatronymicGenders re-derives gender from the patronymic suffix of stored
+// people, without calling Genderize: suffixes such as -ovich/-evich mean male and
+// -ovna/-evna female, in Cyrillic or Latin script; see patronymicGender. A person whose stored gender disagrees and
+// whose probability is below ?threshold= (default 0.7) is corrected, with the
+// change recorded in history; confident provider guesses and manual overrides
+// are left alone. ?dry_run=true only counts what would change.
+func correctPatronymicGenders(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	threshold := defaultReenrichThreshold
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(raw, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			respondError(w, http.StatusBadRequest, "Invalid threshold, expected a number between 0 and 1")
+			return
+		}
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	checked, corrected, unrecognized := 0, 0, 0
+	var lastID uint
+	for {
+		var people []Person
+		err := db.Where("id > ? AND patronymic <> '' AND NOT manual_override", lastID).
+			Order("id").Limit(patronymicGenderBatch).Find(&people).Error
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to load people")
+			return
+		}
+		if len(people) == 0 {
+			break
+		}
+		lastID = people[len(people)-1].ID
+
+		for i := range people {
+			checked++
+			before := people[i]
+			if !correctPatronymicGender(&people[i], threshold) {
+				if patronymicGender(people[i].Patronymic) == "" {
+					unrecognized++
+				}
+				continue
+			}
+			corrected++
+			if dryRun {
+				continue
+			}
+			if err := savePersonWithHistory(r, &before, &people[i]); err != nil {
+				respondDBError(w, err, "Failed to update people")
+				return
+			}
+		}
+	}
+	log.Printf("Patronymic gender check: %d checked, %d corrected, %d unrecognized (dry run: %t)", checked, corrected, unrecognized, dryRun)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"checked":      checked,
+		"corrected":    corrected,
+		"unrecognized": unrecognized,
+		"dry_run":      dryRun,
+	})
+}
+
+// correctPatronymicGender sets the gender of person from its patronymic when the
+// two disagree and the stored gender's probability is below threshold, reporting
+// whether it did. Like the patronymic enrichment source, the inferred gender is
+// stored with probability 1.
+func correctPatronymicGender(person *Person, threshold float64) bool {
+	gender := patronymicGender(person.Patronymic)
+	if gender == "" || gender == person.Gender || person.GenderProbability >= threshold {
+		return false
+	}
+	person.Gender = gender
+	person.GenderProbability = 1
+	return true
+}
+
+// defaultNationalityConvertBatch is how many people are converted per transaction when
+// NATIONALITY_CONVERT_BATCH is unset
+const defaultNationalityConvertBatch = 500
+
+// convertNationalities rewrites every stored nationality, candidates included, into
+// ?to=alpha2 or ?to=alpha3, for when NATIONALITY_FORMAT changes. Rows are converted
+// in batches of one transaction each, logging progress, so a failure part way keeps
+// the batches already done and the conversion can simply be run again. Codes
+// missing from the ISO table are left alone and counted as skipped. Each converted
+// nationality is recorded in the person's history.
+func convertNationalities(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	format := r.URL.Query().Get("to")
+	if format != nationalityAlpha2 && format != nationalityAlpha3 {
+		respondError(w, http.StatusBadRequest, "Invalid target format, expected alpha2 or alpha3")
+		return
+	}
+	batchSize := nationalityConvertBatchSetting.get()
+
+	converted, skipped, batches := 0, 0, 0
+	var lastID uint
+	for {
+		var people []Person
+		if err := db.Unscoped().Where("id > ?", lastID).Order("id").Limit(batchSize).Find(&people).Error; err != nil {
+			respondDBError(w, err, "Failed to update people")
+			return
+		}
+		if len(people) == 0 {
+			break
+		}
+		lastID = people[len(people)-1].ID
+
+		err := writeTransaction(r.Context(), func(tx *gorm.DB) error {
+			for i := range people {
+				before := people[i]
+				changed, ok := convertPersonNationality(&people[i], format)
+				if !ok {
+					skipped++
+				}
+				if !changed {
+					continue
+				}
+				err := tx.Unscoped().Model(&people[i]).UpdateColumns(map[string]interface{}{
+					"nationality":   people[i].Nationality,
+					"nationalities": people[i].Nationalities,
+				}).Error
+				if err != nil {
+					return err
+				}
+				if err := recordHistory(tx, r, people[i].ID, "update", diffPeople(&before, &people[i])); err != nil {
+					return err
+				}
+				converted++
+			}
+			return nil
+		})
+		if err != nil {
+			respondDBError(w, err, "Failed to update people")
+			return
+		}
+
+		batches++
+		log.Printf("Nationality conversion to %s: batch %d done, %d converted, %d skipped so far", format, batches, converted, skipped)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int{"converted": converted, "skipped": skipped, "batches": batches})
+}
+
+// convertPersonNationality converts the nationality codes of person to format,
+// reporting whether anything changed and whether every code was known
+func convertPersonNationality(person *Person, format string) (changed, known bool) {
+	known = true
+	convert := func(code string) string {
+		if code == "" {
+			return code
+		}
+		converted, ok := convertCountryCode(code, format)
+		if !ok {
+			known = false
+			return code
+		}
+		if converted != code {
+			changed = true
+		}
+		return converted
+	}
+
+	person.Nationality = convert(person.Nationality)
+	for i := range person.Nationalities {
+		person.Nationalities[i].CountryID = convert(person.Nationalities[i].CountryID)
+	}
+	return changed, known
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReenrichLowConfidence(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"male","probability":0.5}`
+	})
+	person := createTestPerson(t, server, `{"name":"Sasha","surname":"Ushakova"}`)
+
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"female","probability":0.95}`
+	})
+	enrichCache.flush()
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/reenrich/low-confidence", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized re-enrichment answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/reenrich/low-confidence", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Re-enrichment answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]int
+	decode(t, data, &result)
+	if result["reenriched"] != 1 || result["failed"] != 0 {
+		t.Errorf("Got %v, want 1 re-enriched", result)
+	}
+
+	history := historyOf(t, server, person.ID)
+	if len(history) != 1 {
+		t.Fatalf("Got %d history entries, want 1: %+v", len(history), history)
+	}
+	var changes map[string]fieldChange
+	decode(t, []byte(history[0].Changes), &changes)
+	if changes["Gender"].To != "female" {
+		t.Errorf("History recorded %v, want the gender change", changes)
+	}
+}
+
+func TestConvertNationalities(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/nationality/convert?to=alpha3", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized conversion answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/nationality/convert?to=alpha3", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Conversion answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]int
+	decode(t, data, &result)
+	if result["converted"] != 1 {
+		t.Errorf("Got %v, want 1 converted", result)
+	}
+
+	history := historyOf(t, server, person.ID)
+	if len(history) != 1 {
+		t.Fatalf("Got %d history entries, want 1: %+v", len(history), history)
+	}
+	var changes map[string]fieldChange
+	decode(t, []byte(history[0].Changes), &changes)
+	if changes["Nationality"].From != "RU" || changes["Nationality"].To != "RUS" {
+		t.Errorf("History recorded %v, want RU to RUS", changes)
+	}
+}
+
+func TestCorrectPatronymicGenders(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"male","probability":0.5}`
+	})
+	// A patronymic would decide the gender during enrichment, so one that disagrees is written directly
+	person := createTestPerson(t, server, `{"name":"Sasha","surname":"Ushakova"}`)
+	db.Model(&Person{}).Where("id = ?", person.ID).UpdateColumns(map[string]interface{}{"patronymic": "Ivanovna"})
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/gender/patronymic", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized correction answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/gender/patronymic", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Correction answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]interface{}
+	decode(t, data, &result)
+	if result["corrected"] != float64(1) {
+		t.Errorf("Got %v, want 1 corrected", result)
+	}
+}
+
+func TestFlushEnrichmentCache(t *testing.T) {
+	server, stub := newTestServer(t)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/cache/flush", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized flush answered %d: %s", resp.StatusCode, data)
+	}
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Petrov"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 2 {
+		t.Errorf("Agify called %d times after a refused flush, want the cache kept", n)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/cache/flush", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Flush answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]int
+	decode(t, data, &result)
+	if result["flushed"] != 2 {
+		t.Errorf("Got %v, want 2 entries flushed", result)
+	}
+
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ivanov"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 3 {
+		t.Errorf("Agify called %d times after the flush, want the name looked up again", n)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// redacted replaces secret values in GET /admin/config
+const redacted = "[redacted]"
+
+// ProviderConfig is the effective configuration of one enrichment provider
+type ProviderConfig struct {
+	Name    string
+	Enabled bool
+	// URLs are the base URLs tried in order, with credentials and query values redacted
+	URLs    []string
+	Sources []string
+	Timeout string
+}
+
+// EffectiveConfig is the configuration the running process uses, defaults included
+type EffectiveConfig struct {
+	Settings     map[string]interface{}
+	Providers    []ProviderConfig
+	FeatureFlags map[string]bool
+}
+
+// getEffectiveConfig reports the effective configuration so operators can check
+// what is running. Secrets such as DATABASE_URL and ADMIN_TOKEN are redacted.
+func getEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	config := EffectiveConfig{
+		Settings:     map[string]interface{}{},
+		FeatureFlags: featureFlags,
+	}
+	for _, s := range settings {
+		config.Settings[s.key()] = s.report()
+	}
+
+	for _, p := range enrichmentProviders {
+		pc := ProviderConfig{Name: p.Name, Enabled: p.enabled(), Sources: p.sourceFields(), Timeout: p.timeout().String()}
+		for _, u := range p.baseURLs() {
+			pc.URLs = append(pc.URLs, redactURL(u))
+		}
+		config.Providers = append(config.Providers, pc)
+	}
+
+	respondJSON(w, http.StatusOK, config)
+}
+
+// redactURL hides the password and query values of raw, where API keys usually live
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	query := u.Query()
+	for key := range query {
+		query.Set(key, "redacted")
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEffectiveConfigReportsEverySetting(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("ENRICH_CACHE_SIZE", "25")
+	t.Setenv("FEATURE_FLAGS", "-reenrich")
+	t.Setenv("GENDERIZE_SOURCES", "Patronymic, name")
+	t.Setenv("GENDERIZE_TIMEOUT", "3s")
+
+	if resp, data := call(t, server, http.MethodGet, "/admin/config", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("GET /admin/config without a token answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodGet, "/admin/config", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/config answered %d: %s", resp.StatusCode, data)
+	}
+	var config EffectiveConfig
+	decode(t, data, &config)
+
+	if len(config.Settings) != len(settings) {
+		t.Errorf("Reported %d settings, %d are defined", len(config.Settings), len(settings))
+	}
+	for _, key := range []string{"DB_PASSWORD", "ADMIN_TOKEN"} {
+		if got := config.Settings[key]; got != redacted {
+			t.Errorf("%s = %v, want it redacted", key, got)
+		}
+	}
+	if got := config.Settings["DATABASE_URL"]; got != "" {
+		t.Errorf("Unset DATABASE_URL = %v, want empty", got)
+	}
+	if got := config.Settings["ENRICH_CACHE_SIZE"]; got != float64(25) {
+		t.Errorf("ENRICH_CACHE_SIZE = %v, want 25", got)
+	}
+	if got := config.Settings["MAX_BATCH_SIZE"]; got != float64(defaultMaxBatchSize) {
+		t.Errorf("MAX_BATCH_SIZE = %v, want the default %d", got, defaultMaxBatchSize)
+	}
+	if got := config.Settings["FEATURE_FLAGS"]; got != "-reenrich" {
+		t.Errorf("FEATURE_FLAGS = %v, want its raw value", got)
+	}
+
+	for _, p := range config.Providers {
+		if p.Name != genderizeProvider.Name {
+			continue
+		}
+		if p.Timeout != "3s" || len(p.Sources) != 2 || p.Sources[0] != "patronymic" {
+			t.Errorf("Genderize reported %+v, want its timeout and sources", p)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "strconv"
+
+// unknownAgeNull reports whether unknown ages are null (UNKNOWN_AGE=null) rather
+// than 0 (UNKNOWN_AGE=zero, the default). With null, a stored 0 is a real age,
+// such as an infant set by an override; rows written before the switch keep
+// the 0 they were stored with.
+func unknownAgeNull() bool {
+	return unknownAgeSetting.get() == "null"
+}
+
+// BeforeSave stores an unknown age as 0 unless unknown ages are null
+func (p *Person) BeforeSave() error {
+	if p.Age == nil && !unknownAgeNull() {
+		p.Age = intPtr(0)
+	}
+	return nil
+}
+
+// reportAge reports a null age as 0 unless unknown ages are null; see AfterFind
+func (p *Person) reportAge() {
+	if p.Age == nil && !unknownAgeNull() {
+		p.Age = intPtr(0)
+	}
+}
+
+// age is the enriched age, or the unknown age when Agify didn't know the name
+func (e enrichment) age() *int {
+	if e.AgeKnown {
+		return intPtr(e.Age)
+	}
+	if unknownAgeNull() {
+		return nil
+	}
+	return intPtr(0)
+}
+
+// unknownAgeCondition is the SQL condition matching people whose age is unknown
+func unknownAgeCondition() string {
+	if unknownAgeNull() {
+		return "age IS NULL"
+	}
+	return "(age = 0 OR age IS NULL)"
+}
+
+// ageValue dereferences age for comparisons and history, keeping nil as nil
+func ageValue(age *int) interface{} {
+	if age == nil {
+		return nil
+	}
+	return *age
+}
+
+// formatAge renders age for CSV, leaving unknown ages empty when they are null
+func formatAge(age *int) string {
+	if age == nil {
+		if unknownAgeNull() {
+			return ""
+		}
+		return "0"
+	}
+	return strconv.Itoa(*age)
+}
+
+func intPtr(v int) *int {
+	return &v
+}
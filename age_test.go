@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnknownAgeNullOrZero(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answer(agifyProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"age":null}`
+	})
+
+	for mode, want := range map[string]struct {
+		json, csv string
+		stored    sql.NullInt64
+	}{
+		"":     {`"Age":0`, "0", sql.NullInt64{Valid: true}},
+		"zero": {`"Age":0`, "0", sql.NullInt64{Valid: true}},
+		"null": {`"Age":null`, "", sql.NullInt64{}},
+	} {
+		t.Setenv("UNKNOWN_AGE", mode)
+		db.Unscoped().Delete(&Person{})
+
+		resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Zyxa"}`)
+		if resp.StatusCode != http.StatusCreated || !strings.Contains(string(data), want.json) {
+			t.Errorf("UNKNOWN_AGE=%q: create answered %d: %s, want %s", mode, resp.StatusCode, data, want.json)
+			continue
+		}
+		var person Person
+		decode(t, data, &person)
+
+		if _, data := call(t, server, http.MethodGet, fmt.Sprintf("/people/%d", person.ID), ""); !strings.Contains(string(data), want.json) {
+			t.Errorf("UNKNOWN_AGE=%q: GET answered %s, want %s", mode, data, want.json)
+		}
+		var stored sql.NullInt64
+		db.DB().QueryRow("SELECT age FROM people WHERE id = ?", person.ID).Scan(&stored)
+		if stored != want.stored {
+			t.Errorf("UNKNOWN_AGE=%q: stored age %+v, want %+v", mode, stored, want.stored)
+		}
+
+		_, data = call(t, server, http.MethodGet, "/people/export", "")
+		rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil || len(rows) != 2 {
+			t.Errorf("UNKNOWN_AGE=%q: export = %v (%v), want one person", mode, rows, err)
+			continue
+		}
+		for i, column := range rows[0] {
+			if strings.EqualFold(column, "age") && rows[1][i] != want.csv {
+				t.Errorf("UNKNOWN_AGE=%q: exported age %q, want %q", mode, rows[1][i], want.csv)
+			}
+		}
+	}
+}
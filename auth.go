@@ -0,0 +1,19 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// isAdmin reports whether r presents ADMIN_TOKEN as a bearer token.
+// Without an ADMIN_TOKEN configured no request is an admin.
+func isAdmin(r *http.Request) bool {
+	token := adminTokenSetting.get()
+	if token == "" {
+		return false
+	}
+
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
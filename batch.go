@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultMaxBatchSize caps the items of one batch request when MAX_BATCH_SIZE is unset
+const defaultMaxBatchSize = 100
+
+// batchTooLarge answers 413 when a batch has more than MAX_BATCH_SIZE items, so
+// one request can't hold the enrichment workers for everyone else
+func batchTooLarge(w http.ResponseWriter, size int) bool {
+	max := maxBatchSizeSetting.get()
+	if size <= max {
+		return false
+	}
+	respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("A batch can hold at most %d items, got %d", max, size))
+	return true
+}
+
+// batchCanceled is the error of the items a batch never got to because its request ended
+const batchCanceled = "canceled"
+
+// BatchItemResult reports the outcome of one item of a batch create
+type BatchItemResult struct {
+	Index  int
+	Status int
+	ID     uint `json:",omitempty"`
+	// PublicID is the ID clients address the created person by under ID_STRATEGY uuid or ulid
+	PublicID *string `json:",omitempty"`
+	Error    string  `json:",omitempty"`
+	// RetryAfter is how many seconds to wait before retrying an item refused for exhausted quota
+	RetryAfter int `json:",omitempty"`
+}
+
+// createPeopleBatch creates each person independently, answering 207 Multi-Status when any item failed
+func createPeopleBatch(w http.ResponseWriter, r *http.Request) {
+	var inputs []PersonInput
+	if !decodeJSONBody(w, r, &inputs) || batchTooLarge(w, len(inputs)) {
+		return
+	}
+	people := make([]Person, len(inputs))
+	for i, input := range inputs {
+		people[i] = input.person()
+	}
+
+	results := make([]BatchItemResult, len(people))
+	cache := newBatchEnrichments()
+	errs := runBoundedContext(r.Context(), len(people), func(i int) error {
+		results[i] = createBatchItem(withBatchItem(r.Context(), i), cache, i, &people[i])
+		return nil
+	})
+	// Once the request is canceled or past its deadline no more items are started
+	for i, err := range errs {
+		if err != nil {
+			results[i] = BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Error: batchCanceled}
+		}
+	}
+
+	failed := false
+	for _, result := range results {
+		if result.Status != http.StatusCreated {
+			failed = true
+		}
+	}
+
+	status := http.StatusCreated
+	if failed {
+		status = http.StatusMultiStatus
+	}
+	respondJSON(w, status, results)
+}
+
+// createBatchItem creates one person of a batch through createEnrichedPerson, like
+// POST /people, so each item is warned about, rejected and recorded the same way
+func createBatchItem(ctx context.Context, cache *batchEnrichments, index int, person *Person) BatchItemResult {
+	result := BatchItemResult{Index: index}
+
+	if err := validatePerson(person); err != nil {
+		result.Status = statusFor(err)
+		result.Error = err.Error()
+		return result
+	}
+	warnOnDuplicateCreate(person, requestIDFromContext(ctx))
+
+	if err := createEnrichedPerson(person, cache.get(ctx, inputOf(person))); err != nil {
+		var quota quotaRetryError
+		switch {
+		case errors.As(err, &quota):
+			result.Status = http.StatusServiceUnavailable
+			result.RetryAfter = quota.seconds()
+			result.Error = quota.Error()
+		case statusFor(err) == http.StatusInternalServerError:
+			log.Printf("%s Error creating batch item: %v", logPrefix(ctx), err)
+			result.Status = http.StatusInternalServerError
+			result.Error = "Failed to create person"
+		default:
+			result.Status = statusFor(err)
+			result.Error = err.Error()
+		}
+		return result
+	}
+
+	result.Status = http.StatusCreated
+	result.ID = person.ID
+	result.PublicID = person.PublicID
+	return result
+}
+
+// batchEnrichments remembers the enrichment of each distinct name within one batch,
+// so a name repeated across items is looked up once even when nothing else caches
+type batchEnrichments struct {
+	sync.Mutex
+	entries map[string]*batchEnrichment
+}
+
+type batchEnrichment struct {
+	once sync.Once
+	e    enrichment
+}
+
+func newBatchEnrichments() *batchEnrichments {
+	return &batchEnrichments{entries: map[string]*batchEnrichment{}}
+}
+
+// get returns the enrichment of in, looking it up on first use. Inputs share an
+// entry when the fields the providers are fed match once normalized for case and
+// surrounding space, which the providers ignore too, so by default two Dmitriys
+// with different surnames are looked up once.
+func (c *batchEnrichments) get(ctx context.Context, in enrichmentInput) enrichment {
+	key := strings.ToLower(enrichmentInput{
+		Name:       strings.TrimSpace(in.Name),
+		Surname:    strings.TrimSpace(in.Surname),
+		Patronymic: strings.TrimSpace(in.Patronymic),
+		Country:    in.Country,
+	}.key())
+
+	c.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &batchEnrichment{}
+		c.entries[key] = entry
+	}
+	c.Unlock()
+
+	entry.once.Do(func() { entry.e = getEnrichedData(ctx, in) })
+	return entry.e
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// createBatch posts a batch create and returns the HTTP status with the item results
+func createBatch(t testing.TB, server *httptest.Server, body string) (int, []BatchItemResult) {
+	t.Helper()
+
+	resp, data := call(t, server, http.MethodPost, "/people/batch", body)
+	var results []BatchItemResult
+	decode(t, data, &results)
+	return resp.StatusCode, results
+}
+
+func TestBatchCreateSharesCreatePath(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("REQUIRED_PROVIDERS", agifyProvider.Name)
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		if name == "Limited" {
+			return http.StatusTooManyRequests, `{"error":"Request limit reached"}`
+		}
+		return http.StatusOK, `{"age":42}`
+	})
+	stub.fail(nationalizeProvider.Name)
+
+	status, results := createBatch(t, server, `[{"name":"Dmitriy"},{"name":"Limited"}]`)
+	if status != http.StatusMultiStatus || len(results) != 2 {
+		t.Fatalf("Batch answered %d: %+v", status, results)
+	}
+
+	// An optional provider failing stores the person and records the failure, as POST /people does
+	if results[0].Status != http.StatusCreated {
+		t.Errorf("Item 0 = %+v, want created without a nationality", results[0])
+	}
+	var failures []EnrichmentFailure
+	db.Where("person_id = ?", results[0].ID).Find(&failures)
+	if len(failures) != 1 {
+		t.Errorf("Item 0 has %d enrichment failures recorded, want 1", len(failures))
+	}
+
+	if results[1].Status != http.StatusServiceUnavailable || results[1].RetryAfter <= 0 {
+		t.Errorf("Item 1 = %+v, want 503 with a retry delay", results[1])
+	}
+}
+
+func TestBatchCreatePartialFailure(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("REQUIRED_PROVIDERS", genderizeProvider.Name)
+	stub.answer(genderizeProvider.Name, func(name string) (int, string) {
+		if name == "Broken" {
+			return http.StatusInternalServerError, `{"error":"stubbed failure"}`
+		}
+		return http.StatusOK, `{"gender":"male","probability":0.99}`
+	})
+
+	status, results := createBatch(t, server, `[{"name":"Dmitriy"},{"name":"Broken"},{"surname":"Nameless"},{"name":"Ivan"}]`)
+	if status != http.StatusMultiStatus {
+		t.Fatalf("Batch answered %d, want 207: %+v", status, results)
+	}
+
+	want := []int{http.StatusCreated, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusCreated}
+	if len(results) != len(want) {
+		t.Fatalf("Got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for i, result := range results {
+		if result.Index != i || result.Status != want[i] {
+			t.Errorf("Item %d = %+v, want status %d", i, result, want[i])
+		}
+		if created := result.Status == http.StatusCreated; created != (result.ID != 0) || created == (result.Error != "") {
+			t.Errorf("Item %d = %+v, want an ID only when created and an error otherwise", i, result)
+		}
+	}
+
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored != 2 {
+		t.Errorf("%d people stored, want the 2 created items", stored)
+	}
+}
+
+func TestBatchCreateAllSucceed(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	status, results := createBatch(t, server, `[{"name":"Dmitriy"},{"name":"Ivan"}]`)
+	if status != http.StatusCreated {
+		t.Errorf("Batch answered %d, want 201: %+v", status, results)
+	}
+}
+
+func TestBatchLooksUpEachNameOnce(t *testing.T) {
+	server, stub := newTestServer(t)
+	// Without a cache only the batch itself can keep repeated names from calling out
+	enrichCache = noCache{}
+
+	status, results := createBatch(t, server, `[{"name":"Dmitriy","surname":"Ushakov"},{"name":" dmitriy ","surname":"Petrov"},{"name":"Ivan"},{"name":"Dmitriy"},{"name":"Ivan","surname":"Ivanov"}]`)
+	if status != http.StatusCreated || len(results) != 5 {
+		t.Fatalf("Batch answered %d: %+v", status, results)
+	}
+	for _, result := range results {
+		if result.Status != http.StatusCreated {
+			t.Errorf("Item %d = %+v, want created", result.Index, result)
+		}
+	}
+
+	for _, p := range enrichmentProviders {
+		if n := stub.callCount(p.Name); n != 2 {
+			t.Errorf("%s called %d times for 2 unique names, want 2", p.Name, n)
+		}
+	}
+}
+
+func TestBatchSizeLimit(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("MAX_BATCH_SIZE", "2")
+
+	if status, results := createBatch(t, server, `[{"name":"Dmitriy"},{"name":"Ivan"}]`); status != http.StatusCreated {
+		t.Errorf("Batch at the limit answered %d: %+v", status, results)
+	}
+	if resp, data := call(t, server, http.MethodPost, "/enrich/batch", `["Dmitriy","Ivan"]`); resp.StatusCode != http.StatusOK {
+		t.Errorf("Enrich batch at the limit answered %d: %s", resp.StatusCode, data)
+	}
+	calls := stub.callCount(agifyProvider.Name)
+
+	for path, body := range map[string]string{
+		"/people/batch": `[{"name":"Dmitriy"},{"name":"Ivan"},{"name":"Petr"}]`,
+		"/enrich/batch": `["Dmitriy","Ivan","Petr"]`,
+	} {
+		resp, data := call(t, server, http.MethodPost, path, body)
+		if resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(string(data), "at most 2") {
+			t.Errorf("POST %s over the limit answered %d: %s", path, resp.StatusCode, data)
+		}
+	}
+	if n := stub.callCount(agifyProvider.Name); n != calls {
+		t.Errorf("Agify called %d more times for rejected batches, want none", n-calls)
+	}
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored != 2 {
+		t.Errorf("%d people stored, want only the accepted batch's 2", stored)
+	}
+}
+
+func TestBatchItemLogsCarryRequestAndIndex(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		if name == "Ivan" {
+			return http.StatusInternalServerError, `{"error":"stubbed failure"}`
+		}
+		return http.StatusOK, `{"age":42}`
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	resp, data := call(t, server, http.MethodPost, "/people/batch", `[{"name":"Dmitriy"},{"name":"Ivan"},{"name":"Petr"}]`, "X-Request-ID", "batch-1")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Batch answered %d: %s", resp.StatusCode, data)
+	}
+
+	failures := 0
+	for _, line := range strings.Split(logged.String(), "\n") {
+		if strings.Contains(line, "Error fetching agify data") {
+			failures++
+			if !strings.Contains(line, "[batch-1/1] ") {
+				t.Errorf("Failure logged as %q, want it tied to request batch-1, item 1", line)
+			}
+		}
+	}
+	if failures != 1 {
+		t.Errorf("Logged %d agify failures, want 1:\n%s", failures, logged.String())
+	}
+}
+
+func TestCanceledBatchReportsRemainingItems(t *testing.T) {
+	_, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("ENRICH_CONCURRENCY", "1")
+
+	for _, tc := range []struct {
+		path, body string
+	}{
+		{"/people/batch", `[{"name":"Dmitriy"},{"name":"Ivan"},{"name":"Petr"}]`},
+		{"/enrich/batch", `["Dmitriy","Ivan","Petr"]`},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		started := make(chan struct{}, 3)
+		stub.answer(agifyProvider.Name, func(string) (int, string) {
+			started <- struct{}{}
+			// The first item is still in flight when the client goes away
+			<-ctx.Done()
+			return http.StatusOK, `{"age":42}`
+		})
+		go func() {
+			<-started
+			cancel()
+		}()
+
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, req)
+
+		if len(started) != 0 {
+			t.Errorf("POST %s called Agify again after the request was canceled", tc.path)
+		}
+		var items []struct {
+			Status int
+			Error  string
+		}
+		decode(t, w.Body.Bytes(), &items)
+		if len(items) != 3 {
+			t.Fatalf("POST %s answered %d: %s", tc.path, w.Code, w.Body)
+		}
+		if items[0].Error == batchCanceled {
+			t.Errorf("POST %s reported the item in flight canceled: %s", tc.path, w.Body)
+		}
+		for _, item := range items[1:] {
+			if item.Error != batchCanceled {
+				t.Errorf("POST %s answered %s, want the items never started canceled", tc.path, w.Body)
+				break
+			}
+		}
+		if tc.path == "/people/batch" && (w.Code != http.StatusMultiStatus || items[1].Status != http.StatusServiceUnavailable) {
+			t.Errorf("Canceled batch create answered %d: %s, want 207 with the remaining items 503", w.Code, w.Body)
+		}
+		cancel()
+	}
+
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored > 1 {
+		t.Errorf("%d people stored by a canceled batch, want at most the item in flight", stored)
+	}
+}
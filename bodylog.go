@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// defaultLogBodyLimit is how many bytes of each body are logged when LOG_BODY_LIMIT is unset
+const defaultLogBodyLimit = 2048
+
+// bodyRecorder keeps the start of the response body while passing it through
+type bodyRecorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *bodyRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	room := w.limit - w.body.Len()
+	if room < 0 {
+		room = 0
+	}
+	if len(b) > room {
+		w.truncated = true
+	} else {
+		room = len(b)
+	}
+	w.body.Write(b[:room])
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (w *bodyRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logBodies logs request and response bodies when LOG_BODIES=true, a local debugging
+// aid that is off by default. Only the first LOG_BODY_LIMIT bytes of a body are read
+// for the log, the handler getting them back ahead of the rest, and JSON fields named
+// in LOG_REDACT_FIELDS (comma-separated, case-insensitive) are masked.
+func logBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logBodiesSetting.get() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := logBodyLimitSetting.get()
+		if limit < 0 {
+			limit = 0
+		}
+
+		if r.Body != nil {
+			// One byte past the limit tells a truncated body from one that fits exactly
+			head, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+			if err != nil {
+				log.Printf("[%s] Error reading request body for logging: %v", requestID(r), err)
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+
+			if len(head) > 0 {
+				truncated := len(head) > limit
+				if truncated {
+					head = head[:limit]
+				}
+				log.Printf("[%s] %s %s request body: %s", requestID(r), r.Method, r.URL.Path, loggableBody(head, truncated))
+			}
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: w, status: http.StatusOK, limit: limit}
+		next.ServeHTTP(recorder, r)
+		log.Printf("[%s] %s %s response %d body: %s", requestID(r), r.Method, r.URL.Path, recorder.status, loggableBody(recorder.body.Bytes(), recorder.truncated))
+	})
+}
+
+// loggableBody masks the redacted fields of a JSON body, the first bytes of which
+// are body when truncated. Fields can't be masked in a body that doesn't parse as
+// JSON, truncated ones included, so in privacy mode such a body is withheld.
+func loggableBody(body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	parsed := !truncated && json.Unmarshal(body, &v) == nil
+	if !parsed && privacyMode() {
+		return "(withheld in privacy mode, not complete JSON)"
+	}
+
+	if fields := redactedFields(); len(fields) > 0 && parsed {
+		if masked, err := json.Marshal(maskFields(v, fields)); err == nil {
+			body = masked
+		}
+	}
+
+	if truncated {
+		return string(body) + "...(truncated)"
+	}
+	return string(body)
+}
+
+func redactedFields() map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range strings.Split(logRedactFieldsSetting.get(), ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			fields[field] = true
+		}
+	}
+	if privacyMode() {
+		// Names are the personal data privacy mode keeps out of logs
+		for _, field := range []string{"name", "surname", "patronymic"} {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// maskFields replaces the values of the named object keys anywhere in v
+func maskFields(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if fields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = maskFields(value, fields)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskFields(v[i], fields)
+		}
+	}
+	return v
+}
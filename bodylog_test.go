@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// logBodiesOf serves handler behind logBodies for one request and returns what was
+// logged along with the request body the handler read
+func logBodiesOf(t *testing.T, body string, handler func(w http.ResponseWriter, body []byte)) (logged, read string) {
+	t.Helper()
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	logBodies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Error reading the replayed body: %v", err)
+		}
+		read = string(b)
+		handler(w, b)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/people", strings.NewReader(body)))
+	return out.String(), read
+}
+
+func TestLoggedBodiesMaskRedactedFields(t *testing.T) {
+	body := `{"name":"Dmitriy","Password":"hunter2","nested":[{"token":"abc123"}]}`
+	echo := func(w http.ResponseWriter, b []byte) { w.Write(b) }
+
+	if logged, _ := logBodiesOf(t, body, echo); logged != "" {
+		t.Errorf("Logged with LOG_BODIES unset:\n%s", logged)
+	}
+
+	t.Setenv("LOG_BODIES", "true")
+	logged, read := logBodiesOf(t, body, echo)
+	if read != body {
+		t.Errorf("Handler read %q, want the body unmasked", read)
+	}
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, "abc123") || strings.Count(logged, redacted) != 4 {
+		t.Errorf("Logged:\n%s\nwant the password and token masked in both bodies", logged)
+	}
+	if strings.Count(logged, "Dmitriy") != 2 {
+		t.Errorf("Logged:\n%s\nwant the name kept outside privacy mode", logged)
+	}
+
+	t.Setenv("LOG_REDACT_FIELDS", "name")
+	if logged, _ := logBodiesOf(t, body, echo); strings.Contains(logged, "Dmitriy") || !strings.Contains(logged, "hunter2") {
+		t.Errorf("Logged:\n%s\nwant only the configured field masked", logged)
+	}
+}
+
+func TestLoggedBodiesCutToLimit(t *testing.T) {
+	t.Setenv("LOG_BODIES", "true")
+	t.Setenv("LOG_BODY_LIMIT", "16")
+
+	body := `{"name":"Dmitriy","surname":"Ushakov"}`
+	logged, read := logBodiesOf(t, body, func(w http.ResponseWriter, b []byte) { w.Write(b) })
+	if read != body {
+		t.Errorf("Handler read %q, want the whole body %q", read, body)
+	}
+	if want := body[:16] + "...(truncated)"; strings.Count(logged, want) != 2 {
+		t.Errorf("Logged:\n%s\nwant both bodies cut to %q", logged, want)
+	}
+}
+
+func TestPrivacyModeWithholdsUnmaskableBodies(t *testing.T) {
+	t.Setenv("LOG_BODIES", "true")
+	t.Setenv("PRIVACY_MODE", "true")
+	ignore := func(http.ResponseWriter, []byte) {}
+
+	logged, _ := logBodiesOf(t, `{"name":"Dmitriy","surname":"Ushakov"}`, ignore)
+	if strings.Contains(logged, "Dmitriy") || !strings.Contains(logged, redacted) {
+		t.Errorf("Logged:\n%s\nwant the names masked", logged)
+	}
+
+	// Neither a CSV upload nor JSON cut short parses, so their names can't be masked
+	logged, _ = logBodiesOf(t, "name,surname\nDmitriy,Ushakov", ignore)
+	if strings.Contains(logged, "Dmitriy") || !strings.Contains(logged, "withheld") {
+		t.Errorf("Logged a CSV body in privacy mode:\n%s", logged)
+	}
+	t.Setenv("LOG_BODY_LIMIT", "16")
+	logged, _ = logBodiesOf(t, `{"name":"Dmitriy","surname":"Ushakov"}`, ignore)
+	if strings.Contains(logged, "Dmitriy") || !strings.Contains(logged, "withheld") {
+		t.Errorf("Logged a truncated body in privacy mode:\n%s", logged)
+	}
+}
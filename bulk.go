@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// bulkUpdatableColumns maps the fields accepted by bulk update to their columns
+var bulkUpdatableColumns = map[string]string{
+	"nationality": "nationality",
+	"gender":      "gender",
+	"age":         "age",
+}
+
+// bulkUpdatePeople applies the fields in the body to every person matching the list
+// filters in the query string. Without any filter the request must also pass confirm=true.
+// The people are changed by one UPDATE, and their history by one INSERT ... SELECT,
+// in a single transaction. Like an override, the change marks them ManualOverride
+// so re-enrichment doesn't revert it.
+func bulkUpdatePeople(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	confirmed, _ := strconv.ParseBool(r.URL.Query().Get("confirm"))
+	if !hasPeopleFilters(r) && !confirmed {
+		respondError(w, http.StatusBadRequest, "Updating every person requires confirm=true")
+		return
+	}
+
+	var fields map[string]interface{}
+	if !decodeJSONBody(w, r, &fields) {
+		return
+	}
+
+	updates, err := bulkUpdates(fields)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	changes := bulkChanges(updates)
+
+	// Reject bad filters up front so errors inside the transaction are database failures
+	if _, err := applyPeopleFilters(db, r); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var affected int64
+	err = writeTransaction(r.Context(), func(tx *gorm.DB) error {
+		query, err := applyPeopleFilters(tx, r)
+		if err != nil {
+			return err
+		}
+
+		// History first, while the rows still hold the values being replaced
+		diffs := bulkHistoryDiffs(tx.Dialect().GetName(), query, changes)
+		err = tx.Exec("INSERT INTO person_histories (created_at, person_id, action, changed_by, changes) "+
+			"SELECT ?, id, 'update', ?, changes FROM (?) AS diffs WHERE changes <> '{}'",
+			time.Now(), requestActor(r), diffs).Error
+		if err != nil {
+			return err
+		}
+
+		columns := map[string]interface{}{"updated_at": time.Now()}
+		for _, c := range changes {
+			columns[c.column] = c.value
+		}
+		if nationality, ok := updates["nationality"].(string); ok {
+			// The candidates would otherwise still name the replaced nationality
+			columns["nationalities"] = NationalityCandidates{}
+			if nationality != "" {
+				columns["nationalities"] = NationalityCandidates{{CountryID: nationality, Probability: 1}}
+			}
+		}
+		update := query.Model(&Person{}).UpdateColumns(columns)
+		affected = update.RowsAffected
+		return update.Error
+	})
+	if err != nil {
+		respondServiceError(w, err, "Failed to update people")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]int64{"updated": affected})
+}
+
+// bulkChange is one audited column a bulk update sets, with the SQL type its value is sent as
+type bulkChange struct {
+	field   string
+	column  string
+	value   interface{}
+	sqlType string
+}
+
+// bulkChanges lists the audited columns set by updates: the requested ones in a
+// fixed order, and those an override sets along with them
+func bulkChanges(updates map[string]interface{}) []bulkChange {
+	var changes []bulkChange
+	if age, ok := updates["age"]; ok {
+		changes = append(changes, bulkChange{"Age", "age", age, "integer"})
+	}
+	if gender, ok := updates["gender"]; ok {
+		changes = append(changes,
+			bulkChange{"Gender", "gender", gender, "text"},
+			bulkChange{"GenderProbability", "gender_probability", 1.0, "double precision"})
+	}
+	if nationality, ok := updates["nationality"]; ok {
+		changes = append(changes, bulkChange{"Nationality", "nationality", nationality, "text"})
+	}
+	return append(changes, bulkChange{"ManualOverride", "manual_override", true, "boolean"})
+}
+
+// bulkHistoryDiffs selects the id of each person matched by query with the JSON
+// object of its changes, as diffPeople would record them: each changed column with
+// its old and new value. People the update leaves as they are get "{}".
+func bulkHistoryDiffs(dialect string, query *gorm.DB, changes []bulkChange) interface{} {
+	var pieces []string
+	var args []interface{}
+	for _, c := range changes {
+		to := "CAST(? AS " + c.sqlType + ")"
+		var object string
+		if dialect == "postgres" {
+			object = fmt.Sprintf("json_build_object('From', %s, 'To', %s)::text", c.column, to)
+		} else {
+			from := c.column
+			if c.sqlType == "boolean" {
+				// SQLite stores booleans as integers, which JSON would report as numbers
+				from = fmt.Sprintf("json(CASE WHEN %s THEN 'true' ELSE 'false' END)", c.column)
+				to = "json(CASE WHEN ? THEN 'true' ELSE 'false' END)"
+			}
+			object = fmt.Sprintf("json_object('From', %s, 'To', %s)", from, to)
+		}
+		pieces = append(pieces, fmt.Sprintf(`CASE WHEN %s IS DISTINCT FROM CAST(? AS %s) THEN '"%s":' || %s || ',' ELSE '' END`, c.column, c.sqlType, c.field, object))
+		args = append(args, c.value, c.value)
+	}
+
+	changed := "rtrim(" + strings.Join(pieces, " || ") + ", ',')"
+	if dialect == "postgres" {
+		changed = "TRIM(TRAILING ',' FROM " + strings.Join(pieces, " || ") + ")"
+	}
+	return query.Model(&Person{}).Select("id, '{' || "+changed+" || '}' AS changes", args...).QueryExpr()
+}
+
+// bulkUpdates converts the requested fields into column updates, rejecting unknown fields and bad values
+func bulkUpdates(fields map[string]interface{}) (map[string]interface{}, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("No fields to update")
+	}
+
+	updates := map[string]interface{}{}
+	for field, value := range fields {
+		column, ok := bulkUpdatableColumns[strings.ToLower(field)]
+		if !ok {
+			return nil, fmt.Errorf("Field %s cannot be bulk updated", field)
+		}
+
+		switch column {
+		case "age":
+			age, ok := value.(float64)
+			if !ok || age < 0 || age > 150 || age != float64(int(age)) {
+				return nil, fmt.Errorf("Age must be an integer between 0 and 150")
+			}
+			updates[column] = int(age)
+		case "nationality":
+			raw, ok := value.(string)
+			code, valid := storedCountryCode(raw)
+			if !ok || !valid {
+				return nil, fmt.Errorf("Nationality must be an ISO 3166-1 country code")
+			}
+			updates[column] = checkKnownCountryCode("bulk update", code)
+		case "gender":
+			gender, ok := value.(string)
+			gender = strings.ToLower(gender)
+			if !ok || gender != "male" && gender != "female" && gender != "" {
+				return nil, fmt.Errorf("Gender must be male or female")
+			}
+			updates[column] = gender
+		}
+	}
+	return updates, nil
+}
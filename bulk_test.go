@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBulkUpdatePeople(t *testing.T) {
+	server, _ := newTestServer(t)
+	first := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	second := createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+
+	if resp, data := call(t, server, http.MethodPost, "/people/bulk-update?confirm=true", `{"nationality":"KZ"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized bulk update answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/people/bulk-update?confirm=true", `{"nationality":"KZ"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Bulk update answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]int
+	decode(t, data, &result)
+	if result["updated"] != 2 {
+		t.Errorf("Got %v, want 2 updated", result)
+	}
+
+	for _, person := range []Person{first, second} {
+		history := historyOf(t, server, person.ID)
+		if len(history) != 1 {
+			t.Fatalf("Person %d has %d history entries, want 1", person.ID, len(history))
+		}
+		var changes map[string]fieldChange
+		decode(t, []byte(history[0].Changes), &changes)
+		if changes["Nationality"].To != "KZ" {
+			t.Errorf("Person %d history recorded %v, want the nationality change", person.ID, changes)
+		}
+	}
+}
+
+func TestBulkUpdateMarksOverrides(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	legacy := createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+	// A row stored before validation tightened doesn't block the update
+	db.Model(&Person{}).Where("id = ?", legacy.ID).UpdateColumn("name", "R2D2")
+
+	if resp, data := adminCall(t, server, http.MethodPost, "/people/bulk-update?confirm=true", `{"gender":"robot"}`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Invalid gender answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/people/bulk-update?confirm=true", `{"nationality":"KZ","gender":"female"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Bulk update answered %d: %s", resp.StatusCode, data)
+	}
+
+	for _, id := range []uint{person.ID, legacy.ID} {
+		var stored Person
+		db.First(&stored, id)
+		if stored.Nationality != "KZ" || stored.Gender != "female" || stored.GenderProbability != 1 || !stored.ManualOverride {
+			t.Errorf("Person %d stored %+v, want a KZ female override", id, stored)
+		}
+		if len(stored.Nationalities) != 1 || stored.Nationalities[0].CountryID != "KZ" {
+			t.Errorf("Person %d kept candidates %v, want only KZ", id, stored.Nationalities)
+		}
+
+		history := historyOf(t, server, id)
+		var changes map[string]fieldChange
+		decode(t, []byte(history[0].Changes), &changes)
+		if changes["Gender"].From != "male" || changes["GenderProbability"].To != 1.0 || changes["ManualOverride"].To != true {
+			t.Errorf("Person %d history recorded %v", id, changes)
+		}
+	}
+
+	// Nothing changes the second time, so nothing more is recorded
+	adminCall(t, server, http.MethodPost, "/people/bulk-update?confirm=true", `{"nationality":"KZ","gender":"female"}`)
+	if history := historyOf(t, server, person.ID); len(history) != 1 {
+		t.Errorf("A repeated update left %d history entries, want 1", len(history))
+	}
+}
+
+func TestBulkUpdateNeedsConfirmWithoutFilters(t *testing.T) {
+	server, _ := newTestServer(t)
+	russian := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	other := createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+	db.Model(&Person{}).Where("id = ?", other.ID).UpdateColumn("nationality", "UA")
+
+	if resp, data := adminCall(t, server, http.MethodPost, "/people/bulk-update", `{"nationality":"KZ"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Unconfirmed update of everyone answered %d: %s", resp.StatusCode, data)
+	}
+	var stored Person
+	db.First(&stored, russian.ID)
+	if stored.Nationality != "RU" {
+		t.Errorf("Nationality = %q after a rejected update, want it unchanged", stored.Nationality)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/people/bulk-update?nationality=UA", `{"age":30}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Filtered update answered %d: %s", resp.StatusCode, data)
+	}
+	var result map[string]int
+	decode(t, data, &result)
+	if result["updated"] != 1 {
+		t.Errorf("Got %v, want only the filtered person updated", result)
+	}
+	db.First(&stored, russian.ID)
+	if stored.Age == nil || *stored.Age != 42 {
+		t.Errorf("Unfiltered person's age = %v, want it unchanged", stored.Age)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultEnrichCacheTTL is how long an enrichment is reused when ENRICH_CACHE_TTL is unset
+const defaultEnrichCacheTTL = time.Hour
+
+// defaultEnrichCacheSize bounds the in-memory cache when ENRICH_CACHE_SIZE is unset
+const defaultEnrichCacheSize = 10000
+
+// redisFlushBatch is how many keys a flush scans and deletes per Redis call
+const redisFlushBatch = 500
+
+// redisTimeout bounds each cache call, so a slow Redis costs a lookup at most this much
+const redisTimeout = 200 * time.Millisecond
+
+// enrichmentCache stores complete enrichments by input key. Backends treat
+// their own failures as misses: a broken cache must never fail a request.
+type enrichmentCache interface {
+	get(key string) (enrichment, bool)
+	set(key string, e enrichment)
+	// flush drops every entry, returning how many there were
+	flush() (int, error)
+}
+
+// enrichCache is the cache getEnrichedData consults, in memory until loadEnrichmentCache runs
+var enrichCache enrichmentCache = newMemoryCache(defaultEnrichCacheSize)
+
+// loadEnrichmentCache selects the backend from ENRICH_CACHE: memory (default),
+// redis (at REDIS_URL, shared between instances) or none
+func loadEnrichmentCache() {
+	switch backend := strings.ToLower(enrichCacheSetting.get()); backend {
+	case "memory":
+		enrichCache = newMemoryCache(enrichCacheSizeSetting.get())
+	case "redis":
+		cache, err := newRedisCache(redisURLSetting.get())
+		if err != nil {
+			log.Printf("Invalid REDIS_URL, enrichment cache disabled: %v", err)
+			enrichCache = noCache{}
+			return
+		}
+		enrichCache = cache
+	case "none":
+		enrichCache = noCache{}
+	default:
+		log.Printf("Unknown ENRICH_CACHE %q, using memory", backend)
+		enrichCache = newMemoryCache(enrichCacheSizeSetting.get())
+	}
+}
+
+func enrichCacheTTL() time.Duration {
+	return enrichCacheTTLSetting.get()
+}
+
+// enrichmentCacheKey identifies in together with the settings that change what the
+// providers are asked or how their answers are kept: the enabled providers and
+// their source fields, the combine strategy, name transliteration, the nationality
+// format and candidates kept, and how ages are rounded and screened. A hinted lookup
+// never collides with an unhinted one, since the country is part of in.key().
+func enrichmentCacheKey(in enrichmentInput) string {
+	var enabled []string
+	for _, p := range enrichmentProviders {
+		if p.enabled() {
+			enabled = append(enabled, p.Name+"="+strings.Join(p.sourceFields(), "+"))
+		}
+	}
+	return strings.Join([]string{
+		in.key(),
+		strings.Join(enabled, ","),
+		enrichCombineSetting.get(),
+		strconv.FormatBool(transliterationEnabled()),
+		nationalityFormat(),
+		strconv.Itoa(nationalityCandidatesSetting.get()),
+		unknownNationalitySetting.get(),
+		ageRoundingSetting.get(),
+		strconv.Itoa(agePlausibleMinSetting.get()),
+		strconv.Itoa(agePlausibleMaxSetting.get()),
+		ageImplausibleSetting.get(),
+	}, "\x00")
+}
+
+// cacheable reports whether e may be reused; partial results are retried instead
+func cacheable(e enrichment) bool {
+	return len(e.Failures) == 0
+}
+
+// noCache is used when caching is off or its backend can't be configured
+type noCache struct{}
+
+func (noCache) get(string) (enrichment, bool) { return enrichment{}, false }
+func (noCache) set(string, enrichment)        {}
+func (noCache) flush() (int, error)           { return 0, nil }
+
+// memoryCache keeps enrichments in this process, dropping the oldest once full
+type memoryCache struct {
+	sync.Mutex
+	size    int
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	e       enrichment
+	expires time.Time
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{size: size, entries: map[string]memoryCacheEntry{}}
+}
+
+func (c *memoryCache) get(key string) (enrichment, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return enrichment{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return enrichment{}, false
+	}
+	return entry.e, true
+}
+
+func (c *memoryCache) set(key string, e enrichment) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		c.evict()
+	}
+	if len(c.entries) < c.size {
+		c.entries[key] = memoryCacheEntry{e: e, expires: time.Now().Add(enrichCacheTTL())}
+	}
+}
+
+func (c *memoryCache) flush() (int, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	n := len(c.entries)
+	c.entries = map[string]memoryCacheEntry{}
+	return n, nil
+}
+
+// evict drops expired entries, or the one closest to expiring when none are
+func (c *memoryCache) evict() {
+	now := time.Now()
+	oldest := ""
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(c.entries) >= c.size && oldest != "" {
+		delete(c.entries, oldest)
+	}
+}
+
+// redisCache shares enrichments between instances. Keys are hashed so names
+// aren't stored in the clear; values are the enrichment as JSON.
+type redisCache struct {
+	client *redis.Client
+	// down is set while Redis is failing, so the outage is logged once rather than per lookup
+	down int32
+}
+
+func newRedisCache(url string) (*redisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{client: redis.NewClient(options)}, nil
+}
+
+func (c *redisCache) key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "enrichment:" + hex.EncodeToString(sum[:])
+}
+
+func (c *redisCache) get(key string) (enrichment, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if err == redis.Nil {
+		c.healthy()
+		return enrichment{}, false
+	}
+	if err != nil {
+		c.unavailable(err)
+		return enrichment{}, false
+	}
+	c.healthy()
+
+	var e enrichment
+	if err := json.Unmarshal(data, &e); err != nil {
+		log.Printf("Discarding unreadable cached enrichment: %v", err)
+		return enrichment{}, false
+	}
+	return e, true
+}
+
+func (c *redisCache) set(key string, e enrichment) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error encoding enrichment for cache: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, c.key(key), data, enrichCacheTTL()).Err(); err != nil {
+		c.unavailable(err)
+		return
+	}
+	c.healthy()
+}
+
+// flush deletes the enrichment keys a batch at a time, leaving anything else in
+// the Redis database alone. Instances sharing the cache are all flushed.
+func (c *redisCache) flush() (int, error) {
+	ctx := context.Background()
+	n := 0
+	iter := c.client.Scan(ctx, 0, "enrichment:*", redisFlushBatch).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == redisFlushBatch {
+			deleted, err := c.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return n, err
+			}
+			n += int(deleted)
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return n, err
+	}
+	if len(keys) > 0 {
+		deleted, err := c.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return n, err
+		}
+		n += int(deleted)
+	}
+	return n, nil
+}
+
+func (c *redisCache) unavailable(err error) {
+	if atomic.CompareAndSwapInt32(&c.down, 0, 1) {
+		log.Printf("Redis unavailable, enriching without cache: %v", err)
+	}
+}
+
+func (c *redisCache) healthy() {
+	if atomic.CompareAndSwapInt32(&c.down, 1, 0) {
+		log.Printf("Redis available again, enrichment cache restored")
+	}
+}
+
+// flushEnrichmentCache empties the enrichment cache, for use once the providers'
+// data is known to have changed, and reports how many entries were dropped
+func flushEnrichmentCache(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	flushed, err := enrichCache.flush()
+	if err != nil {
+		log.Printf("Error flushing enrichment cache after %d entries: %v", flushed, err)
+		respondError(w, http.StatusInternalServerError, "Failed to flush enrichment cache")
+		return
+	}
+	log.Printf("Flushed %d enrichment cache entries", flushed)
+
+	respondJSON(w, http.StatusOK, map[string]int{"flushed": flushed})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeRedis speaks just enough RESP2 for redisCache: GET, SET, SCAN and DEL.
+// While down it drops every connection, as an unreachable Redis would.
+type fakeRedis struct {
+	sync.Mutex
+	listener net.Listener
+	values   map[string]string
+	down     int32
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, values: map[string]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return r
+}
+
+func (r *fakeRedis) url() string {
+	return "redis://" + r.listener.Addr().String()
+}
+
+func (r *fakeRedis) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&r.down, 1)
+	} else {
+		atomic.StoreInt32(&r.down, 0)
+	}
+}
+
+func (r *fakeRedis) keys() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	var keys []string
+	for key := range r.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(in)
+		if err != nil || atomic.LoadInt32(&r.down) == 1 {
+			return
+		}
+		if _, err := conn.Write(r.reply(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (r *fakeRedis) reply(args []string) []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		value, ok := r.values[args[1]]
+		if !ok {
+			return []byte("$-1\r\n")
+		}
+		return bulk(value)
+	case "SET":
+		r.values[args[1]] = args[2]
+		return []byte("+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := r.values[key]; ok {
+				delete(r.values, key)
+				deleted++
+			}
+		}
+		return []byte(":" + strconv.Itoa(deleted) + "\r\n")
+	case "SCAN":
+		// One page holds every match, so the cursor is always back at 0
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+		var matches bytes.Buffer
+		n := 0
+		for key := range r.values {
+			if ok, _ := path.Match(pattern, key); ok {
+				matches.Write(bulk(key))
+				n++
+			}
+		}
+		return []byte(fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk("0"), n, matches.Bytes()))
+	case "PING":
+		return []byte("+PONG\r\n")
+	case "CLIENT":
+		return []byte("+OK\r\n")
+	default:
+		// HELLO among them, so the client falls back to RESP2
+		return []byte("-ERR unknown command '" + args[0] + "'\r\n")
+	}
+}
+
+func bulk(s string) []byte {
+	return []byte("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// readCommand reads one command, sent as an array of bulk strings
+func readCommand(in *bufio.Reader) ([]string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(in, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestMemoryCacheSavesProviderCalls(t *testing.T) {
+	server, stub := newTestServer(t)
+
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Petrov"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 1 {
+		t.Errorf("Agify called %d times for the same name, want 1", n)
+	}
+
+	if resp, data := adminCall(t, server, http.MethodPost, "/admin/cache/flush", ""); resp.StatusCode != http.StatusOK || !strings.Contains(string(data), `"flushed":1`) {
+		t.Errorf("Cache flush answered %d: %s", resp.StatusCode, data)
+	}
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ivanov"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 2 {
+		t.Errorf("Agify called %d times after the flush, want 2", n)
+	}
+
+	// Once full, an older entry makes way for the new one
+	cache := newMemoryCache(1)
+	cache.set("Dmitriy", enrichment{})
+	cache.set("Ivan", enrichment{})
+	if _, ok := cache.get("Dmitriy"); ok {
+		t.Error("Cache of size 1 still holds the first entry")
+	}
+	if _, ok := cache.get("Ivan"); !ok {
+		t.Error("Cache of size 1 lost the newest entry")
+	}
+}
+
+func TestRedisCacheSharedBetweenInstances(t *testing.T) {
+	redis := newFakeRedis(t)
+	first, err := newRedisCache(redis.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := newRedisCache(redis.url())
+
+	first.set("Dmitriy", enrichment{Age: 42, AgeKnown: true})
+	cached, ok := second.get("Dmitriy")
+	if !ok || !cached.AgeKnown || cached.Age != 42 {
+		t.Fatalf("Second instance got %+v (%v), want the first instance's enrichment", cached, ok)
+	}
+	for _, key := range redis.keys() {
+		if strings.Contains(key, "Dmitriy") {
+			t.Errorf("Redis key %q holds the name in the clear", key)
+		}
+	}
+
+	redis.Lock()
+	redis.values["session:1"] = "kept"
+	redis.Unlock()
+	if n, err := second.flush(); n != 1 || err != nil {
+		t.Errorf("Flush dropped %d entries (%v), want 1", n, err)
+	}
+	if _, ok := first.get("Dmitriy"); ok {
+		t.Error("First instance still cached the enrichment after the flush")
+	}
+	if keys := redis.keys(); len(keys) != 1 || keys[0] != "session:1" {
+		t.Errorf("Redis holds %v after the flush, want only the unrelated key", keys)
+	}
+}
+
+func TestRedisOutageDegradesToNoCache(t *testing.T) {
+	server, stub := newTestServer(t)
+	redis := newFakeRedis(t)
+	cache, err := newRedisCache(redis.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrichCache = cache
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	redis.setDown(true)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Petrov"}`)
+	if person.Age == nil || *person.Age != 42 {
+		t.Errorf("Person created during the outage = %+v, want it enriched", person)
+	}
+	if n := stub.callCount(agifyProvider.Name); n != 2 {
+		t.Errorf("Agify called %d times during the outage, want every lookup enriched afresh", n)
+	}
+	if n := strings.Count(logged.String(), "Redis unavailable"); n != 1 {
+		t.Errorf("Outage logged %d times, want once:\n%s", n, logged.String())
+	}
+
+	redis.setDown(false)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ivanov"}`)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Sidorov"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 3 {
+		t.Errorf("Agify called %d times once Redis was back, want 3", n)
+	}
+	if !strings.Contains(logged.String(), "Redis available again") {
+		t.Errorf("Recovery not logged:\n%s", logged.String())
+	}
+}
+
+func TestLoadEnrichmentCacheSelectsBackend(t *testing.T) {
+	previousCache := enrichCache
+	defer func() { enrichCache = previousCache }()
+
+	for _, tc := range []struct {
+		backend, url, want string
+	}{
+		{"", "", "*main.memoryCache"},
+		{"memory", "", "*main.memoryCache"},
+		{"redis", "redis://127.0.0.1:6379/0", "*main.redisCache"},
+		{"redis", "not a url", "main.noCache"},
+		{"none", "", "main.noCache"},
+		{"memcached", "", "*main.memoryCache"},
+	} {
+		t.Setenv("ENRICH_CACHE", tc.backend)
+		t.Setenv("REDIS_URL", tc.url)
+		log.SetOutput(io.Discard)
+		loadEnrichmentCache()
+		log.SetOutput(os.Stderr)
+		if got := fmt.Sprintf("%T", enrichCache); got != tc.want {
+			t.Errorf("ENRICH_CACHE=%q REDIS_URL=%q loaded %s, want %s", tc.backend, tc.url, got, tc.want)
+		}
+	}
+}
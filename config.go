@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnv returns the value of key, or def when it is unset
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getEnvInt returns key parsed as an int, or def when it is unset or invalid
+func getEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// getEnvBool returns key parsed as a bool, or def when it is unset or invalid
+func getEnvBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// getEnvDuration returns key parsed as a time.Duration, or def when it is unset or invalid
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// postgresSSLModes are the sslmode values libpq accepts
+var postgresSSLModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// databaseURL returns DATABASE_URL, or when it is unset assembles the DSN from
+// DB_HOST, DB_PORT (default 5432), DB_USER, DB_PASSWORD, DB_NAME and DB_SSLMODE
+// (default disable), as many deployment platforms provide them
+func databaseURL() (string, error) {
+	if dsn := databaseURLSetting.get(); dsn != "" {
+		return dsn, nil
+	}
+
+	var missing []string
+	for _, s := range []stringSetting{dbHostSetting, dbUserSetting, dbNameSetting} {
+		if s.get() == "" {
+			missing = append(missing, s.key())
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("DATABASE_URL is unset and so is %s", strings.Join(missing, ", "))
+	}
+
+	port := dbPortSetting.get()
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid DB_PORT %q", port)
+	}
+	sslMode := dbSSLModeSetting.get()
+	if !postgresSSLModes[sslMode] {
+		return "", fmt.Errorf("invalid DB_SSLMODE %q", sslMode)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(dbHostSetting.get(), port),
+		Path:     "/" + dbNameSetting.get(),
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	if password := dbPasswordSetting.get(); password != "" {
+		dsn.User = url.UserPassword(dbUserSetting.get(), password)
+	} else {
+		dsn.User = url.User(dbUserSetting.get())
+	}
+	return dsn.String(), nil
+}
+
+// describeDSN summarizes a postgres DSN for logs as "host:port/db as user", leaving
+// out the password. Both URLs and libpq key=value strings are understood.
+func describeDSN(dsn string) string {
+	var host, port, name, user string
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		host, port = u.Hostname(), u.Port()
+		name = strings.TrimPrefix(u.Path, "/")
+		user = u.User.Username()
+	} else {
+		for _, pair := range strings.Fields(dsn) {
+			key, value, _ := strings.Cut(pair, "=")
+			value = strings.Trim(value, "'")
+			switch key {
+			case "host":
+				host = value
+			case "port":
+				port = value
+			case "dbname":
+				name = value
+			case "user":
+				user = value
+			}
+		}
+	}
+
+	if port == "" {
+		port = "5432"
+	}
+	description := net.JoinHostPort(host, port) + "/" + name
+	if user != "" {
+		description += " as " + user
+	}
+	return description
+}
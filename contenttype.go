@@ -0,0 +1,43 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+)
+
+// jsonMediaTypes are the request body types accepted by the JSON endpoints
+var jsonMediaTypes = map[string]bool{
+	"application/json":             true,
+	"application/merge-patch+json": true,
+}
+
+// nonJSONBodyPaths take a body in another format and skip the JSON check
+var nonJSONBodyPaths = map[string]bool{
+	"/enrich/csv": true,
+}
+
+// requireJSONContentType answers 415 when a POST, PUT or PATCH carries a body that
+// isn't declared as JSON. Parameters such as charset are allowed. Bodiless requests
+// pass, and REQUIRE_JSON_CONTENT_TYPE=false turns the check off.
+func requireJSONContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength == 0 || nonJSONBodyPaths[r.URL.Path] || !requireJSONContentTypeSetting.get() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !jsonMediaTypes[mediaType] {
+			respondError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
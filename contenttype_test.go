@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateRequiresJSONContentType(t *testing.T) {
+	server, _ := newTestServer(t)
+	body := `{"name":"Dmitriy","surname":"Ushakov"}`
+
+	for contentType, want := range map[string]int{
+		"application/json":                  http.StatusCreated,
+		"application/json; charset=utf-8":   http.StatusCreated,
+		"":                                  http.StatusUnsupportedMediaType,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+	} {
+		resp, data := call(t, server, http.MethodPost, "/people", body, "Content-Type", contentType)
+		if resp.StatusCode != want {
+			t.Errorf("Create sent as %q answered %d, want %d: %s", contentType, resp.StatusCode, want, data)
+		}
+	}
+
+	t.Setenv("REQUIRE_JSON_CONTENT_TYPE", "false")
+	if resp, data := call(t, server, http.MethodPost, "/people", body, "Content-Type", "text/plain"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("Create sent as text/plain with the check off answered %d: %s", resp.StatusCode, data)
+	}
+}
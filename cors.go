@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMaxAge is how long browsers may cache a preflight when CORS_MAX_AGE is unset
+const defaultCORSMaxAge = 10 * time.Minute
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, Accept, Accept-Language, Prefer"
+)
+
+// corsMaxAge reads CORS_MAX_AGE, rejecting negative durations in favor of the default
+func corsMaxAge() time.Duration {
+	maxAge := corsMaxAgeSetting.get()
+	if maxAge < 0 {
+		log.Printf("Invalid CORS_MAX_AGE %s, it must not be negative; using %s", maxAge, defaultCORSMaxAge)
+		return defaultCORSMaxAge
+	}
+	return maxAge
+}
+
+// withCORS adds CORS headers for the origins in CORS_ALLOWED_ORIGINS (comma-separated,
+// default "*") and answers preflight requests itself. It wraps the whole router, since
+// routes don't register OPTIONS.
+func withCORS(next http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range strings.Split(corsAllowedOriginsSetting.get(), ",") {
+		allowed[strings.TrimSpace(origin)] = true
+	}
+	maxAge := strconv.Itoa(int(corsMaxAge().Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every response depends on Origin, including those without CORS headers,
+		// so a shared cache never hands one origin's answer to another
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// Let browser clients read the response headers they need
+			w.Header().Set("Access-Control-Expose-Headers", requestIDHeaderName()+", "+nextCursorHeader+", Location, Preference-Applied")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders+", "+requestIDHeaderName())
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
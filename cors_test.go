@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORSVariesOnOriginForEveryResponse(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://allowed.example")
+	server, _ := newTestServer(t)
+
+	for _, origin := range []string{"", "https://allowed.example", "https://other.example"} {
+		resp, _ := call(t, server, http.MethodGet, "/healthz", "", "Origin", origin)
+		if resp.Header.Get("Vary") != "Origin" {
+			t.Errorf("Origin %q: Vary = %q, want Origin", origin, resp.Header.Get("Vary"))
+		}
+		allowOrigin := resp.Header.Get("Access-Control-Allow-Origin")
+		if want := origin == "https://allowed.example"; want != (allowOrigin != "") {
+			t.Errorf("Origin %q: Access-Control-Allow-Origin = %q", origin, allowOrigin)
+		}
+	}
+}
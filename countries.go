@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Nationality storage formats, chosen with NATIONALITY_FORMAT
+const (
+	nationalityAlpha2 = "alpha2"
+	nationalityAlpha3 = "alpha3"
+)
+
+// alpha2ToAlpha3 maps the ISO 3166-1 alpha-2 country codes to their alpha-3 equivalents.
+// XK is Kosovo's user-assigned code, which Nationalize returns.
+var alpha2ToAlpha3 = map[string]string{
+	"AF": "AFG", "AX": "ALA", "AL": "ALB", "DZ": "DZA", "AS": "ASM", "AD": "AND", "AO": "AGO", "AI": "AIA",
+	"AQ": "ATA", "AG": "ATG", "AR": "ARG", "AM": "ARM", "AW": "ABW", "AU": "AUS", "AT": "AUT", "AZ": "AZE",
+	"BS": "BHS", "BH": "BHR", "BD": "BGD", "BB": "BRB", "BY": "BLR", "BE": "BEL", "BZ": "BLZ", "BJ": "BEN",
+	"BM": "BMU", "BT": "BTN", "BO": "BOL", "BQ": "BES", "BA": "BIH", "BW": "BWA", "BV": "BVT", "BR": "BRA",
+	"IO": "IOT", "BN": "BRN", "BG": "BGR", "BF": "BFA", "BI": "BDI", "CV": "CPV", "KH": "KHM", "CM": "CMR",
+	"CA": "CAN", "KY": "CYM", "CF": "CAF", "TD": "TCD", "CL": "CHL", "CN": "CHN", "CX": "CXR", "CC": "CCK",
+	"CO": "COL", "KM": "COM", "CG": "COG", "CD": "COD", "CK": "COK", "CR": "CRI", "CI": "CIV", "HR": "HRV",
+	"CU": "CUB", "CW": "CUW", "CY": "CYP", "CZ": "CZE", "DK": "DNK", "DJ": "DJI", "DM": "DMA", "DO": "DOM",
+	"EC": "ECU", "EG": "EGY", "SV": "SLV", "GQ": "GNQ", "ER": "ERI", "EE": "EST", "SZ": "SWZ", "ET": "ETH",
+	"FK": "FLK", "FO": "FRO", "FJ": "FJI", "FI": "FIN", "FR": "FRA", "GF": "GUF", "PF": "PYF", "TF": "ATF",
+	"GA": "GAB", "GM": "GMB", "GE": "GEO", "DE": "DEU", "GH": "GHA", "GI": "GIB", "GR": "GRC", "GL": "GRL",
+	"GD": "GRD", "GP": "GLP", "GU": "GUM", "GT": "GTM", "GG": "GGY", "GN": "GIN", "GW": "GNB", "GY": "GUY",
+	"HT": "HTI", "HM": "HMD", "VA": "VAT", "HN": "HND", "HK": "HKG", "HU": "HUN", "IS": "ISL", "IN": "IND",
+	"ID": "IDN", "IR": "IRN", "IQ": "IRQ", "IE": "IRL", "IM": "IMN", "IL": "ISR", "IT": "ITA", "JM": "JAM",
+	"JP": "JPN", "JE": "JEY", "JO": "JOR", "KZ": "KAZ", "KE": "KEN", "KI": "KIR", "KP": "PRK", "KR": "KOR",
+	"KW": "KWT", "KG": "KGZ", "LA": "LAO", "LV": "LVA", "LB": "LBN", "LS": "LSO", "LR": "LBR", "LY": "LBY",
+	"LI": "LIE", "LT": "LTU", "LU": "LUX", "MO": "MAC", "MG": "MDG", "MW": "MWI", "MY": "MYS", "MV": "MDV",
+	"ML": "MLI", "MT": "MLT", "MH": "MHL", "MQ": "MTQ", "MR": "MRT", "MU": "MUS", "YT": "MYT", "MX": "MEX",
+	"FM": "FSM", "MD": "MDA", "MC": "MCO", "MN": "MNG", "ME": "MNE", "MS": "MSR", "MA": "MAR", "MZ": "MOZ",
+	"MM": "MMR", "NA": "NAM", "NR": "NRU", "NP": "NPL", "NL": "NLD", "NC": "NCL", "NZ": "NZL", "NI": "NIC",
+	"NE": "NER", "NG": "NGA", "NU": "NIU", "NF": "NFK", "MK": "MKD", "MP": "MNP", "NO": "NOR", "OM": "OMN",
+	"PK": "PAK", "PW": "PLW", "PS": "PSE", "PA": "PAN", "PG": "PNG", "PY": "PRY", "PE": "PER", "PH": "PHL",
+	"PN": "PCN", "PL": "POL", "PT": "PRT", "PR": "PRI", "QA": "QAT", "RE": "REU", "RO": "ROU", "RU": "RUS",
+	"RW": "RWA", "BL": "BLM", "SH": "SHN", "KN": "KNA", "LC": "LCA", "MF": "MAF", "PM": "SPM", "VC": "VCT",
+	"WS": "WSM", "SM": "SMR", "ST": "STP", "SA": "SAU", "SN": "SEN", "RS": "SRB", "SC": "SYC", "SL": "SLE",
+	"SG": "SGP", "SX": "SXM", "SK": "SVK", "SI": "SVN", "SB": "SLB", "SO": "SOM", "ZA": "ZAF", "GS": "SGS",
+	"SS": "SSD", "ES": "ESP", "LK": "LKA", "SD": "SDN", "SR": "SUR", "SJ": "SJM", "SE": "SWE", "CH": "CHE",
+	"SY": "SYR", "TW": "TWN", "TJ": "TJK", "TZ": "TZA", "TH": "THA", "TL": "TLS", "TG": "TGO", "TK": "TKL",
+	"TO": "TON", "TT": "TTO", "TN": "TUN", "TR": "TUR", "TM": "TKM", "TC": "TCA", "TV": "TUV", "UG": "UGA",
+	"UA": "UKR", "AE": "ARE", "GB": "GBR", "US": "USA", "UM": "UMI", "UY": "URY", "UZ": "UZB", "VU": "VUT",
+	"VE": "VEN", "VN": "VNM", "VG": "VGB", "VI": "VIR", "WF": "WLF", "EH": "ESH", "YE": "YEM", "ZM": "ZMB",
+	"ZW": "ZWE",
+	"XK": "XKX",
+}
+
+// alpha3ToAlpha2 is the inverse of alpha2ToAlpha3
+var alpha3ToAlpha2 = func() map[string]string {
+	inverse := make(map[string]string, len(alpha2ToAlpha3))
+	for alpha2, alpha3 := range alpha2ToAlpha3 {
+		inverse[alpha3] = alpha2
+	}
+	return inverse
+}()
+
+// nationalityFormat is how nationalities are stored: alpha2 (the default, as the
+// providers answer) or alpha3
+func nationalityFormat() string {
+	return nationalityFormatSetting.get()
+}
+
+// convertCountryCode returns the known country code in the given format, reporting
+// false when code is neither a known alpha-2 nor alpha-3 code
+func convertCountryCode(code, format string) (string, bool) {
+	code = strings.ToUpper(code)
+	alpha2, ok := code, alpha2ToAlpha3[code] != ""
+	if !ok {
+		alpha2, ok = alpha3ToAlpha2[code]
+	}
+	if !ok {
+		return "", false
+	}
+	if format == nationalityAlpha3 {
+		return alpha2ToAlpha3[alpha2], true
+	}
+	return alpha2, true
+}
+
+// storedCountryCode normalizes a client-supplied alpha-2 or alpha-3 code to the
+// storage format. Well-formed codes missing from the table are kept as given
+// when they already have the storage format's length.
+func storedCountryCode(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if converted, ok := convertCountryCode(code, nationalityFormat()); ok {
+		return converted, true
+	}
+
+	length := 2
+	if nationalityFormat() == nationalityAlpha3 {
+		length = 3
+	}
+	if len(code) != length {
+		return "", false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// checkKnownCountryCode soft-validates a well-formed code against the ISO 3166
+// table. Unknown codes are logged, since they usually mean a provider started
+// answering something new; with UNKNOWN_NATIONALITY=null they are also dropped,
+// returning "", instead of being stored as given (UNKNOWN_NATIONALITY=log, the default).
+func checkKnownCountryCode(source, code string) string {
+	if code == "" {
+		return code
+	}
+	if _, ok := convertCountryCode(code, nationalityFormat()); ok {
+		return code
+	}
+
+	if unknownNationalitySetting.get() == "null" {
+		log.Printf("Dropping unknown nationality code %q from %s", code, source)
+		return ""
+	}
+	log.Printf("Unknown nationality code %q from %s", code, source)
+	return code
+}
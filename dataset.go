@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Dataset is a full dump of the database, soft-deleted people included, as
+// written by GET /admin/export and read back by POST /admin/import
+type Dataset struct {
+	People  []Person
+	History []PersonHistory
+}
+
+// importConflictStrategies are the accepted values of ?on_conflict= for an import:
+// fail aborts the whole import, skip keeps the stored row, overwrite replaces it
+// and renumber inserts the imported person under a new ID
+var importConflictStrategies = map[string]bool{"fail": true, "skip": true, "overwrite": true, "renumber": true}
+
+// exportDataset dumps every person and history entry with their IDs and timestamps.
+// RESPONSE_FIELD_NAMING doesn't apply, so the dump can always be imported as is.
+func exportDataset(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	conn, done := readDB(r)
+	defer done()
+
+	var dataset Dataset
+	if err := conn.Unscoped().Order("id").Find(&dataset.People).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+	if err := conn.Order("id").Find(&dataset.History).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load history")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="dataset.json"`)
+	// importDataset reads the dump back with the Go field names, whatever naming responses use
+	if jw, ok := w.(*jsonWriter); ok {
+		jw.serializer = withoutNaming(jw.serializer)
+	}
+	respondJSON(w, http.StatusOK, dataset)
+}
+
+// importDataset restores a dump made by exportDataset in one transaction. The
+// stored values, timestamps included, are kept as they are unless ?enrich=true,
+// and ?on_conflict= (fail by default) decides what happens to a person whose ID
+// is already taken. People are only validated with ?validate=true, so records
+// stored before a validation rule was tightened can be restored from their dump.
+func importDataset(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	strategy := r.URL.Query().Get("on_conflict")
+	if strategy == "" {
+		strategy = importConflictStrategySetting.get()
+	}
+	if !importConflictStrategies[strategy] {
+		respondError(w, http.StatusBadRequest, "Invalid on_conflict, expected fail, skip, overwrite or renumber")
+		return
+	}
+
+	var dataset Dataset
+	if !decodeJSONBody(w, r, &dataset) {
+		return
+	}
+
+	enrich, _ := strconv.ParseBool(r.URL.Query().Get("enrich"))
+	validate, _ := strconv.ParseBool(r.URL.Query().Get("validate"))
+	for i := range dataset.People {
+		if validate {
+			if err := validatePerson(&dataset.People[i]); err != nil {
+				respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Person %d: %v", dataset.People[i].ID, err))
+				return
+			}
+		}
+		if enrich && !dataset.People[i].ManualOverride {
+			enrichPersonData(r.Context(), &dataset.People[i])
+		}
+	}
+
+	imported, skipped := 0, 0
+	err := writeTransaction(r.Context(), func(tx *gorm.DB) error {
+		// renumbered maps the dump's IDs to the ones assigned, so history follows its person
+		renumbered := map[uint]uint{}
+		for i := range dataset.People {
+			person := &dataset.People[i]
+
+			var taken int
+			if err := tx.Unscoped().Model(&Person{}).Where("id = ?", person.ID).Count(&taken).Error; err != nil {
+				return err
+			}
+			if taken > 0 {
+				switch strategy {
+				case "fail":
+					return newDomainError(errConflict, "Person %d already exists", person.ID)
+				case "skip":
+					skipped++
+					continue
+				case "overwrite":
+					// Save would stamp UpdatedAt with the current time; the dump's is kept instead
+					if err := tx.Unscoped().Model(person).UpdateColumns(storedColumns(tx, person)).Error; err != nil {
+						return err
+					}
+					imported++
+					continue
+				case "renumber":
+					oldID := person.ID
+					// The copy is a different person, so it gets its own PublicID too
+					person.ID, person.PublicID = 0, nil
+					if err := tx.Create(person).Error; err != nil {
+						return err
+					}
+					renumbered[oldID] = person.ID
+					imported++
+					continue
+				}
+			}
+
+			if err := tx.Create(person).Error; err != nil {
+				return err
+			}
+			imported++
+		}
+
+		for i := range dataset.History {
+			entry := dataset.History[i]
+			if newID, ok := renumbered[entry.PersonID]; ok {
+				entry.PersonID = newID
+			}
+			// History IDs aren't referenced anywhere, so entries always get fresh ones
+			entry.ID = 0
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+
+		return resetIDSequences(tx)
+	})
+
+	if err != nil {
+		respondDBError(w, err, "Failed to import dataset")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]int{"imported": imported, "skipped": skipped})
+}
+
+// storedColumns maps every stored column of person but its ID to the value it
+// holds, so an UpdateColumns can write the whole row, zero values included
+func storedColumns(tx *gorm.DB, person *Person) map[string]interface{} {
+	columns := map[string]interface{}{}
+	for _, field := range tx.NewScope(person).Fields() {
+		if field.IsNormal && !field.IsIgnored && !field.IsPrimaryKey {
+			columns[field.DBName] = field.Field.Interface()
+		}
+	}
+	return columns
+}
+
+// resetIDSequences moves the ID sequences past the highest imported IDs, since
+// inserting explicit IDs doesn't advance them. Only Postgres keeps sequences;
+// SQLite, used by the tests, numbers new rows after the highest ID as it is.
+func resetIDSequences(tx *gorm.DB) error {
+	if tx.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	for _, table := range []string{"people", "person_histories"} {
+		err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE((SELECT MAX(id) FROM %[1]s), 0) + 1, false)", table)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatasetRoundTripWithFieldNaming(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("RESPONSE_FIELD_NAMING", namingSnake)
+	resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy","surname":"Ushakov"}`)
+	if resp.StatusCode != http.StatusCreated || !strings.Contains(string(data), `"gender_probability"`) {
+		t.Fatalf("Create answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, dump := adminCall(t, server, http.MethodGet, "/admin/export", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Export answered %d: %s", resp.StatusCode, dump)
+	}
+	var dataset Dataset
+	decode(t, dump, &dataset)
+	if len(dataset.People) != 1 || dataset.People[0].Name != "Dmitriy" || dataset.People[0].GenderProbability != 0.99 {
+		t.Fatalf("Export with snake naming lost fields: %s", dump)
+	}
+
+	resp, data = adminCall(t, server, http.MethodPost, "/admin/import?on_conflict=overwrite", string(dump))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Import answered %d: %s", resp.StatusCode, data)
+	}
+	var stored Person
+	db.First(&stored)
+	if stored.Name != "Dmitriy" || stored.Surname != "Ushakov" || stored.Nationality != "RU" {
+		t.Errorf("Round trip stored %+v", stored)
+	}
+}
+
+func TestDatasetRestoreKeepsRowsAsExported(t *testing.T) {
+	server, _ := newTestServer(t)
+	// Stored before the name rules were tightened, so it would no longer pass validation
+	legacy := Person{Name: "Dmitriy2", Surname: "Ushakov"}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatalf("Error storing legacy person: %v", err)
+	}
+
+	resp, dump := adminCall(t, server, http.MethodGet, "/admin/export", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Export answered %d: %s", resp.StatusCode, dump)
+	}
+	var dataset Dataset
+	decode(t, dump, &dataset)
+	exported := dataset.People[0]
+	exported.UpdatedAt = exported.UpdatedAt.Add(-24 * time.Hour)
+	exported.Surname = ""
+	data, _ := json.Marshal(Dataset{People: []Person{exported}})
+
+	resp, body := adminCall(t, server, http.MethodPost, "/admin/import?on_conflict=overwrite&validate=true", string(data))
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Validated import of an invalid name answered %d: %s", resp.StatusCode, body)
+	}
+
+	resp, body = adminCall(t, server, http.MethodPost, "/admin/import?on_conflict=overwrite", string(data))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Import answered %d: %s", resp.StatusCode, body)
+	}
+	var stored Person
+	db.First(&stored, legacy.ID)
+	if stored.Name != "Dmitriy2" || stored.Surname != "" {
+		t.Errorf("Overwrite stored %+v, want the dump's row with its empty surname", stored)
+	}
+	if !stored.UpdatedAt.Equal(exported.UpdatedAt) || !stored.CreatedAt.Equal(exported.CreatedAt) {
+		t.Errorf("Overwrite stored timestamps %v / %v, want the dump's %v / %v", stored.CreatedAt, stored.UpdatedAt, exported.CreatedAt, exported.UpdatedAt)
+	}
+}
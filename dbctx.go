@@ -0,0 +1,26 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+)
+
+// readDB returns a read-only transaction bound to r's context. GORM v1 can't
+// attach a context to a single query, but database/sql cancels every statement
+// of a context-bound transaction, so a client that disconnects aborts its reads.
+// The returned func must be called once the queries are done.
+func readDB(r *http.Request) (*gorm.DB, func()) {
+	tx := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		if r.Context().Err() != nil {
+			// The client has already gone, so queries on tx fail with its error rather than run
+			return tx, func() {}
+		}
+		log.Printf("Error starting request transaction, running without cancellation: %v", tx.Error)
+		return db, func() {}
+	}
+	return tx, func() { tx.Rollback() }
+}
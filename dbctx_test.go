@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestCanceledReadIsAborted(t *testing.T) {
+	newTestServer(t)
+	storePeople(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn, done := readDB(httptest.NewRequest(http.MethodGet, "/people", nil).WithContext(ctx))
+	defer done()
+
+	var people []Person
+	if err := conn.Find(&people).Error; err == nil {
+		t.Errorf("Read after the client went away returned %d people, want it aborted", len(people))
+	}
+}
+
+func TestCanceledWriteIsRolledBack(t *testing.T) {
+	newTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := writeTransaction(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(&Person{Name: "Dmitriy"}).Error; err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if err == nil {
+		t.Error("Write whose client went away committed")
+	}
+
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("%d people stored by a canceled write, want 0", stored)
+	}
+}
+
+func TestCanceledUpdateLeavesPersonUnchanged(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/people/%d", person.ID), strings.NewReader(`{"name":"Dmitriy","surname":"Petrov"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	newRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.Surname != "Ushakov" {
+		t.Errorf("Surname = %q after a canceled update, want it unchanged", stored.Surname)
+	}
+}
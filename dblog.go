@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultDBSlowQueryThreshold is how long a query may take before it is logged when DB_SLOW_QUERY_THRESHOLD is unset
+const defaultDBSlowQueryThreshold = 200 * time.Millisecond
+
+// slowQueryLogger receives GORM's log output and reports queries slower than threshold.
+// With level "info" every query is logged, "warn" (the default) logs slow queries and
+// errors, and "silent" logs nothing.
+type slowQueryLogger struct {
+	threshold time.Duration
+	level     string
+}
+
+func newSlowQueryLogger() slowQueryLogger {
+	return slowQueryLogger{
+		threshold: dbSlowQueryThresholdSetting.get(),
+		level:     strings.ToLower(dbLogLevelSetting.get()),
+	}
+}
+
+// Print implements gorm.logger. SQL entries arrive as
+// ("sql", source, duration, query, vars, rowsAffected); anything else is a log or error entry.
+func (l slowQueryLogger) Print(values ...interface{}) {
+	if l.level == "silent" || len(values) < 2 {
+		return
+	}
+
+	if values[0] != "sql" || len(values) < 6 {
+		log.Printf("Database: %v %v", values[1], values[2:])
+		return
+	}
+
+	duration, _ := values[2].(time.Duration)
+	vars := values[4]
+	if privacyMode() {
+		// Bound values include names, so only the SQL text is logged
+		vars = "[redacted]"
+	}
+
+	switch {
+	case duration >= l.threshold:
+		log.Printf("Slow query (%s, threshold %s) at %v: %v %v", duration, l.threshold, values[1], values[3], vars)
+	case l.level == "info":
+		log.Printf("Query (%s): %v %v", duration, values[3], vars)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSlowQueriesLogged(t *testing.T) {
+	newTestServer(t)
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "20ms")
+	conn := db.New()
+	conn.SetLogger(newSlowQueryLogger())
+	conn.LogMode(true)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var count struct{ N int }
+	conn.Raw("SELECT count(*) AS n FROM people WHERE name = ?", "Dmitriy").Scan(&count)
+	if strings.Contains(logged.String(), "Slow query") {
+		t.Errorf("A fast query was logged as slow:\n%s", logged.String())
+	}
+
+	// Counting a few million generated rows takes SQLite well past the threshold
+	conn.Raw("WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < ?) SELECT count(*) AS n FROM c", 3000000).Scan(&count)
+	if !strings.Contains(logged.String(), "Slow query") || !strings.Contains(logged.String(), "WITH RECURSIVE") {
+		t.Errorf("The slow query wasn't logged:\n%s", logged.String())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnrichmentFailure is a deadletter entry for a person whose enrichment failed
+// after every configured URL of a provider was tried, kept so operators or a
+// backfill job can revisit them
+type EnrichmentFailure struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PersonID  uint `gorm:"index"`
+	// Providers is the comma-separated list of providers that failed
+	Providers string
+	Reason    string `gorm:"type:text"`
+}
+
+// recordEnrichmentFailure adds a deadletter entry for personID when e has failures.
+// The person has already been saved, so a failed insert is only logged.
+func recordEnrichmentFailure(personID uint, e enrichment) {
+	if len(e.Failures) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := make([]string, len(names))
+	for i, name := range names {
+		reasons[i] = name + ": " + e.Failures[name].Error()
+	}
+
+	failure := EnrichmentFailure{
+		PersonID:  personID,
+		Providers: strings.Join(names, ","),
+		Reason:    strings.Join(reasons, "; "),
+	}
+	if err := db.Create(&failure).Error; err != nil {
+		log.Printf("Error recording enrichment failure for person %d: %v", personID, err)
+	}
+}
+
+// getEnrichmentFailures lists the deadletter entries, optionally for one ?person_id=
+func getEnrichmentFailures(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	conn, done := readDB(r)
+	defer done()
+
+	query := conn.Order("id")
+	if raw := r.URL.Query().Get("person_id"); raw != "" {
+		personID, err := strconv.Atoi(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid person ID")
+			return
+		}
+		query = query.Where("person_id = ?", personID)
+	}
+
+	query, err := applyPagination(query, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var failures []EnrichmentFailure
+	if err := query.Find(&failures).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load enrichment failures")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, failures)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEnrichmentFailuresRecorded(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.fail(nationalizeProvider.Name)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+
+	if resp, data := call(t, server, http.MethodGet, "/admin/enrichment/failures", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized listing answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodGet, "/admin/enrichment/failures", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Listing answered %d: %s", resp.StatusCode, data)
+	}
+	var failures []EnrichmentFailure
+	decode(t, data, &failures)
+	if len(failures) != 1 || failures[0].PersonID != person.ID || failures[0].Providers != nationalizeProvider.Name {
+		t.Errorf("Got %+v, want one nationalize failure for person %d", failures, person.ID)
+	}
+}
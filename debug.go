@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// EnrichmentDebug explains how a person's enrichment was derived
+type EnrichmentDebug struct {
+	Responses map[string]json.RawMessage
+	Failures  map[string]string `json:",omitempty"`
+	Decisions []string
+}
+
+// personWithDebug is a person response carrying enrichment debug info
+type personWithDebug struct {
+	Person
+	Debug *EnrichmentDebug
+}
+
+// debugRequested reports whether r asked for ?debug=true and may see it,
+// which requires a development environment or an admin token.
+func debugRequested(r *http.Request) bool {
+	debug, _ := strconv.ParseBool(r.URL.Query().Get("debug"))
+	return debug && (isDevEnvironment() || isAdmin(r))
+}
+
+func (e enrichment) debug() *EnrichmentDebug {
+	d := &EnrichmentDebug{Responses: e.Responses, Decisions: e.Decisions}
+	for provider, err := range e.Failures {
+		if d.Failures == nil {
+			d.Failures = map[string]string{}
+		}
+		d.Failures[provider] = err.Error()
+	}
+	return d
+}
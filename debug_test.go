@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugInfoNeedsFlagAndPermission(t *testing.T) {
+	server, _ := newTestServer(t)
+	body := `{"name":"Dmitriy","surname":"Ushakov"}`
+	admin := []string{"Authorization", "Bearer " + testAdminToken}
+
+	debugOf := func(resp *http.Response, data []byte) *EnrichmentDebug {
+		t.Helper()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Answered %d: %s", resp.StatusCode, data)
+		}
+		var answered personWithDebug
+		decode(t, data, &answered)
+		return answered.Debug
+	}
+
+	if debug := debugOf(call(t, server, http.MethodPost, "/people?debug=true", body)); debug != nil {
+		t.Errorf("Create without permission answered debug info %+v", debug)
+	}
+	if debug := debugOf(call(t, server, http.MethodPost, "/people", body, admin...)); debug != nil {
+		t.Errorf("Create without ?debug=true answered debug info %+v", debug)
+	}
+
+	resp, data := call(t, server, http.MethodPost, "/people?debug=true", body, admin...)
+	debug := debugOf(resp, data)
+	if debug == nil {
+		t.Fatalf("Admin create with ?debug=true answered no debug info: %s", data)
+	}
+	for _, p := range enrichmentProviders {
+		if len(debug.Responses[p.Name]) == 0 {
+			t.Errorf("Debug info has no raw %s response: %+v", p.Name, debug.Responses)
+		}
+	}
+	if !strings.Contains(strings.Join(debug.Decisions, "\n"), "nationality RU chosen from 2 candidates") {
+		t.Errorf("Decisions = %q, want the nationality choice explained", debug.Decisions)
+	}
+
+	var person Person
+	decode(t, data, &person)
+	path := fmt.Sprintf("/people/%d?debug=true", person.ID)
+	if debug := debugOf(call(t, server, http.MethodGet, path, "")); debug != nil {
+		t.Errorf("GET without permission answered debug info %+v", debug)
+	}
+	t.Setenv("APP_ENV", "development")
+	debug = debugOf(call(t, server, http.MethodGet, path, ""))
+	if debug == nil || len(debug.Decisions) == 0 || !strings.HasPrefix(debug.Decisions[0], "fresh lookup") {
+		t.Errorf("GET in development answered debug info %+v, want a fresh lookup", debug)
+	}
+}
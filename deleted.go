@@ -0,0 +1,31 @@
+package main
+
+import "net/http"
+
+// getDeletedPeople lists soft-deleted people, the recycle bin kept out of GET /people.
+// It takes the same filters and ?limit=/?offset= pagination as the main list.
+func getDeletedPeople(w http.ResponseWriter, r *http.Request) {
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := applyPeopleFilters(conn.Unscoped().Where("deleted_at IS NOT NULL"), r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Most recently deleted first; pagination adds id as a tie-breaker
+	query, err = applyPagination(query.Order("deleted_at DESC"), r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	people := []Person{}
+	if err := query.Find(&people).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, people)
+}
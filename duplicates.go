@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDuplicateCreateWindow is how close together two identical creates must be to be reported
+const defaultDuplicateCreateWindow = 5 * time.Second
+
+type recentCreate struct {
+	requestID string
+	at        time.Time
+}
+
+// recentCreates remembers the latest create per full name, for spotting client double-submits
+var recentCreates = struct {
+	sync.Mutex
+	byName map[string]recentCreate
+}{byName: map[string]recentCreate{}}
+
+// warnOnDuplicateCreate logs a warning when a create for the same name, surname and
+// patronymic arrived within DUPLICATE_CREATE_WINDOW. It only reports; it never rejects.
+func warnOnDuplicateCreate(person *Person, requestID string) {
+	window := duplicateCreateWindowSetting.get()
+	key := strings.ToLower(strings.Join([]string{person.Name, person.Surname, person.Patronymic}, "\x00"))
+	now := time.Now()
+
+	recentCreates.Lock()
+	defer recentCreates.Unlock()
+
+	if previous, ok := recentCreates.byName[key]; ok && now.Sub(previous.at) <= window {
+		log.Printf("Warning: possible duplicate create, request %s repeats request %s from %s ago",
+			requestID, previous.requestID, now.Sub(previous.at).Round(time.Millisecond))
+	}
+	recentCreates.byName[key] = recentCreate{requestID: requestID, at: now}
+
+	for k, c := range recentCreates.byName {
+		if now.Sub(c.at) > window {
+			delete(recentCreates.byName, k)
+		}
+	}
+}
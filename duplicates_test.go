@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateCreatesLogged(t *testing.T) {
+	server, _ := newTestServer(t)
+	recentCreates.byName = map[string]recentCreate{}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	body := `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`
+	for _, id := range []string{"first-create", "second-create"} {
+		if resp, data := call(t, server, http.MethodPost, "/people", body, "X-Request-ID", id); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Create answered %d: %s", resp.StatusCode, data)
+		}
+	}
+	if !strings.Contains(logged.String(), "request second-create repeats request first-create") {
+		t.Errorf("Logged:\n%s\nwant a duplicate warning naming both requests", logged.String())
+	}
+
+	logged.Reset()
+	call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy","surname":"Petrov"}`, "X-Request-ID", "other-create")
+	if strings.Contains(logged.String(), "duplicate") {
+		t.Errorf("A different person was reported as a duplicate:\n%s", logged.String())
+	}
+}
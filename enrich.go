@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/resty.v1"
+)
+
+// enrichment holds the values derived from the external APIs for a name
+type enrichment struct {
+	// Age is only meaningful when AgeKnown; Agify answers null for names it doesn't know
+	Age               int
+	AgeKnown          bool
+	Gender            string
+	GenderProbability float64
+	Nationality       string
+	Nationalities     NationalityCandidates
+
+	// Answered lists the providers that responded; Failures maps each provider
+	// that could not be queried to its error. Disabled providers appear in neither.
+	Answered map[string]bool
+	Failures map[string]error `json:"-"`
+
+	// Responses holds each answering provider's raw body and Decisions explains
+	// how the values were derived; both are only surfaced by ?debug=true
+	Responses map[string]json.RawMessage
+	Decisions []string
+}
+
+// err summarizes the provider failures, or returns nil when every provider answered
+func (e enrichment) err() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return newDomainError(errUpstream, "enrichment failed for %s", strings.Join(names, ", "))
+}
+
+// provider is one enrichment API. Its base URL is read from EnvVar, and
+// FallbackEnvVar may list comma-separated alternates with the same response
+// shape, tried in order when the primary fails.
+type provider struct {
+	Name           string
+	EnvVar         string
+	FallbackEnvVar string
+}
+
+var (
+	agifyProvider       = provider{"agify", "AGIFY_API", "AGIFY_FALLBACK_APIS"}
+	genderizeProvider   = provider{"genderize", "GENDERIZE_API", "GENDERIZE_FALLBACK_APIS"}
+	nationalizeProvider = provider{"nationalize", "NATIONALIZE_API", "NATIONALIZE_FALLBACK_APIS"}
+)
+
+// enrichmentProviders is the registry of every provider used during enrichment
+var enrichmentProviders = []provider{agifyProvider, genderizeProvider, nationalizeProvider}
+
+var client = resty.New()
+
+// enrichGroup collapses concurrent lookups of the same name into one set of upstream calls
+var enrichGroup singleflight.Group
+
+// baseURLs returns the primary base URL followed by the configured fallbacks
+func (p provider) baseURLs() []string {
+	urls := []string{p.urlSetting().get()}
+	for _, u := range strings.Split(p.fallbackSetting().get(), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// defaultEnrichTimeout bounds each provider call when neither the provider's
+// timeout nor ENRICH_TIMEOUT is set
+const defaultEnrichTimeout = 10 * time.Second
+
+// timeout bounds one call to p, read from e.g. NATIONALIZE_TIMEOUT and falling back to ENRICH_TIMEOUT
+func (p provider) timeout() time.Duration {
+	return p.timeoutSetting().get()
+}
+
+// fetch queries the provider chain for name, decoding the first successful response
+// into result and also returning its raw body
+func (p provider) fetch(ctx context.Context, name string, result interface{}) (json.RawMessage, error) {
+	return p.fetchQuery(ctx, url.Values{"name": {name}}, result)
+}
+
+// fetchQuery is fetch with arbitrary query params, such as a country_id hint.
+// The request ID carried by ctx is forwarded so upstream logs can be correlated.
+// Failures aren't logged: the error of the last URL tried is returned for the
+// caller to handle.
+func (p provider) fetchQuery(ctx context.Context, query url.Values, result interface{}) (json.RawMessage, error) {
+	var lastErr error
+	for _, baseURL := range p.baseURLs() {
+		start := time.Now()
+		// Each URL gets the full timeout, so a hung primary still leaves time for the fallbacks
+		callCtx, cancel := context.WithTimeout(ctx, p.timeout())
+		req := client.R().SetContext(callCtx)
+		if id := requestIDFromContext(ctx); id != "" {
+			req.SetHeader(requestIDHeaderName(), id)
+		}
+		resp, err := req.Get(fmt.Sprintf("%s/?%s", baseURL, query.Encode()))
+		cancel()
+		if resp != nil && resp.RawResponse != nil {
+			recordQuota(p.Name, resp.Header())
+		}
+		if err == nil && resp.StatusCode() == http.StatusTooManyRequests {
+			err = fmt.Errorf("%w: %s responded with status %d", errQuotaExhausted, baseURL, resp.StatusCode())
+		} else if err == nil && resp.IsError() {
+			err = fmt.Errorf("%s responded with status %d", baseURL, resp.StatusCode())
+		}
+		if err == nil {
+			if err = decodeProviderResponse(resp.Body(), result); err != nil {
+				providerMetrics.malformed.Add(p.Name, 1)
+				err = fmt.Errorf("unexpected response from %s: %w", baseURL, err)
+			}
+		}
+		if err != nil && privacyMode() {
+			// Transport errors quote the request URL, which contains the name
+			err = redactedError{redactName(err.Error(), query.Get("name")), err}
+		}
+		observeProviderCall(p.Name, query.Get("name"), time.Since(start), err)
+		if err == nil {
+			return json.RawMessage(resp.Body()), nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// hintedQuery is the provider query for name, with the country_id hint when country is set
+func hintedQuery(name, country string) url.Values {
+	query := url.Values{"name": {name}}
+	if country != "" {
+		query.Set("country_id", country)
+	}
+	return query
+}
+
+var (
+	// errMalformedResponse marks a provider body that isn't the JSON object expected
+	errMalformedResponse = errors.New("malformed provider response")
+	// errProviderError marks a successful status whose body reports an error instead of data
+	errProviderError = errors.New("provider reported an error")
+)
+
+// decodeProviderResponse decodes body into result, rejecting non-JSON bodies,
+// error-shaped objects such as {"error":"..."} and fields of the wrong type
+func decodeProviderResponse(body []byte, result interface{}) error {
+	var shape map[string]json.RawMessage
+	if err := json.Unmarshal(body, &shape); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedResponse, err)
+	}
+	if message, ok := shape["error"]; ok {
+		return fmt.Errorf("%w: %s", errProviderError, message)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedResponse, err)
+	}
+	return nil
+}
+
+// enrichPersonData fills the derived fields of person, returning an error if any provider failed.
+// Failures for a person that is already stored are also recorded in the deadletter table.
+func enrichPersonData(ctx context.Context, person *Person) error {
+	e := getEnrichedData(ctx, inputOf(person))
+	err := applyEnrichment(person, e)
+	if err != nil && person.ID != 0 {
+		recordEnrichmentFailure(person.ID, e)
+	}
+	return err
+}
+
+// applyEnrichment copies the values of e into person
+func applyEnrichment(person *Person, e enrichment) error {
+	// Only fields whose provider answered are replaced, each stamped with its own time
+	now := time.Now()
+	if e.Answered[agifyProvider.Name] {
+		// A null age from Agify leaves the stored age alone rather than zeroing it
+		if e.AgeKnown {
+			person.Age = intPtr(e.Age)
+		}
+		person.AgeEnrichedAt = &now
+	}
+	if e.Answered[genderizeProvider.Name] {
+		person.Gender = e.storedGender()
+		person.GenderProbability = e.GenderProbability
+		person.GenderEnrichedAt = &now
+	}
+	if e.Answered[nationalizeProvider.Name] {
+		person.Nationality = e.Nationality
+		person.Nationalities = e.Nationalities
+		person.NationalityEnrichedAt = &now
+	}
+
+	if err := e.err(); err != nil {
+		return err
+	}
+	person.EnrichedAt = &now
+	return nil
+}
+
+// storedGender is the gender to store for e. Genderize answers null for names it
+// doesn't know; DEFAULT_GENDER (e.g. "unknown") is then used for consumers that
+// need a value, otherwise the gender stays empty.
+func (e enrichment) storedGender() string {
+	if e.Gender == "" && e.Answered[genderizeProvider.Name] {
+		return defaultGenderSetting.get()
+	}
+	return e.Gender
+}
+
+// Plausible Agify ages when AGE_PLAUSIBLE_MIN and AGE_PLAUSIBLE_MAX are unset
+const (
+	defaultAgePlausibleMin = 1
+	defaultAgePlausibleMax = 120
+)
+
+// defaultEnrichmentFreshness is how long enrichment stays fresh when ENRICHMENT_FRESHNESS is unset
+const defaultEnrichmentFreshness = 24 * time.Hour
+
+// enrichmentFresh reports whether person was fully enriched within the freshness window
+func enrichmentFresh(person *Person) bool {
+	if person.EnrichedAt == nil {
+		return false
+	}
+	return time.Since(*person.EnrichedAt) < enrichmentFreshnessSetting.get()
+}
+
+// getEnrichedData answers from enrichCache when it can, otherwise queries the providers
+// and caches the result if every one of them answered
+func getEnrichedData(ctx context.Context, in enrichmentInput) enrichment {
+	key := enrichmentCacheKey(in)
+	if e, ok := enrichCache.get(key); ok {
+		e.Decisions = append(append([]string(nil), e.Decisions...), "served from the enrichment cache")
+		return e
+	}
+
+	v, _, _ := enrichGroup.Do(key, func() (interface{}, error) {
+		// The lookup is shared with concurrent callers, so one of them going away mustn't cancel it
+		e := fetchEnrichedData(context.WithoutCancel(ctx), in)
+		if cacheable(e) {
+			enrichCache.set(key, e)
+		}
+		return e, nil
+	})
+
+	return v.(enrichment)
+}
+
+func fetchEnrichedData(ctx context.Context, in enrichmentInput) enrichment {
+	e := enrichment{
+		Answered:  map[string]bool{},
+		Failures:  map[string]error{},
+		Responses: map[string]json.RawMessage{},
+	}
+
+	// Disabled providers are skipped, leaving their fields empty
+	if agifyProvider.enabled() {
+		e.enrichFrom(agifyProvider, in, func(field, value string) (float64, func(*enrichment), json.RawMessage, error) {
+			age, raw, err := getAgifyAge(ctx, value, in.Country)
+			if age != nil {
+				age = plausibleAge(value, *age)
+			}
+			if age == nil {
+				return 0, func(e *enrichment) { e.decide("agify has no age for %s", field) }, raw, err
+			}
+			return 1, func(e *enrichment) { e.Age, e.AgeKnown = *age, true }, raw, err
+		})
+	} else {
+		e.decide("%s is disabled, age not enriched", agifyProvider.Name)
+	}
+	if genderizeProvider.enabled() {
+		e.enrichFrom(genderizeProvider, in, func(field, value string) (float64, func(*enrichment), json.RawMessage, error) {
+			if field == "patronymic" {
+				// Patronymic suffixes are decisive, so they are read locally rather than asked of Genderize
+				gender := patronymicGender(value)
+				score := 0.0
+				if gender != "" {
+					score = 1
+				}
+				raw, _ := json.Marshal(map[string]string{"patronymic": value, "gender": gender})
+				return score, func(e *enrichment) { e.Gender, e.GenderProbability = gender, score }, raw, nil
+			}
+			gender, probability, raw, err := getGenderizeGender(ctx, value, in.Country)
+			return probability, func(e *enrichment) { e.Gender, e.GenderProbability = gender, probability }, raw, err
+		})
+	} else {
+		e.decide("%s is disabled, gender not enriched", genderizeProvider.Name)
+	}
+	if nationalizeProvider.enabled() {
+		e.enrichFrom(nationalizeProvider, in, func(field, value string) (float64, func(*enrichment), json.RawMessage, error) {
+			candidates, raw, err := getNationalities(ctx, value)
+			score := 0.0
+			if len(candidates) > 0 {
+				score = candidates[0].Probability
+			}
+			return score, func(e *enrichment) { e.Nationalities = candidates }, raw, err
+		})
+	} else {
+		e.decide("%s is disabled, nationality not enriched", nationalizeProvider.Name)
+	}
+
+	if len(e.Nationalities) > 0 {
+		e.Nationality = e.Nationalities[0].CountryID
+		e.decide("nationality %s chosen from %d candidates", e.Nationality, len(e.Nationalities))
+	}
+
+	// The provider calls return their errors unlogged, so each failure is logged once here
+	for _, p := range enrichmentProviders {
+		if err, failed := e.Failures[p.Name]; failed {
+			log.Printf("%s Error fetching %s data: %v", logPrefix(ctx), p.Name, err)
+		}
+	}
+
+	return e
+}
+
+// record notes the outcome of one provider call
+func (e *enrichment) record(p provider, raw json.RawMessage, err error) {
+	if err != nil {
+		e.Failures[p.Name] = err
+		e.decide("%s failed: %v", p.Name, err)
+		return
+	}
+	e.Answered[p.Name] = true
+	e.Responses[p.Name] = raw
+}
+
+// decide appends a debug note explaining how the enrichment was derived
+func (e *enrichment) decide(format string, args ...interface{}) {
+	e.Decisions = append(e.Decisions, fmt.Sprintf(format, args...))
+}
+
+// getAgifyAge returns the age Agify estimates for name, or nil when it answered null.
+// A non-empty country is sent as the country_id hint.
+func getAgifyAge(ctx context.Context, name, country string) (*int, json.RawMessage, error) {
+	var response map[string]interface{}
+	raw, err := agifyProvider.fetchQuery(ctx, hintedQuery(name, country), &response)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	age, ok := response["age"].(float64)
+	if !ok {
+		return nil, raw, nil
+	}
+	rounded := roundAge(age)
+	return &rounded, raw, nil
+}
+
+// plausibleAge checks an Agify age against AGE_PLAUSIBLE_MIN and AGE_PLAUSIBLE_MAX
+// (default 1 to 120); Agify sometimes answers 0 or absurd ages for rare names.
+// Ages outside the range are dropped, or with AGE_IMPLAUSIBLE=clamp moved to
+// the nearest bound.
+func plausibleAge(name string, age int) *int {
+	min := agePlausibleMinSetting.get()
+	max := agePlausibleMaxSetting.get()
+	if age >= min && age <= max {
+		return &age
+	}
+
+	if ageImplausibleSetting.get() != "clamp" {
+		log.Printf("Dropping implausible age %d for %s, expected %d to %d", age, logName(name), min, max)
+		return nil
+	}
+	clamped := min
+	if age > max {
+		clamped = max
+	}
+	log.Printf("Clamping implausible age %d for %s to %d", age, logName(name), clamped)
+	return &clamped
+}
+
+// roundAge converts an Agify age to whole years as set by AGE_ROUNDING:
+// truncate (the default), round or ceil
+func roundAge(age float64) int {
+	switch ageRoundingSetting.get() {
+	case "round":
+		return int(math.Round(age))
+	case "ceil":
+		return int(math.Ceil(age))
+	default:
+		return int(age)
+	}
+}
+
+func getGenderizeGender(ctx context.Context, name, country string) (string, float64, json.RawMessage, error) {
+	var response map[string]interface{}
+	raw, err := genderizeProvider.fetchQuery(ctx, hintedQuery(name, country), &response)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	gender, _ := response["gender"].(string)
+	probability, _ := response["probability"].(float64)
+	return gender, probability, raw, nil
+}
+
+// getNationalities returns the most likely countries for name, best first
+func getNationalities(ctx context.Context, name string) (NationalityCandidates, json.RawMessage, error) {
+	var response nationalizeResponse
+	raw, err := nationalizeProvider.fetch(ctx, name, &response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return topNationalityCandidates(response.candidates()), raw, nil
+}
+
+// nationalizeResponse is Nationalize's answer: the countries a name is likely from
+type nationalizeResponse struct {
+	Country []struct {
+		CountryID   string  `json:"country_id"`
+		Probability float64 `json:"probability"`
+	} `json:"country"`
+}
+
+// candidates converts the answered codes to the storage format, dropping unknown
+// ones when UNKNOWN_NATIONALITY=null. Codes that end up the same, such as an
+// alpha-2 and an alpha-3 code of one country, are merged by summing their
+// probabilities. The result is sorted by descending probability.
+func (r nationalizeResponse) candidates() NationalityCandidates {
+	candidates := make(NationalityCandidates, 0, len(r.Country))
+	index := map[string]int{}
+	for _, c := range r.Country {
+		// Nationalize answers alpha-2 codes; they are stored as NATIONALITY_FORMAT says
+		code := c.CountryID
+		if converted, ok := convertCountryCode(code, nationalityFormat()); ok {
+			code = converted
+		} else if code = checkKnownCountryCode(nationalizeProvider.Name, code); code == "" {
+			continue
+		}
+		if i, ok := index[code]; ok {
+			candidates[i].Probability += c.Probability
+			continue
+		}
+		index[code] = len(candidates)
+		candidates = append(candidates, NationalityCandidate{CountryID: code, Probability: c.Probability})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+	return candidates
+}
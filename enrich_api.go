@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hintComparison holds a provider value looked up with and without the country hint
+type hintComparison struct {
+	WithoutCountry interface{}
+	WithCountry    interface{}
+}
+
+// EnrichComparison shows how the country hint changes Agify and Genderize results for a name
+type EnrichComparison struct {
+	Name              string
+	Country           string
+	Age               hintComparison
+	GenderProbability hintComparison
+}
+
+// queryName reads the ?name= the enrichment endpoints look up. A missing one is
+// answered 400, and one failing name validation 422 as it would be on create.
+func queryName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("name")
+	if strings.TrimSpace(name) == "" {
+		respondError(w, http.StatusBadRequest, "A valid name is required")
+		return "", false
+	}
+	if err := validateName("Name", name); err != nil {
+		respondServiceError(w, err, "Internal server error")
+		return "", false
+	}
+	return name, true
+}
+
+func compareEnrichment(w http.ResponseWriter, r *http.Request) {
+	name, ok := queryName(w, r)
+	if !ok {
+		return
+	}
+
+	country := strings.ToUpper(r.URL.Query().Get("country"))
+	if !isCountryCode(country) {
+		respondError(w, http.StatusBadRequest, "A valid country code is required")
+		return
+	}
+
+	plain := url.Values{"name": {lookupName(name)}}
+	hinted := url.Values{"name": {lookupName(name)}, "country_id": {country}}
+
+	comparison := EnrichComparison{Name: name, Country: country}
+	comparison.Age.WithoutCountry = providerField(r.Context(), agifyProvider, plain, "age")
+	comparison.Age.WithCountry = providerField(r.Context(), agifyProvider, hinted, "age")
+	comparison.GenderProbability.WithoutCountry = providerField(r.Context(), genderizeProvider, plain, "probability")
+	comparison.GenderProbability.WithCountry = providerField(r.Context(), genderizeProvider, hinted, "probability")
+
+	respondJSON(w, http.StatusOK, comparison)
+}
+
+// providerField returns one field of the provider's response, or nil when the
+// provider is disabled or the call fails
+func providerField(ctx context.Context, p provider, query url.Values, field string) interface{} {
+	if !p.enabled() {
+		return nil
+	}
+	var response map[string]interface{}
+	if _, err := p.fetchQuery(ctx, query, &response); err != nil {
+		log.Printf("%s Error fetching %s data for comparison: %v", logPrefix(ctx), p.Name, err)
+		return nil
+	}
+	return response[field]
+}
+
+// EnrichCheck is a dry-run enrichment of a name together with the providers' remaining quotas
+type EnrichCheck struct {
+	Name              string
+	Age               *int
+	Gender            string
+	GenderProbability float64
+	Nationality       string
+	Nationalities     NationalityCandidates
+	Errors            map[string]string `json:",omitempty"`
+	Quotas            map[string]ProviderQuota
+}
+
+// checkEnrichment enriches ?name=, hinted with ?country= when given, without persisting
+// anything, reporting the quota left at each provider
+func checkEnrichment(w http.ResponseWriter, r *http.Request) {
+	name, ok := queryName(w, r)
+	if !ok {
+		return
+	}
+
+	country := strings.ToUpper(r.URL.Query().Get("country"))
+	if country != "" && !isCountryCode(country) {
+		respondError(w, http.StatusBadRequest, "A valid country code is required")
+		return
+	}
+
+	e := getEnrichedData(r.Context(), enrichmentInput{Name: name, Country: country})
+	check := EnrichCheck{
+		Name:              name,
+		Age:               e.age(),
+		Gender:            e.storedGender(),
+		GenderProbability: e.GenderProbability,
+		Nationality:       e.Nationality,
+		Nationalities:     e.Nationalities,
+		Quotas:            quotaSnapshot(),
+	}
+	for provider, err := range e.Failures {
+		if check.Errors == nil {
+			check.Errors = map[string]string{}
+		}
+		check.Errors[provider] = err.Error()
+	}
+
+	respondJSON(w, http.StatusOK, check)
+}
+
+// EnrichBatchItem is the enrichment of one name from POST /enrich/batch
+type EnrichBatchItem struct {
+	Name              string
+	Age               *int
+	Gender            string
+	GenderProbability float64
+	Nationality       string
+	Error             string `json:",omitempty"`
+}
+
+// enrichBatch enriches a JSON array of names without touching the database,
+// answering in the order the names were given.
+func enrichBatch(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if !decodeJSONBody(w, r, &names) || batchTooLarge(w, len(names)) {
+		return
+	}
+
+	items := make([]EnrichBatchItem, len(names))
+	errs := runBoundedContext(r.Context(), len(names), func(i int) error {
+		items[i] = enrichBatchItem(r.Context(), names[i])
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			items[i] = EnrichBatchItem{Name: names[i], Error: batchCanceled}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, items)
+}
+
+func enrichBatchItem(ctx context.Context, name string) EnrichBatchItem {
+	item := EnrichBatchItem{Name: name}
+	if err := validatePerson(&Person{Name: name}); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	e := getEnrichedData(ctx, enrichmentInput{Name: name})
+	item.Age = e.age()
+	item.Gender = e.storedGender()
+	item.GenderProbability = e.GenderProbability
+	item.Nationality = e.Nationality
+	if err := e.err(); err != nil {
+		item.Error = err.Error()
+	}
+	return item
+}
+
+// EnrichDistribution is the full demographic picture the providers give for a name
+type EnrichDistribution struct {
+	Name string
+	// Gender maps male and female to their probability; empty when Genderize doesn't know the name
+	Gender      map[string]float64
+	GenderCount int
+	// Nationalities is every candidate Nationalize returned, best first, not cut to NATIONALITY_CANDIDATES
+	Nationalities NationalityCandidates
+	Errors        map[string]string `json:",omitempty"`
+}
+
+// getDistribution reports the gender split and full nationality distribution for ?name=
+// without persisting anything
+func getDistribution(w http.ResponseWriter, r *http.Request) {
+	name, ok := queryName(w, r)
+	if !ok {
+		return
+	}
+
+	distribution := EnrichDistribution{Name: name, Gender: map[string]float64{}, Nationalities: NationalityCandidates{}}
+	fail := func(p provider, err error) {
+		if distribution.Errors == nil {
+			distribution.Errors = map[string]string{}
+		}
+		distribution.Errors[p.Name] = err.Error()
+	}
+
+	var gender struct {
+		Gender      *string `json:"gender"`
+		Probability float64 `json:"probability"`
+		Count       int     `json:"count"`
+	}
+	if !genderizeProvider.enabled() {
+		fail(genderizeProvider, errProviderDisabled)
+	} else if _, err := genderizeProvider.fetch(r.Context(), lookupName(name), &gender); err != nil {
+		fail(genderizeProvider, err)
+	} else if gender.Gender != nil {
+		other := "female"
+		if *gender.Gender == "female" {
+			other = "male"
+		}
+		distribution.Gender[*gender.Gender] = gender.Probability
+		distribution.Gender[other] = 1 - gender.Probability
+		distribution.GenderCount = gender.Count
+	}
+
+	var nationality nationalizeResponse
+	if !nationalizeProvider.enabled() {
+		fail(nationalizeProvider, errProviderDisabled)
+	} else if _, err := nationalizeProvider.fetch(r.Context(), lookupName(name), &nationality); err != nil {
+		fail(nationalizeProvider, err)
+	} else {
+		// The full distribution, normalized as for a stored person but not cut to NATIONALITY_CANDIDATES
+		distribution.Nationalities = nationality.candidates()
+	}
+
+	respondJSON(w, http.StatusOK, distribution)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDistributionNormalizesNationalities(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("NATIONALITY_FORMAT", nationalityAlpha3)
+	t.Setenv("UNKNOWN_NATIONALITY", "null")
+	stub.answer(nationalizeProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, `{"country":[{"country_id":"UA","probability":0.3},{"country_id":"RU","probability":0.25},{"country_id":"RUS","probability":0.2},{"country_id":"XX","probability":0.1}]}`
+	})
+
+	resp, data := call(t, server, http.MethodGet, "/enrich/distribution?name=Dmitriy", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /enrich/distribution answered %d: %s", resp.StatusCode, data)
+	}
+	var distribution EnrichDistribution
+	decode(t, data, &distribution)
+
+	got := distribution.Nationalities
+	if len(got) != 2 || got[0].CountryID != "RUS" || got[1].CountryID != "UKR" {
+		t.Fatalf("Nationalities = %+v, want RUS and UKR", got)
+	}
+	if math.Abs(got[0].Probability-0.45) > 1e-9 {
+		t.Errorf("RUS probability = %v, want the merged 0.45", got[0].Probability)
+	}
+}
+
+func TestEnrichEndpointsValidateNames(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for _, path := range []string{"/enrich/compare?country=RU&", "/enrich/check?", "/enrich/distribution?"} {
+		for query, want := range map[string]int{
+			"":          http.StatusBadRequest,
+			"name=R2D2": http.StatusUnprocessableEntity,
+			"name=Ivan": http.StatusOK,
+		} {
+			resp, data := call(t, server, http.MethodGet, path+query, "")
+			if resp.StatusCode != want {
+				t.Errorf("GET %s%s answered %d, want %d: %s", path, query, resp.StatusCode, want, data)
+			}
+			if want == http.StatusUnprocessableEntity && !strings.Contains(string(data), "may only contain letters") {
+				t.Errorf("GET %s%s reported %s, want why the name is invalid", path, query, data)
+			}
+		}
+	}
+}
+
+func TestCheckReportsEnrichmentAndQuotas(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.setHeaders(agifyProvider.Name, http.Header{
+		"X-Rate-Limit-Limit":     {"1000"},
+		"X-Rate-Limit-Remaining": {"998"},
+		"X-Rate-Limit-Reset":     {"3600"},
+	})
+	stub.setHeaders(genderizeProvider.Name, http.Header{"X-Rate-Limit-Remaining": {"5"}})
+
+	resp, data := call(t, server, http.MethodGet, "/enrich/check?name=Dmitriy", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /enrich/check answered %d: %s", resp.StatusCode, data)
+	}
+	var check EnrichCheck
+	decode(t, data, &check)
+	if check.Age == nil || *check.Age != 42 || check.Gender != "male" || check.Nationality != "RU" {
+		t.Errorf("Check = %+v, want the stubbed enrichment", check)
+	}
+
+	agify := check.Quotas[agifyProvider.Name]
+	if agify.Limit == nil || *agify.Limit != 1000 || agify.Remaining == nil || *agify.Remaining != 998 || agify.ResetSeconds == nil || *agify.ResetSeconds != 3600 {
+		t.Errorf("Agify quota = %+v, want 998 of 1000 left for 3600s", agify)
+	}
+	if genderize := check.Quotas[genderizeProvider.Name]; genderize.Remaining == nil || *genderize.Remaining != 5 || genderize.Limit != nil {
+		t.Errorf("Genderize quota = %+v, want only 5 remaining", genderize)
+	}
+	if _, ok := check.Quotas[nationalizeProvider.Name]; ok {
+		t.Errorf("Reported a quota for nationalize, which sent no rate-limit headers")
+	}
+
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("Check stored %d people, want none", stored)
+	}
+}
+
+func TestEnrichBatchKeepsOrder(t *testing.T) {
+	server, stub := newTestServer(t)
+	ages := map[string]int{"Dmitriy": 40, "Anna": 25, "Ivan": 61, "Olga": 33}
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, fmt.Sprintf(`{"age":%d}`, ages[name])
+	})
+	stub.answer(genderizeProvider.Name, func(name string) (int, string) {
+		if name == "Anna" || name == "Olga" {
+			return http.StatusOK, `{"gender":"female","probability":0.98}`
+		}
+		return http.StatusOK, `{"gender":"male","probability":0.99}`
+	})
+
+	resp, data := call(t, server, http.MethodPost, "/enrich/batch", `["Dmitriy","Anna","R2D2","Ivan","Olga"]`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /enrich/batch answered %d: %s", resp.StatusCode, data)
+	}
+	var items []EnrichBatchItem
+	decode(t, data, &items)
+	if len(items) != 5 {
+		t.Fatalf("Got %d items, want 5", len(items))
+	}
+	for i, want := range []struct {
+		name, gender string
+	}{{"Dmitriy", "male"}, {"Anna", "female"}, {"R2D2", ""}, {"Ivan", "male"}, {"Olga", "female"}} {
+		item := items[i]
+		if item.Name != want.name {
+			t.Errorf("Item %d is %q, want %q", i, item.Name, want.name)
+			continue
+		}
+		if want.name == "R2D2" {
+			if item.Error == "" || item.Age != nil {
+				t.Errorf("Invalid name enriched: %+v", item)
+			}
+			continue
+		}
+		if item.Error != "" || item.Age == nil || *item.Age != ages[want.name] || item.Gender != want.gender || item.Nationality != "RU" {
+			t.Errorf("Item %d = %+v, want %s enriched", i, item, want.name)
+		}
+	}
+
+	var stored int
+	db.Model(&Person{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("Batch enrichment stored %d people, want none", stored)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// enrichCSVColumns are appended to every row of a POST /enrich/csv upload
+var enrichCSVColumns = []string{"Age", "Gender", "Nationality", "Error"}
+
+// defaultMaxCSVBytes bounds a POST /enrich/csv upload when MAX_CSV_BYTES is unset
+const defaultMaxCSVBytes = 1 << 20
+
+// enrichCSV enriches the names in the first column of an uploaded CSV and answers
+// the same rows with enrichCSVColumns appended, persisting nothing. A first row
+// whose first cell is "name" is taken as a header and extended with the column
+// names. Names are enriched like POST /enrich/batch, at most ENRICH_CONCURRENCY
+// at a time and MAX_BATCH_SIZE per upload; rows are streamed back in upload
+// order as soon as they and the rows before them are done. Uploads are read
+// a row at a time, refused with 413 past MAX_CSV_BYTES (default 1 MiB) or
+// MAX_BATCH_SIZE rows without reading the rest.
+func enrichCSV(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, int64(maxCSVBytesSetting.get()))
+	defer body.Close()
+
+	reader := csv.NewReader(body)
+	// Rows may carry extra columns, which are passed through untouched
+	reader.FieldsPerRecord = -1
+
+	maxRows := maxBatchSizeSetting.get()
+	var header []string
+	var rows [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("A CSV upload can be at most %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid CSV payload")
+			return
+		}
+
+		if header == nil && rows == nil && len(row) > 0 && strings.EqualFold(strings.TrimSpace(row[0]), "name") {
+			header = row
+			continue
+		}
+		rows = append(rows, row)
+		if len(rows) > maxRows {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("A batch can hold at most %d items", maxRows))
+			return
+		}
+	}
+
+	items := make([]EnrichBatchItem, len(rows))
+	done := make([]chan struct{}, len(rows))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go func() {
+		errs := runBoundedContext(r.Context(), len(rows), func(i int) error {
+			defer close(done[i])
+			// The CSV reader skips blank lines, so every row has a first cell
+			items[i] = enrichBatchItem(r.Context(), rows[i][0])
+			return nil
+		})
+		for i, err := range errs {
+			if err != nil {
+				items[i] = EnrichBatchItem{Error: batchCanceled}
+				close(done[i])
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="enriched.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	if header != nil {
+		out.Write(append(header, enrichCSVColumns...))
+	}
+	for i, row := range rows {
+		<-done[i]
+		out.Write(append(row, enrichCSVRow(items[i])...))
+		out.Flush()
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	out.Flush()
+}
+
+// enrichCSVRow renders item as the values of enrichCSVColumns
+func enrichCSVRow(item EnrichBatchItem) []string {
+	age := ""
+	if item.Age != nil {
+		age = strconv.Itoa(*item.Age)
+	}
+	return []string{age, item.Gender, item.Nationality, item.Error}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEnrichCSV(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, data := call(t, server, http.MethodPost, "/enrich/csv", "name,note\nDmitriy,first\nIvan,second\n", "Content-Type", "text/csv")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CSV upload answered %d: %s", resp.StatusCode, data)
+	}
+	want := "name,note,Age,Gender,Nationality,Error\nDmitriy,first,42,male,RU,\nIvan,second,42,male,RU,\n"
+	if string(data) != want {
+		t.Errorf("Got\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestEnrichCSVLimits(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("MAX_BATCH_SIZE", "2")
+
+	resp, data := call(t, server, http.MethodPost, "/enrich/csv", "name\nAnna\nBoris\nVera\n", "Content-Type", "text/csv")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload past MAX_BATCH_SIZE answered %d: %s", resp.StatusCode, data)
+	}
+
+	t.Setenv("MAX_CSV_BYTES", "64")
+	resp, data = call(t, server, http.MethodPost, "/enrich/csv", "name\n"+strings.Repeat("x", 100)+"\n", "Content-Type", "text/csv")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload past MAX_CSV_BYTES answered %d: %s", resp.StatusCode, data)
+	}
+
+	if n := stub.callCount(agifyProvider.Name); n != 0 {
+		t.Errorf("Refused uploads were enriched: agify called %d times", n)
+	}
+}
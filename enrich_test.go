@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentLookupsShareProviderCalls(t *testing.T) {
+	_, stub := newTestServer(t)
+	// Without a cache only singleflight can keep the lookups from each calling out
+	enrichCache = noCache{}
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return http.StatusOK, `{"age":42}`
+	})
+
+	// The providers only see the first name, so the surnames don't split the lookup
+	surnames := []string{"Ivanov", "Petrov"}
+	const lookups = 20
+	results := make([]enrichment, lookups)
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = getEnrichedData(context.Background(), enrichmentInput{Name: "Dmitriy", Surname: surnames[i%2]})
+		}(i)
+	}
+
+	<-started
+	// Give the remaining lookups time to join the one in flight
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, p := range enrichmentProviders {
+		if n := stub.callCount(p.Name); n != 1 {
+			t.Errorf("%s called %d times for %d lookups of one name, want 1", p.Name, n, lookups)
+		}
+	}
+	for i, e := range results {
+		if !e.AgeKnown || e.Age != 42 {
+			t.Errorf("Lookup %d got age %d (known %v), want 42", i, e.Age, e.AgeKnown)
+		}
+	}
+}
+
+func TestLookupKeyUsesSourceFieldsOnly(t *testing.T) {
+	newTestServer(t)
+	ivanov := enrichmentInput{Name: "Dmitriy", Surname: "Ivanov", Patronymic: "Sergeevich"}
+	petrov := enrichmentInput{Name: "Dmitriy", Surname: "Petrov"}
+	if ivanov.key() != petrov.key() {
+		t.Errorf("%q and %q have different keys though only the name is looked up", ivanov, petrov)
+	}
+	if hinted := (enrichmentInput{Name: "Dmitriy", Country: "RU"}); hinted.key() == petrov.key() {
+		t.Errorf("A country hint doesn't change the key")
+	}
+
+	t.Setenv("AGIFY_SOURCES", "surname,name")
+	if ivanov.key() == petrov.key() {
+		t.Errorf("%q and %q share a key though Agify is fed the surname", ivanov, petrov)
+	}
+}
+
+func TestCacheKeyFollowsEnrichmentSettings(t *testing.T) {
+	_, stub := newTestServer(t)
+	ctx := context.Background()
+	if e := getEnrichedData(ctx, enrichmentInput{Name: "Dmitriy"}); !e.AgeKnown {
+		t.Fatalf("Age unknown before any setting changed: %+v", e)
+	}
+
+	// An age cached before the plausible range narrowed mustn't be served after
+	t.Setenv("AGE_PLAUSIBLE_MAX", "30")
+	if e := getEnrichedData(ctx, enrichmentInput{Name: "Dmitriy"}); e.AgeKnown {
+		t.Errorf("Got cached age %d after AGE_PLAUSIBLE_MAX=30", e.Age)
+	}
+
+	settings := map[string]string{
+		"AGIFY_SOURCES":          "patronymic,name",
+		"ENRICH_COMBINE":         "best",
+		"AGE_ROUNDING":           "round",
+		"AGE_PLAUSIBLE_MIN":      "5",
+		"AGE_IMPLAUSIBLE":        "clamp",
+		"NATIONALITY_CANDIDATES": "1",
+		"UNKNOWN_NATIONALITY":    "null",
+	}
+	for name, value := range settings {
+		before := stub.callCount(agifyProvider.Name)
+		t.Setenv(name, value)
+		getEnrichedData(ctx, enrichmentInput{Name: "Dmitriy"})
+		if stub.callCount(agifyProvider.Name) == before {
+			t.Errorf("Served from the cache after %s changed", name)
+		}
+	}
+}
+
+// BenchmarkEnrichPerson measures the whole create-with-enrichment flow: POST /people
+// through the router, provider calls against the stubs and the insert
+func BenchmarkEnrichPerson(b *testing.B) {
+	server, _ := newTestServer(b)
+	// Every create looks the name up again rather than reusing the cache
+	enrichCache = noCache{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, data := call(b, server, http.MethodPost, "/people", `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+		if resp.StatusCode != http.StatusCreated {
+			b.Fatalf("POST /people answered %d: %s", resp.StatusCode, data)
+		}
+	}
+}
+
+// BenchmarkGetPeople measures listing a page of 100 stored people through GET /people
+func BenchmarkGetPeople(b *testing.B) {
+	server, _ := newTestServer(b)
+	for i := 0; i < 100; i++ {
+		if err := db.Create(&Person{Name: "Dmitriy", Surname: "Ushakov", Age: intPtr(42), Gender: "male", Nationality: "RU"}).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, data := call(b, server, http.MethodGet, "/people?limit=100", "")
+		if resp.StatusCode != http.StatusOK {
+			b.Fatalf("GET /people answered %d: %s", resp.StatusCode, data)
+		}
+	}
+}
+
+func BenchmarkEnrichPersonData(b *testing.B) {
+	newTestServer(b)
+	// Every iteration goes through the stubbed providers rather than the cache
+	enrichCache = noCache{}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		person := Person{Name: "Dmitriy", Surname: "Ushakov", Patronymic: "Vasilevich"}
+		if err := enrichPersonData(ctx, &person); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnrichPersonDataCached(b *testing.B) {
+	newTestServer(b)
+	ctx := context.Background()
+	getEnrichedData(ctx, enrichmentInput{Name: "Dmitriy", Surname: "Ushakov", Patronymic: "Vasilevich"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		person := Person{Name: "Dmitriy", Surname: "Ushakov", Patronymic: "Vasilevich"}
+		if err := enrichPersonData(ctx, &person); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkApplyEnrichment(b *testing.B) {
+	newTestServer(b)
+	e := getEnrichedData(context.Background(), enrichmentInput{Name: "Dmitriy"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var person Person
+		if err := applyEnrichment(&person, e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProviderFailureLoggedOnce(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.fail(agifyProvider.Name)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	createTestPerson(t, server, `{"name":"Dmitriy"}`)
+
+	if n := strings.Count(logged.String(), "Error fetching"); n != 1 {
+		t.Errorf("Agify failure logged %d times, want once:\n%s", n, logged.String())
+	}
+}
+
+func TestUpdateReenrichesOnlyStaleRecords(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("ENRICHMENT_FRESHNESS", "1h")
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	calls := stub.callCount(agifyProvider.Name)
+	if resp, data := call(t, server, http.MethodPut, path, `{"name":"Dmitriy","surname":"Petrov"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update answered %d: %s", resp.StatusCode, data)
+	}
+	if n := stub.callCount(agifyProvider.Name) - calls; n != 0 {
+		t.Errorf("Fresh record re-enriched with %d agify calls, want none", n)
+	}
+
+	db.Model(&Person{}).Where("id = ?", person.ID).UpdateColumn("enriched_at", time.Now().Add(-2*time.Hour))
+	if resp, data := call(t, server, http.MethodPut, path, `{"name":"Dmitriy","surname":"Ivanov"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update answered %d: %s", resp.StatusCode, data)
+	}
+	if n := stub.callCount(agifyProvider.Name) - calls; n != 1 {
+		t.Errorf("Stale record re-enriched with %d agify calls, want 1", n)
+	}
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.EnrichedAt == nil || time.Since(*stored.EnrichedAt) > time.Minute {
+		t.Errorf("EnrichedAt = %v after re-enrichment, want it refreshed", stored.EnrichedAt)
+	}
+}
+
+func TestFieldEnrichmentTimesUpdateIndependently(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	stub.fail(genderizeProvider.Name)
+
+	created := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	if created.AgeEnrichedAt == nil || created.NationalityEnrichedAt == nil || created.GenderEnrichedAt != nil {
+		t.Fatalf("Created %+v, want age and nationality times but no gender time", created)
+	}
+
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"male","probability":0.99}`
+	})
+	stub.fail(agifyProvider.Name)
+	time.Sleep(10 * time.Millisecond)
+	resp, data := call(t, server, http.MethodPut, fmt.Sprintf("/people/%d?force_enrich=true", created.ID), `{"name":"Dmitriy","surname":"Ushakov"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update answered %d: %s", resp.StatusCode, data)
+	}
+	var updated Person
+	decode(t, data, &updated)
+
+	if updated.GenderEnrichedAt == nil || updated.Gender != "male" {
+		t.Errorf("Updated %+v, want gender enriched now", updated)
+	}
+	if updated.AgeEnrichedAt == nil || !updated.AgeEnrichedAt.Equal(*created.AgeEnrichedAt) {
+		t.Errorf("AgeEnrichedAt = %v, want it kept at %v while Agify was down", updated.AgeEnrichedAt, created.AgeEnrichedAt)
+	}
+	if updated.NationalityEnrichedAt == nil || !updated.NationalityEnrichedAt.After(*created.NationalityEnrichedAt) {
+		t.Errorf("NationalityEnrichedAt = %v, want it refreshed after %v", updated.NationalityEnrichedAt, created.NationalityEnrichedAt)
+	}
+}
+
+func TestGenderInferredFromPatronymic(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"female","probability":0.6}`
+	})
+
+	if person := createTestPerson(t, server, `{"name":"Sasha","patronymic":"Ivanovich"}`); person.Gender != "female" {
+		t.Errorf("Gender = %q by default, want Genderize's answer for the first name", person.Gender)
+	}
+
+	t.Setenv("GENDERIZE_SOURCES", "patronymic,name")
+	calls := stub.callCount(genderizeProvider.Name)
+	for patronymic, want := range map[string]string{"Ivanovich": "male", "Петровна": "female"} {
+		person := createTestPerson(t, server, fmt.Sprintf(`{"name":"Sasha","patronymic":%q}`, patronymic))
+		if person.Gender != want || person.GenderProbability != 1 {
+			t.Errorf("Patronymic %s gave %s at %v, want %s for certain", patronymic, person.Gender, person.GenderProbability, want)
+		}
+	}
+	if n := stub.callCount(genderizeProvider.Name) - calls; n != 0 {
+		t.Errorf("Genderize called %d times though the patronymics decided", n)
+	}
+
+	// A patronymic without a known suffix falls through to the first name
+	if person := createTestPerson(t, server, `{"name":"Sasha","patronymic":"Smith"}`); person.Gender != "female" || person.GenderProbability != 0.6 {
+		t.Errorf("Unrecognized patronymic gave %s at %v, want Genderize's answer", person.Gender, person.GenderProbability)
+	}
+
+	// With the first name preferred, the best combine still lets the certain patronymic win
+	t.Setenv("GENDERIZE_SOURCES", "name,patronymic")
+	t.Setenv("ENRICH_COMBINE", "best")
+	if person := createTestPerson(t, server, `{"name":"Sasha","patronymic":"Ivanovich"}`); person.Gender != "male" {
+		t.Errorf("Best combine gave %q, want male from the patronymic", person.Gender)
+	}
+}
+
+func TestDefaultGenderForUnknownNames(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":null,"probability":0}`
+	})
+
+	if person := createTestPerson(t, server, `{"name":"Zyxa"}`); person.Gender != "" {
+		t.Errorf("Gender = %q without DEFAULT_GENDER, want it left empty", person.Gender)
+	}
+
+	t.Setenv("DEFAULT_GENDER", "unknown")
+	person := createTestPerson(t, server, `{"name":"Zyxa"}`)
+	if person.Gender != "unknown" {
+		t.Errorf("Gender = %q with DEFAULT_GENDER=unknown, want unknown", person.Gender)
+	}
+
+	// The default only stands in for a null answer, not for a failed lookup
+	stub.fail(genderizeProvider.Name)
+	if person := createTestPerson(t, server, `{"name":"Zyxa"}`); person.Gender != "" {
+		t.Errorf("Gender = %q after Genderize failed, want it left empty", person.Gender)
+	}
+}
+
+func TestAgeRoundingAndNull(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("UNKNOWN_AGE", "null")
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		switch name {
+		case "Dmitriy":
+			return http.StatusOK, `{"age":41.4}`
+		case "Ivan":
+			return http.StatusOK, `{"age":41.6}`
+		}
+		return http.StatusOK, `{"age":null}`
+	})
+
+	for mode, want := range map[string][2]int{
+		"truncate": {41, 41},
+		"round":    {41, 42},
+		"ceil":     {42, 42},
+		"":         {41, 41},
+	} {
+		t.Setenv("AGE_ROUNDING", mode)
+		for i, name := range []string{"Dmitriy", "Ivan"} {
+			person := createTestPerson(t, server, fmt.Sprintf(`{"name":%q}`, name))
+			if person.Age == nil || *person.Age != want[i] {
+				t.Errorf("AGE_ROUNDING=%q: age of %s = %v, want %d", mode, name, person.Age, want[i])
+			}
+		}
+	}
+
+	unknown := createTestPerson(t, server, `{"name":"Zyxa"}`)
+	if unknown.Age != nil {
+		t.Errorf("Null age from Agify stored as %d, want it unknown", *unknown.Age)
+	}
+	var stored Person
+	db.First(&stored, unknown.ID)
+	if stored.Age != nil {
+		t.Errorf("Null age stored as %d, want NULL", *stored.Age)
+	}
+
+	// A null answer doesn't wipe an age already known
+	db.Model(&Person{}).Where("id = ?", unknown.ID).UpdateColumn("age", 30)
+	call(t, server, http.MethodPut, fmt.Sprintf("/people/%d?force_enrich=true", unknown.ID), `{"name":"Zyxa"}`)
+	db.First(&stored, unknown.ID)
+	if stored.Age == nil || *stored.Age != 30 {
+		t.Errorf("Age = %v after Agify answered null, want the stored 30 kept", stored.Age)
+	}
+}
+
+func TestUpdateReenrichesOnTriggeringFields(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	update := func(body string) int {
+		t.Helper()
+		calls := stub.callCount(agifyProvider.Name)
+		if resp, data := call(t, server, http.MethodPut, path, body); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Update answered %d: %s", resp.StatusCode, data)
+		}
+		return stub.callCount(agifyProvider.Name) - calls
+	}
+
+	if n := update(`{"name":"Dmitriy","surname":"Ushakov","patronymic":"Petrovich"}`); n != 0 {
+		t.Errorf("Patronymic change re-enriched with %d agify calls, want none", n)
+	}
+	if n := update(`{"name":"Dmitry","surname":"Ushakov","patronymic":"Petrovich"}`); n != 1 {
+		t.Errorf("Name change re-enriched with %d agify calls, want 1", n)
+	}
+
+	t.Setenv("REENRICH_ON_FIELDS", "name, Patronymic")
+	if n := update(`{"name":"Dmitry","surname":"Petrov","patronymic":"Petrovich"}`); n != 0 {
+		t.Errorf("Surname change re-enriched with %d agify calls, want none", n)
+	}
+	if n := update(`{"name":"Dmitry","surname":"Petrov","patronymic":"Ivanovich"}`); n != 1 {
+		t.Errorf("Patronymic change re-enriched with %d agify calls once it triggers, want 1", n)
+	}
+}
+
+func TestImplausibleAgesDroppedOrClamped(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("UNKNOWN_AGE", "null")
+	ages := map[string]string{"Dmitriy": "35", "Zyxa": "0", "Olga": "250"}
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, fmt.Sprintf(`{"age":%s}`, ages[name])
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for mode, want := range map[string]map[string]*int{
+		"":      {"Dmitriy": intPtr(35), "Zyxa": nil, "Olga": nil},
+		"clamp": {"Dmitriy": intPtr(35), "Zyxa": intPtr(1), "Olga": intPtr(120)},
+	} {
+		t.Setenv("AGE_IMPLAUSIBLE", mode)
+		for name, age := range want {
+			person := createTestPerson(t, server, fmt.Sprintf(`{"name":%q}`, name))
+			if (person.Age == nil) != (age == nil) || age != nil && *person.Age != *age {
+				t.Errorf("AGE_IMPLAUSIBLE=%q: Agify's %s for %s gave age %v, want %v", mode, ages[name], name, person.Age, age)
+			}
+		}
+	}
+	if !strings.Contains(logged.String(), "implausible age 250") || strings.Contains(logged.String(), "implausible age 35") {
+		t.Errorf("Logged:\n%s\nwant only the implausible ages reported", logged.String())
+	}
+}
+
+func TestForcedReenrichmentOfUnchangedName(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+	body := `{"name":"Dmitriy","surname":"Ushakov"}`
+
+	reenriched := func(method, query string) int {
+		t.Helper()
+		calls := stub.callCount(agifyProvider.Name)
+		if resp, data := call(t, server, method, path+query, body); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s answered %d: %s", method, query, resp.StatusCode, data)
+		}
+		return stub.callCount(agifyProvider.Name) - calls
+	}
+
+	stub.answer(agifyProvider.Name, func(string) (int, string) { return http.StatusOK, `{"age":50}` })
+	if n := reenriched(http.MethodPut, ""); n != 0 {
+		t.Errorf("Unforced update of a fresh record called agify %d times", n)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		if n := reenriched(method, "?force_enrich=true"); n != 1 {
+			t.Errorf("Forced %s called agify %d times, want 1", method, n)
+		}
+	}
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.Age == nil || *stored.Age != 50 {
+		t.Errorf("Age = %v after forced re-enrichment, want the new 50", stored.Age)
+	}
+
+	call(t, server, http.MethodPatch, path+"/override", `{"Age":30}`)
+	if n := reenriched(http.MethodPut, "?force_enrich=true"); n != 0 {
+		t.Errorf("Forced update of an override called agify %d times, want none without force=true", n)
+	}
+	if n := reenriched(http.MethodPut, "?force_enrich=true&force=true"); n != 1 {
+		t.Errorf("Forced update with force=true called agify %d times, want 1", n)
+	}
+	db.First(&stored, person.ID)
+	if stored.ManualOverride || *stored.Age != 50 {
+		t.Errorf("Stored %+v, want the override handed back to enrichment", stored)
+	}
+}
+
+func TestProvidersRespectOwnTimeouts(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("ENRICH_TIMEOUT", "2s")
+	t.Setenv("NATIONALIZE_TIMEOUT", "50ms")
+
+	if got := agifyProvider.timeout(); got != 2*time.Second {
+		t.Errorf("Agify timeout = %s, want ENRICH_TIMEOUT's 2s", got)
+	}
+	if got := nationalizeProvider.timeout(); got != 50*time.Millisecond {
+		t.Errorf("Nationalize timeout = %s, want its own 50ms", got)
+	}
+
+	slow := func(body string) func(string) (int, string) {
+		return func(string) (int, string) {
+			time.Sleep(200 * time.Millisecond)
+			return http.StatusOK, body
+		}
+	}
+	stub.answer(agifyProvider.Name, slow(`{"age":42}`))
+	stub.answer(nationalizeProvider.Name, slow(`{"country":[{"country_id":"RU","probability":0.7}]}`))
+
+	person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	if person.Age == nil || *person.Age != 42 {
+		t.Errorf("Age = %v, want Agify's slow answer within its timeout", person.Age)
+	}
+	if person.Nationality != "" || person.NationalityEnrichedAt != nil {
+		t.Errorf("Nationality = %q, want Nationalize cut off by its own timeout", person.Nationality)
+	}
+}
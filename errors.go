@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Kinds of failure the handlers' helpers report, mapped to HTTP statuses by statusFor
+var (
+	errNotFound   = errors.New("not found")
+	errValidation = errors.New("validation failed")
+	errUpstream   = errors.New("upstream provider failed")
+	errConflict   = errors.New("conflict")
+)
+
+// domainError is a failure of one of the kinds above whose message is meant for the
+// client as is, so it can still be translated by the message catalog
+type domainError struct {
+	kind    error
+	message string
+}
+
+func (e domainError) Error() string { return e.message }
+
+func (e domainError) Unwrap() error { return e.kind }
+
+// fieldError is the validation failure of one field
+type fieldError struct {
+	field   string
+	message string
+}
+
+// validationErrors collects the failures of every invalid field, so a client can
+// fix them all in one round trip. Its message joins theirs.
+type validationErrors []fieldError
+
+func (e validationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e validationErrors) Unwrap() error { return errValidation }
+
+// newDomainError returns an error of kind with a client-facing message
+func newDomainError(kind error, format string, args ...interface{}) error {
+	return domainError{kind: kind, message: fmt.Sprintf(format, args...)}
+}
+
+// statusFor maps an error to the HTTP status answering it; unknown errors are internal
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errUpstream):
+		return http.StatusBadGateway
+	case errors.Is(err, errConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondServiceError answers err with its mapped status. Domain errors carry their
+// own message; anything else is logged and answered 500 with fallback.
+func respondServiceError(w http.ResponseWriter, err error, fallback string) {
+	status := statusFor(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Internal error: %v", err)
+		respondError(w, status, fallback)
+		return
+	}
+	var fieldErrs validationErrors
+	if errors.As(err, &fieldErrs) {
+		respondValidationErrors(w, status, fieldErrs)
+		return
+	}
+	respondError(w, status, err.Error())
+}
+
+// respondValidationErrors answers like respondError, with the joined message under
+// "error", and lists each field's failure under "errors"
+func respondValidationErrors(w http.ResponseWriter, status int, errs validationErrors) {
+	lang := writerLanguage(w)
+	messages := make([]string, len(errs))
+	fields := make([]map[string]string, len(errs))
+	for i, fe := range errs {
+		messages[i] = translate(lang, fe.message)
+		fields[i] = map[string]string{"field": fe.field, "message": messages[i]}
+	}
+	respondJSON(w, status, map[string]interface{}{
+		"error":  strings.Join(messages, "; "),
+		"errors": fields,
+	})
+}
+
+// dbError turns the database errors clients should know about into domain errors
+func dbError(err error) error {
+	var pqErr *pq.Error
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		return newDomainError(errNotFound, "Person not found")
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		return newDomainError(errConflict, "Person already exists")
+	}
+	return err
+}
+
+// findPerson loads the person with id, reporting errNotFound when there is none
+func findPerson(conn *gorm.DB, id int) (Person, error) {
+	var person Person
+	if err := conn.First(&person, id).Error; err != nil {
+		return person, dbError(err)
+	}
+	return person, nil
+}
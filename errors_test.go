@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+func TestErrorKindsMapToStatuses(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{newDomainError(errNotFound, "Person not found"), http.StatusNotFound},
+		{newDomainError(errValidation, "Invalid value for field Age"), http.StatusUnprocessableEntity},
+		{validationErrors{{"Name", "Name is required"}}, http.StatusUnprocessableEntity},
+		{newDomainError(errUpstream, "Enrichment failed"), http.StatusBadGateway},
+		{newDomainError(errConflict, "Person already exists"), http.StatusConflict},
+		{fmt.Errorf("saving: %w", newDomainError(errConflict, "Person exists")), http.StatusConflict},
+		{dbError(gorm.ErrRecordNotFound), http.StatusNotFound},
+		{dbError(&pq.Error{Code: "23505"}), http.StatusConflict},
+		{dbError(errors.New("connection refused")), http.StatusInternalServerError},
+		{errors.New("something unexpected"), http.StatusInternalServerError},
+	} {
+		if got := statusFor(c.err); got != c.want {
+			t.Errorf("statusFor(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestServiceErrorHidesInternalMessages(t *testing.T) {
+	w := httptest.NewRecorder()
+	respondServiceError(w, newDomainError(errNotFound, "Person not found"), "Failed to load person")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "Person not found") {
+		t.Errorf("Domain error answered %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	respondServiceError(w, errors.New("dial tcp 10.0.0.5:5432: connection refused"), "Failed to load person")
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "10.0.0.5") || !strings.Contains(w.Body.String(), "Failed to load person") {
+		t.Errorf("Internal error answered %d: %s, want the fallback message only", w.Code, w.Body.String())
+	}
+}
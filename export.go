@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// exportColumns is the header row of a CSV export
+var exportColumns = []string{"ID", "PublicID", "Name", "Surname", "Patronymic", "Age", "Gender", "GenderProbability", "Nationality", "CreatedAt", "UpdatedAt"}
+
+// exportPeople streams every person matching the list filters as CSV, or
+// with ?format=json as a JSON array, for operators to take offline
+func exportPeople(w http.ResponseWriter, r *http.Request) {
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := applyPeopleFilters(conn, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query = query.Order("id")
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Disposition", `attachment; filename="people.csv"`)
+		streamPeopleCSV(w, query)
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="people.json"`)
+		streamPeople(w, query, false)
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid format, expected csv or json")
+	}
+}
+
+// streamPeopleCSV writes the people matched by query as CSV one row at a time.
+// Like streamPeople, errors after the header has been sent can only be logged.
+func streamPeopleCSV(w http.ResponseWriter, query *gorm.DB) {
+	rows, err := query.Model(&Person{}).Rows()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write(exportColumns)
+	for i := 0; rows.Next(); i++ {
+		var person Person
+		if err := query.ScanRows(rows, &person); err != nil {
+			log.Printf("Error scanning exported person: %v", err)
+			break
+		}
+		// ScanRows skips the gorm callbacks
+		person.AfterFind()
+
+		out.Write([]string{
+			strconv.FormatUint(uint64(person.ID), 10),
+			stringValue(person.PublicID),
+			person.Name,
+			person.Surname,
+			person.Patronymic,
+			formatAge(person.Age),
+			person.Gender,
+			strconv.FormatFloat(person.GenderProbability, 'f', -1, 64),
+			person.Nationality,
+			person.CreatedAt.Format(time.RFC3339),
+			person.UpdatedAt.Format(time.RFC3339),
+		})
+
+		if (i+1)%streamFlushEvery == 0 {
+			out.Flush()
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating people: %v", err)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error writing export: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestExportHonorsListFilters(t *testing.T) {
+	server, _ := newTestServer(t)
+	old := time.Now().AddDate(0, -1, 0)
+	for _, person := range []Person{
+		{Name: "Dmitriy", Gender: "male", Nationality: "RU", Age: intPtr(40)},
+		{Name: "Anna", Gender: "female", Nationality: "RU", Age: intPtr(25)},
+		{Name: "Ivan", Gender: "male", Nationality: "UA", Age: intPtr(33)},
+		{Name: "Oleg", Gender: "male", Nationality: "RU", Age: intPtr(20)},
+		{Name: "Petr", Gender: "male", Nationality: "RU", Age: intPtr(50), Model: gorm.Model{CreatedAt: old}},
+	} {
+		if err := db.Create(&person).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	filters := "gender=male&nationality=RU&age_min=30&created_after=" + time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	resp, data := call(t, server, http.MethodGet, "/people/export?"+filters, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CSV export answered %d: %s", resp.StatusCode, data)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatalf("Error reading the export: %v", err)
+	}
+	if len(rows) != 2 || rows[1][2] != "Dmitriy" {
+		t.Errorf("CSV export = %v, want only Dmitriy after the header", rows)
+	}
+
+	resp, data = call(t, server, http.MethodGet, "/people/export?format=json&"+filters, "")
+	var people []Person
+	decode(t, data, &people)
+	if resp.StatusCode != http.StatusOK || len(people) != 1 || people[0].Name != "Dmitriy" {
+		t.Errorf("JSON export answered %d: %s, want only Dmitriy", resp.StatusCode, data)
+	}
+
+	if resp, data := call(t, server, http.MethodGet, "/people/export?age_min=old", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Export with an invalid filter answered %d: %s", resp.StatusCode, data)
+	}
+}
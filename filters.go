@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxNationalityFilter caps how many codes ?nationality= may list
+const maxNationalityFilter = 20
+
+// peopleFilterParams lists the query params understood by applyPeopleFilters
+var peopleFilterParams = []string{"name", "surname", "gender", "nationality", "age_min", "age_max", "created_after", "created_before", "enriched"}
+
+// hasPeopleFilters reports whether r sets any of the list filters
+func hasPeopleFilters(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, param := range peopleFilterParams {
+		if query.Get(param) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPeopleFilters narrows a people query using the list query params
+func applyPeopleFilters(query *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	params := r.URL.Query()
+
+	// name and surname match case-insensitive substrings, backed by trigram indexes
+	if name := params.Get("name"); name != "" {
+		query = query.Where("name ILIKE ?", "%"+escapeLike(name)+"%")
+	}
+	if surname := params.Get("surname"); surname != "" {
+		query = query.Where("surname ILIKE ?", "%"+escapeLike(surname)+"%")
+	}
+	if gender := params.Get("gender"); gender != "" {
+		query = query.Where("gender = ?", strings.ToLower(gender))
+	}
+
+	for param, op := range map[string]string{"age_min": ">=", "age_max": "<="} {
+		if raw := params.Get(param); raw != "" {
+			age, err := strconv.Atoi(raw)
+			if err != nil || age < 0 {
+				return nil, fmt.Errorf("Invalid %s, expected a non-negative integer", param)
+			}
+			query = query.Where("age "+op+" ?", age)
+		}
+	}
+
+	if raw := params.Get("nationality"); raw != "" {
+		codes, err := parseNationalities(raw)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("nationality IN (?)", codes)
+	}
+
+	// enriched=false finds records needing a backfill: no age, gender or nationality stored
+	if raw := params.Get("enriched"); raw != "" {
+		enriched, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid enriched, expected true or false")
+		}
+		incomplete := "(" + unknownAgeCondition() + " OR gender = '' OR gender IS NULL OR nationality = '' OR nationality IS NULL)"
+		if enriched {
+			query = query.Where("NOT " + incomplete)
+		} else {
+			query = query.Where(incomplete)
+		}
+	}
+
+	// created_after and created_before bound created_at, accepting RFC 3339 timestamps or plain dates
+	for param, op := range map[string]string{"created_after": ">=", "created_before": "<"} {
+		if raw := params.Get(param); raw != "" {
+			t, err := parseFilterTime(raw)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s, expected an RFC 3339 timestamp or a YYYY-MM-DD date", param)
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+
+	return query, nil
+}
+
+func parseFilterTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// parseNationalities splits a comma-separated list of ISO 3166-1 alpha-2 or alpha-3
+// codes, normalized to the format nationalities are stored in
+func parseNationalities(raw string) ([]string, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxNationalityFilter {
+		return nil, fmt.Errorf("At most %d nationalities can be filtered on", maxNationalityFilter)
+	}
+
+	codes := make([]string, 0, len(parts))
+	for _, part := range parts {
+		code, ok := storedCountryCode(part)
+		if !ok {
+			return nil, fmt.Errorf("Invalid nationality code %q", part)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// isCountryCode reports whether code has the shape of an alpha-2 code, as the providers take for hints
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
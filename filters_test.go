@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFilterByNationalities(t *testing.T) {
+	server, _ := newTestServer(t)
+	for _, nationality := range []string{"RU", "UA", "KZ", "BY"} {
+		if err := db.Create(&Person{Name: "Dmitriy", Nationality: nationality}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string]int{
+		"nationality=RU":          1,
+		"nationality=ru,UA,%20KZ": 3,
+		"nationality=RUS,UKR":     2,
+		"nationality=RU,RU":       1,
+	} {
+		resp, data := call(t, server, http.MethodGet, "/people?"+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /people?%s answered %d: %s", query, resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		if len(people) != want {
+			t.Errorf("GET /people?%s returned %d people, want %d", query, len(people), want)
+		}
+	}
+
+	tooMany := strings.TrimSuffix(strings.Repeat("RU,", maxNationalityFilter+1), ",")
+	for _, query := range []string{"nationality=" + tooMany, "nationality=RU,Russia"} {
+		if resp, data := call(t, server, http.MethodGet, "/people?"+query, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /people?%s answered %d: %s", query, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestCountOnlyMatchesFilters(t *testing.T) {
+	server, _ := newTestServer(t)
+	for _, nationality := range []string{"RU", "RU", "UA"} {
+		if err := db.Create(&Person{Name: "Dmitriy", Nationality: nationality}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, filter := range []string{"", "nationality=RU", "nationality=KZ"} {
+		_, data := call(t, server, http.MethodGet, "/people?"+filter, "")
+		var people []Person
+		decode(t, data, &people)
+
+		resp, data := call(t, server, http.MethodGet, "/people?count_only=true&"+filter, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Count with %q answered %d: %s", filter, resp.StatusCode, data)
+		}
+		var count map[string]int
+		decode(t, data, &count)
+		if len(count) != 1 || count["total"] != len(people) {
+			t.Errorf("Count with %q = %s, want {\"total\":%d}", filter, data, len(people))
+		}
+	}
+}
+
+func TestFilterByEnrichment(t *testing.T) {
+	server, _ := newTestServer(t)
+	for _, person := range []Person{
+		{Name: "Complete", Age: intPtr(40), Gender: "male", Nationality: "RU"},
+		{Name: "NoAge", Gender: "male", Nationality: "RU"},
+		{Name: "NoGender", Age: intPtr(30), Nationality: "RU"},
+		{Name: "NoNationality", Age: intPtr(30), Gender: "female"},
+		{Name: "Nothing"},
+	} {
+		if err := db.Create(&person).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(query string) map[string]bool {
+		t.Helper()
+		resp, data := call(t, server, http.MethodGet, "/people?"+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /people?%s answered %d: %s", query, resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		found := map[string]bool{}
+		for _, person := range people {
+			found[person.Name] = true
+		}
+		return found
+	}
+
+	if got := names("enriched=true"); len(got) != 1 || !got["Complete"] {
+		t.Errorf("enriched=true returned %v, want only Complete", got)
+	}
+	if got := names("enriched=false"); len(got) != 4 || got["Complete"] {
+		t.Errorf("enriched=false returned %v, want the four incomplete people", got)
+	}
+	if got := names("enriched=false&gender=male"); len(got) != 1 || !got["NoAge"] {
+		t.Errorf("enriched=false&gender=male returned %v, want only NoAge", got)
+	}
+	if resp, data := call(t, server, http.MethodGet, "/people?enriched=maybe", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("enriched=maybe answered %d: %s", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Feature flag names gating experimental endpoints
+const (
+	featureBatch    = "batch"
+	featureReenrich = "reenrich"
+)
+
+// featureFlags holds whether each experimental feature is enabled, seeded with the defaults
+var featureFlags = map[string]bool{
+	featureBatch:    true,
+	featureReenrich: true,
+}
+
+// loadFeatureFlags applies FEATURE_FLAGS on top of the defaults. The value is
+// either a JSON object such as {"batch": false} or a comma-separated list like
+// "batch,-reenrich" where a leading "-" disables the feature.
+func loadFeatureFlags() {
+	raw := strings.TrimSpace(featureFlagsSetting.get())
+	if raw == "" {
+		return
+	}
+
+	if strings.HasPrefix(raw, "{") {
+		var flags map[string]bool
+		if err := json.Unmarshal([]byte(raw), &flags); err != nil {
+			log.Printf("Invalid FEATURE_FLAGS, using defaults: %v", err)
+			return
+		}
+		for name, enabled := range flags {
+			featureFlags[name] = enabled
+		}
+		return
+	}
+
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "-") {
+			featureFlags[name[1:]] = false
+		} else if name != "" {
+			featureFlags[name] = true
+		}
+	}
+}
+
+func featureEnabled(name string) bool {
+	return featureFlags[name]
+}
+
+// requireFeature responds 404 in place of h while the named feature is disabled
+func requireFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			respondError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// setFeatureFlags loads raw as FEATURE_FLAGS over the defaults, restoring the flags when the test ends
+func setFeatureFlags(t *testing.T, raw string) {
+	t.Helper()
+	saved := make(map[string]bool, len(featureFlags))
+	for name, enabled := range featureFlags {
+		saved[name] = enabled
+	}
+	t.Cleanup(func() { featureFlags = saved })
+
+	featureFlags = map[string]bool{featureBatch: true, featureReenrich: true}
+	t.Setenv("FEATURE_FLAGS", raw)
+	loadFeatureFlags()
+}
+
+func TestDisabledFeatureRoutesNotFound(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for _, raw := range []string{"-batch,-reenrich", `{"batch":false,"reenrich":false}`} {
+		setFeatureFlags(t, raw)
+		for _, path := range []string{"/people/batch", "/enrich/batch", "/admin/reenrich/low-confidence"} {
+			if resp, data := adminCall(t, server, http.MethodPost, path, `[]`); resp.StatusCode != http.StatusNotFound {
+				t.Errorf("POST %s with FEATURE_FLAGS=%s answered %d: %s", path, raw, resp.StatusCode, data)
+			}
+		}
+	}
+
+	setFeatureFlags(t, "-reenrich")
+	if resp, data := call(t, server, http.MethodPost, "/people/batch", `[{"name":"Dmitriy"}]`); resp.StatusCode != http.StatusCreated {
+		t.Errorf("Batch left enabled answered %d: %s", resp.StatusCode, data)
+	}
+}
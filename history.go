@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PersonHistory is one audit log entry for a change made to a person
+type PersonHistory struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PersonID  uint `gorm:"index"`
+	Action    string
+	ChangedBy string
+	// Changes is a JSON object mapping each changed field to its old and new value
+	Changes string `gorm:"type:text"`
+}
+
+type fieldChange struct {
+	From interface{}
+	To   interface{}
+}
+
+func getPersonHistory(w http.ResponseWriter, r *http.Request) {
+	personID := personIDFrom(r)
+
+	conn, done := readDB(r)
+	defer done()
+
+	var history []PersonHistory
+	if err := conn.Where("person_id = ?", personID).Order("created_at, id").Find(&history).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load history")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, history)
+}
+
+// savePersonWithHistory saves after and records how it differs from before in the same transaction
+func savePersonWithHistory(r *http.Request, before, after *Person) error {
+	return writeTransaction(r.Context(), func(tx *gorm.DB) error {
+		return savePersonInTx(tx, r, before, after)
+	})
+}
+
+// savePersonInTx is savePersonWithHistory inside the caller's transaction
+func savePersonInTx(tx *gorm.DB, r *http.Request, before, after *Person) error {
+	if err := tx.Save(after).Error; err != nil {
+		return err
+	}
+	return recordHistory(tx, r, after.ID, "update", diffPeople(before, after))
+}
+
+// deletePersonWithHistory soft-deletes person and records the deletion in the same
+// transaction. UpdatedAt moves too, so incremental sync reports the deletion.
+func deletePersonWithHistory(r *http.Request, person *Person) error {
+	return writeTransaction(r.Context(), func(tx *gorm.DB) error {
+		if err := tx.Model(person).UpdateColumn("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(person).Error; err != nil {
+			return err
+		}
+		return recordHistory(tx, r, person.ID, "delete", diffPeople(person, &Person{}))
+	})
+}
+
+// recordHistory adds an entry for changes to the history of the person. A write
+// that changes none of the audited fields, such as a refresh of enrichment times
+// alone, leaves no entry.
+func recordHistory(tx *gorm.DB, r *http.Request, personID uint, action string, changes map[string]fieldChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&PersonHistory{
+		PersonID:  personID,
+		Action:    action,
+		ChangedBy: requestActor(r),
+		Changes:   string(encoded),
+	}).Error
+}
+
+// requestActor identifies who made a request: the X-User header when set, otherwise the client address
+func requestActor(r *http.Request) string {
+	if user := r.Header.Get("X-User"); user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+func diffPeople(before, after *Person) map[string]fieldChange {
+	changes := map[string]fieldChange{}
+	add := func(field string, from, to interface{}) {
+		if from != to {
+			changes[field] = fieldChange{From: from, To: to}
+		}
+	}
+
+	add("Name", before.Name, after.Name)
+	add("Surname", before.Surname, after.Surname)
+	add("Patronymic", before.Patronymic, after.Patronymic)
+	add("Age", ageValue(before.Age), ageValue(after.Age))
+	add("Gender", before.Gender, after.Gender)
+	add("GenderProbability", before.GenderProbability, after.GenderProbability)
+	add("Nationality", before.Nationality, after.Nationality)
+	add("ManualOverride", before.ManualOverride, after.ManualOverride)
+
+	return changes
+}
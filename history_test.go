@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// historyOf loads the history of the person with id through the API
+func historyOf(t testing.TB, server *httptest.Server, id uint) []PersonHistory {
+	t.Helper()
+
+	resp, data := call(t, server, http.MethodGet, fmt.Sprintf("/people/%d/history", id), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET history answered %d: %s", resp.StatusCode, data)
+	}
+	var history []PersonHistory
+	decode(t, data, &history)
+	return history
+}
+
+func TestHistorySkipsUnchangedSaves(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	// The second PUT changes nothing, so only the first is audited
+	for i := 0; i < 2; i++ {
+		resp, data := call(t, server, http.MethodPut, path, `{"name":"Dmitriy","surname":"Petrov"}`)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT answered %d: %s", resp.StatusCode, data)
+		}
+	}
+
+	history := historyOf(t, server, person.ID)
+	if len(history) != 1 {
+		t.Fatalf("Got %d history entries, want 1: %+v", len(history), history)
+	}
+	if history[0].Action != "update" {
+		t.Errorf("Action = %q, want update", history[0].Action)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/oklog/ulid/v2"
+)
+
+// ID strategies, chosen with ID_STRATEGY. The integer primary key stays the
+// internal identifier either way; uuid and ulid add a PublicID for clients.
+const (
+	idStrategyIncrement = "increment"
+	idStrategyUUID      = "uuid"
+	idStrategyULID      = "ulid"
+)
+
+// maxPublicIDLength is the longest {id} looked up as a PublicID; UUIDs and ULIDs are shorter
+const maxPublicIDLength = 64
+
+// publicIDBackfillBatch is how many people get a PublicID per backfill query
+const publicIDBackfillBatch = 500
+
+func idStrategy() string {
+	return idStrategySetting.get()
+}
+
+// newPublicID returns a fresh PublicID, or nil under the increment strategy
+func newPublicID() *string {
+	var id string
+	switch idStrategy() {
+	case idStrategyUUID:
+		id = uuid.NewString()
+	case idStrategyULID:
+		id = ulid.Make().String()
+	default:
+		return nil
+	}
+	return &id
+}
+
+// BeforeCreate gives new people a PublicID unless one was supplied, as by an import
+func (p *Person) BeforeCreate() error {
+	if p.PublicID == nil {
+		p.PublicID = newPublicID()
+	}
+	return nil
+}
+
+// backfillPublicIDs gives every person created before the switch to uuid or ulid
+// a PublicID, so all of them can be addressed the same way
+func backfillPublicIDs() {
+	if idStrategy() == idStrategyIncrement {
+		return
+	}
+
+	total := 0
+	for {
+		var ids []uint
+		if err := db.Unscoped().Model(&Person{}).Where("public_id IS NULL").Limit(publicIDBackfillBatch).Pluck("id", &ids).Error; err != nil {
+			log.Printf("Error loading people without a public ID: %v", err)
+			return
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			// UpdateColumn skips the hooks and timestamps; this isn't a change to the person
+			if err := db.Unscoped().Model(&Person{}).Where("id = ?", id).UpdateColumn("public_id", *newPublicID()).Error; err != nil {
+				log.Printf("Error assigning a public ID to person %d: %v", id, err)
+				return
+			}
+		}
+		total += len(ids)
+	}
+	if total > 0 {
+		log.Printf("Assigned %s public IDs to %d existing people", idStrategy(), total)
+	}
+}
+
+type personIDKey struct{}
+
+// errInvalidPersonID is returned for a path ID that is neither a positive ID nor could be a PublicID
+var errInvalidPersonID = errors.New("invalid person ID")
+
+// withPersonID resolves the {id} of a /people/{id} route once, answering 400 for
+// anything that isn't a positive ID or a PublicID, and hands h the integer ID
+// through the request context; see personIDFrom
+func withPersonID(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		personID, err := personIDParam(r)
+		if errors.Is(err, errInvalidPersonID) {
+			respondError(w, http.StatusBadRequest, "Invalid person ID")
+			return
+		}
+		if err != nil {
+			respondServiceError(w, err, "Failed to load person")
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), personIDKey{}, personID)))
+	}
+}
+
+// personIDFrom returns the ID withPersonID resolved for r
+func personIDFrom(r *http.Request) int {
+	id, _ := r.Context().Value(personIDKey{}).(int)
+	return id
+}
+
+// personIDParam resolves the {id} path variable, an integer ID or a PublicID, to
+// the integer ID. An unknown PublicID resolves to 0, which matches no person.
+func personIDParam(r *http.Request) (int, error) {
+	raw := mux.Vars(r)["id"]
+	if id, err := strconv.Atoi(raw); err == nil {
+		if id <= 0 {
+			return 0, errInvalidPersonID
+		}
+		return id, nil
+	}
+	if raw == "" || len(raw) > maxPublicIDLength {
+		return 0, errInvalidPersonID
+	}
+
+	var ids []uint
+	if err := db.Unscoped().Model(&Person{}).Where("public_id = ?", raw).Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("resolving public ID %q: %w", raw, err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return int(ids[0]), nil
+}
+
+// personPath is the path clients address person by
+func personPath(person *Person) string {
+	if person.PublicID != nil {
+		return "/people/" + *person.PublicID
+	}
+	return fmt.Sprintf("/people/%d", person.ID)
+}
+
+// maxIDsLookup caps how many ids ?ids= may request at once
+const maxIDsLookup = 100
+
+// PeopleByIDs answers an ?ids= lookup, listing people in the requested order.
+// Requested ids that match no one are listed back as sent, integer IDs under
+// NotFound and PublicIDs under NotFoundPublicIDs.
+type PeopleByIDs struct {
+	People            []Person
+	NotFound          []int
+	NotFoundPublicIDs []string `json:",omitempty"`
+}
+
+// getPeopleByIDs resolves ?ids=1,5,9 with a single query, ignoring the other list
+// filters. Each id may be an integer ID or a PublicID.
+func getPeopleByIDs(w http.ResponseWriter, conn *gorm.DB, raw string) {
+	requested, err := parseIDs(raw)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The placeholders keep each IN list non-empty when only one kind of id is requested
+	ids, publicIDs := []int{0}, []string{""}
+	for _, id := range requested {
+		if n, err := strconv.Atoi(id); err == nil {
+			ids = append(ids, n)
+		} else {
+			publicIDs = append(publicIDs, id)
+		}
+	}
+
+	var found []Person
+	if err := conn.Where("id IN (?) OR public_id IN (?)", ids, publicIDs).Find(&found).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	byID := make(map[string]Person, 2*len(found))
+	for _, person := range found {
+		byID[strconv.FormatUint(uint64(person.ID), 10)] = person
+		if person.PublicID != nil {
+			byID[*person.PublicID] = person
+		}
+	}
+
+	result := PeopleByIDs{People: []Person{}, NotFound: []int{}}
+	for _, id := range requested {
+		if person, ok := byID[id]; ok {
+			result.People = append(result.People, person)
+		} else if n, err := strconv.Atoi(id); err == nil {
+			result.NotFound = append(result.NotFound, n)
+		} else {
+			result.NotFoundPublicIDs = append(result.NotFoundPublicIDs, id)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// parseIDs splits a comma-separated list of person ids, integer IDs or PublicIDs,
+// dropping repeats
+func parseIDs(raw string) ([]string, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxIDsLookup {
+		return nil, fmt.Errorf("At most %d ids can be requested at once", maxIDsLookup)
+	}
+
+	seen := map[string]bool{}
+	ids := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if n, err := strconv.Atoi(part); err == nil {
+			if n <= 0 {
+				return nil, fmt.Errorf("Invalid person ID %q", part)
+			}
+			part = strconv.Itoa(n)
+		} else if part == "" || len(part) > maxPublicIDLength {
+			return nil, fmt.Errorf("Invalid person ID %q", part)
+		}
+		if !seen[part] {
+			seen[part] = true
+			ids = append(ids, part)
+		}
+	}
+	return ids, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
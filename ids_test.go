@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid/v2"
+)
+
+func TestPublicIDStrategies(t *testing.T) {
+	valid := map[string]func(string) error{
+		idStrategyUUID: func(id string) error { _, err := uuid.Parse(id); return err },
+		idStrategyULID: func(id string) error { _, err := ulid.ParseStrict(id); return err },
+	}
+	for strategy, parse := range valid {
+		t.Run(strategy, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			t.Setenv("ID_STRATEGY", strategy)
+
+			seen := map[string]bool{}
+			for i := 0; i < 3; i++ {
+				person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+				if person.PublicID == nil {
+					t.Fatalf("Created person has no PublicID under %s", strategy)
+				}
+				if err := parse(*person.PublicID); err != nil {
+					t.Errorf("PublicID %q is not a valid %s: %v", *person.PublicID, strategy, err)
+				}
+				if seen[*person.PublicID] {
+					t.Errorf("PublicID %q given twice", *person.PublicID)
+				}
+				seen[*person.PublicID] = true
+
+				resp, data := call(t, server, http.MethodGet, "/people/"+*person.PublicID, "")
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("GET by PublicID answered %d: %s", resp.StatusCode, data)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateIgnoresServerOwnedFields(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	person := createTestPerson(t, server, `{"ID":99,"PublicID":"chosen","name":"Dmitriy","Age":7,"ManualOverride":true,"EnrichedAt":"2000-01-01T00:00:00Z"}`)
+	if person.ID == 99 || person.PublicID != nil || person.ManualOverride {
+		t.Errorf("Create kept client-set fields: %+v", person)
+	}
+	if person.Age == nil || *person.Age != 42 || person.EnrichedAt == nil || person.EnrichedAt.Year() == 2000 {
+		t.Errorf("Create kept the client's enrichment: %+v", person)
+	}
+
+	var results []BatchItemResult
+	t.Setenv("ID_STRATEGY", idStrategyUUID)
+	resp, data := call(t, server, http.MethodPost, "/people/batch", `[{"name":"Ivan","PublicID":"chosen"}]`)
+	decode(t, data, &results)
+	if resp.StatusCode != http.StatusCreated || len(results) != 1 || results[0].PublicID == nil || *results[0].PublicID == "chosen" {
+		t.Errorf("Batch create answered %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestLookupByIDs(t *testing.T) {
+	server, _ := newTestServer(t)
+	first := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	second := createTestPerson(t, server, `{"name":"Ivan"}`)
+	third := createTestPerson(t, server, `{"name":"Petr"}`)
+
+	lookup := func(ids string) PeopleByIDs {
+		t.Helper()
+		resp, data := call(t, server, http.MethodGet, "/people?ids="+ids, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET ?ids=%s answered %d: %s", ids, resp.StatusCode, data)
+		}
+		var result PeopleByIDs
+		decode(t, data, &result)
+		return result
+	}
+
+	all := lookup(fmt.Sprintf("%d,%d,%d", third.ID, first.ID, second.ID))
+	if len(all.People) != 3 || all.People[0].ID != third.ID || all.People[1].ID != first.ID || all.People[2].ID != second.ID {
+		t.Errorf("Found %+v, want all three in the requested order", all.People)
+	}
+	if len(all.NotFound) != 0 {
+		t.Errorf("Not found = %v, want none", all.NotFound)
+	}
+
+	partial := lookup(fmt.Sprintf("%d,900,%d,901", second.ID, first.ID))
+	if len(partial.People) != 2 || partial.People[0].ID != second.ID || partial.People[1].ID != first.ID {
+		t.Errorf("Found %+v, want the two existing people in the requested order", partial.People)
+	}
+	if len(partial.NotFound) != 2 || partial.NotFound[0] != 900 || partial.NotFound[1] != 901 {
+		t.Errorf("Not found = %v, want 900 and 901", partial.NotFound)
+	}
+
+	if resp, data := call(t, server, http.MethodGet, "/people?ids=1,0", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid id answered %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestLookupByPublicIDs(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("ID_STRATEGY", idStrategyULID)
+	first := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	second := createTestPerson(t, server, `{"name":"Ivan"}`)
+
+	path := fmt.Sprintf("/people?ids=%s,%d,%s,404", *first.PublicID, second.ID, *first.PublicID)
+	resp, data := call(t, server, http.MethodGet, path+",missing", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+	}
+	var result PeopleByIDs
+	decode(t, data, &result)
+	if len(result.People) != 2 || result.People[0].ID != first.ID || result.People[1].ID != second.ID {
+		t.Errorf("Found %+v, want both people in the requested order", result.People)
+	}
+	if len(result.NotFound) != 1 || result.NotFound[0] != 404 || len(result.NotFoundPublicIDs) != 1 || result.NotFoundPublicIDs[0] != "missing" {
+		t.Errorf("Not found = %v and %v, want 404 and missing", result.NotFound, result.NotFoundPublicIDs)
+	}
+}
+
+func TestBackfillPublicIDs(t *testing.T) {
+	newTestServer(t)
+	storePeople(t, 3)
+
+	t.Setenv("ID_STRATEGY", idStrategyUUID)
+	backfillPublicIDs()
+
+	var missing int
+	db.Model(&Person{}).Where("public_id IS NULL").Count(&missing)
+	if missing != 0 {
+		t.Errorf("%d people still have no PublicID after the backfill", missing)
+	}
+}
+
+func TestPublicIDResolveFailureIsInternal(t *testing.T) {
+	server, _ := newTestServer(t)
+	db.DropTable(&Person{})
+
+	resp, data := call(t, server, http.MethodGet, "/people/01HZX3Q0V6S8NB2C4K1D9F7A5E", "")
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GET with a broken database answered %d: %s", resp.StatusCode, data)
+	}
+	resp, data = call(t, server, http.MethodGet, "/people/0", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /people/0 answered %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestWithPersonIDParsesOnce(t *testing.T) {
+	server, _ := newTestServer(t)
+	publicID := "01HZX3Q0V6S8NB2C4K1D9F7A5E"
+	stored := Person{Name: "Dmitriy", PublicID: &publicID}
+	if err := db.Create(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	var got int
+	called := false
+	router.HandleFunc("/people/{id}", withPersonID(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		got = personIDFrom(r)
+	}))
+
+	for _, tc := range []struct {
+		raw    string
+		status int
+		id     int
+	}{
+		{"7", http.StatusOK, 7},
+		{publicID, http.StatusOK, int(stored.ID)},
+		// An unknown PublicID reaches the handler as 0, which it answers 404 for
+		{"01HZX3Q0V6S8NB2C4K1D9F7A5F", http.StatusOK, 0},
+		{"0", http.StatusBadRequest, 0},
+		{"-3", http.StatusBadRequest, 0},
+		{strings.Repeat("a", maxPublicIDLength+1), http.StatusBadRequest, 0},
+	} {
+		called, got = false, -1
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/people/"+tc.raw, nil))
+		if w.Code != tc.status || called != (tc.status == http.StatusOK) || called && got != tc.id {
+			t.Errorf("ID %q answered %d with the handler called %v for ID %d, want %d for ID %d", tc.raw, w.Code, called, got, tc.status, tc.id)
+		}
+	}
+
+	// Every /people/{id} handler answers a bad ID the same way
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if resp, data := call(t, server, method, "/people/0", `{"name":"Dmitriy"}`); resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), "Invalid person ID") {
+			t.Errorf("%s /people/0 answered %d: %s", method, resp.StatusCode, data)
+		}
+	}
+}
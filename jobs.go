@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Job statuses
+const (
+	jobPending   = "pending"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// defaultJobRetention is how long finished jobs stay pollable when JOB_RETENTION is unset
+const defaultJobRetention = time.Hour
+
+// Job workers and queued jobs when JOB_WORKERS and JOB_QUEUE_SIZE are unset
+const (
+	defaultJobWorkers   = 4
+	defaultJobQueueSize = 100
+)
+
+// Job tracks a create running in the background
+type Job struct {
+	ID       string
+	Status   string
+	PersonID uint `json:",omitempty"`
+	// PersonPublicID is the ID clients address the created person by under ID_STRATEGY uuid or ulid
+	PersonPublicID *string `json:",omitempty"`
+	Error          string  `json:",omitempty"`
+	// RetryAfter is how many seconds to wait before retrying a create refused for exhausted quota
+	RetryAfter int `json:",omitempty"`
+	CreatedAt  time.Time
+	FinishedAt *time.Time `json:",omitempty"`
+}
+
+// jobs holds the background jobs in memory, so they don't survive a restart
+var jobs = struct {
+	sync.RWMutex
+	byID map[string]*Job
+}{byID: map[string]*Job{}}
+
+// jobTask is a create waiting for a job worker
+type jobTask struct {
+	ctx    context.Context
+	job    *Job
+	person Person
+}
+
+var (
+	jobQueue     chan jobTask
+	startJobPool sync.Once
+)
+
+// jobPool returns the queue of the JOB_WORKERS workers running create jobs,
+// starting them on first use. The queue holds JOB_QUEUE_SIZE jobs, so however
+// many creates are sent only that many wait and that many workers run.
+func jobPool() chan<- jobTask {
+	startJobPool.Do(func() {
+		size := jobQueueSizeSetting.get()
+		if size < 0 {
+			size = 0
+		}
+		workers := jobWorkersSetting.get()
+		if workers < 1 {
+			workers = 1
+		}
+
+		queue := make(chan jobTask, size)
+		jobQueue = queue
+		for i := 0; i < workers; i++ {
+			go func() {
+				for task := range queue {
+					runCreateJob(task.ctx, task.job, task.person)
+				}
+			}()
+		}
+	})
+	return jobQueue
+}
+
+// wantsAsync reports whether the client asked for the create to run in the
+// background, with Prefer: respond-async (RFC 7240) or ?async=true
+func wantsAsync(r *http.Request) bool {
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		return true
+	}
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startCreateJob answers 202 Accepted with a job that enriches and stores person
+// in the background; GET /jobs/{id} reports how it went. When the job queue is
+// full the create is answered 503 with Retry-After, as an overloaded server is.
+func startCreateJob(w http.ResponseWriter, r *http.Request, person Person) {
+	job := &Job{ID: newRequestID(), Status: jobPending, CreatedAt: time.Now()}
+
+	jobs.Lock()
+	pruneJobs()
+	jobs.byID[job.ID] = job
+	snapshot := *job
+	jobs.Unlock()
+
+	select {
+	// The job outlives the request, but keeps its values such as the request ID
+	case jobPool() <- jobTask{context.WithoutCancel(r.Context()), job, person}:
+	default:
+		jobs.Lock()
+		delete(jobs.byID, job.ID)
+		jobs.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(int(loadShedRetryAfterSetting.get().Seconds())))
+		respondError(w, http.StatusServiceUnavailable, "Too many pending jobs, try again later")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%s", job.ID))
+	w.Header().Set("Preference-Applied", "respond-async")
+	respondJSON(w, http.StatusAccepted, snapshot)
+}
+
+// runCreateJob is createPerson after validation, recording the outcome on job
+func runCreateJob(ctx context.Context, job *Job, person Person) {
+	finish := func(created *Person, err error) {
+		jobs.Lock()
+		defer jobs.Unlock()
+		now := time.Now()
+		job.FinishedAt = &now
+		if created != nil {
+			job.PersonID, job.PersonPublicID = created.ID, created.PublicID
+		}
+		job.Status = jobSucceeded
+		if err != nil {
+			job.Status = jobFailed
+			job.Error = err.Error()
+		}
+	}
+
+	if err := createEnrichedPerson(&person, getEnrichedData(ctx, inputOf(&person))); err != nil {
+		var quota quotaRetryError
+		if errors.As(err, &quota) {
+			jobs.Lock()
+			job.RetryAfter = quota.seconds()
+			jobs.Unlock()
+		} else if statusFor(err) == http.StatusInternalServerError {
+			log.Printf("Error creating person for job %s: %v", job.ID, err)
+			err = errors.New("Failed to create person")
+		}
+		finish(nil, err)
+		return
+	}
+	finish(&person, nil)
+}
+
+// pruneJobs forgets jobs that finished more than JOB_RETENTION ago. Callers hold the lock.
+func pruneJobs() {
+	retention := jobRetentionSetting.get()
+	for id, job := range jobs.byID {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			delete(jobs.byID, id)
+		}
+	}
+}
+
+func getJob(w http.ResponseWriter, r *http.Request) {
+	jobs.RLock()
+	job, ok := jobs.byID[mux.Vars(r)["id"]]
+	var snapshot Job
+	if ok {
+		snapshot = *job
+	}
+	jobs.RUnlock()
+
+	if !ok {
+		respondError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, snapshot)
+}
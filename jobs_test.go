@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// restartJobPool stops the job workers once their queue drains, so the next job
+// starts a pool sized by the settings in force then
+func restartJobPool() {
+	if jobQueue != nil {
+		close(jobQueue)
+	}
+	jobQueue = nil
+	startJobPool = sync.Once{}
+}
+
+// startJob posts an async create and returns the job it was answered with
+func startJob(t *testing.T, server *httptest.Server, body string) Job {
+	t.Helper()
+
+	resp, data := call(t, server, http.MethodPost, "/people?async=true", body)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Async create answered %d: %s", resp.StatusCode, data)
+	}
+	var job Job
+	decode(t, data, &job)
+	if job.Status != jobPending || resp.Header.Get("Location") != "/jobs/"+job.ID {
+		t.Fatalf("Async create answered %+v at %q, want a pending job", job, resp.Header.Get("Location"))
+	}
+	return job
+}
+
+// pollJob polls the job until it finishes
+func pollJob(t *testing.T, server *httptest.Server, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, data := call(t, server, http.MethodGet, "/jobs/"+id, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /jobs/%s answered %d: %s", id, resp.StatusCode, data)
+		}
+		var job Job
+		decode(t, data, &job)
+		if job.Status != jobPending {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %s still pending", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pollJobOnce returns the job as GET /jobs/{id} reports it now
+func pollJobOnce(t *testing.T, server *httptest.Server, id string) Job {
+	t.Helper()
+
+	_, data := call(t, server, http.MethodGet, "/jobs/"+id, "")
+	var job Job
+	decode(t, data, &job)
+	return job
+}
+
+func TestAsyncCreateLifecycle(t *testing.T) {
+	server, stub := newTestServer(t)
+	release := make(chan struct{})
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		<-release
+		return http.StatusOK, `{"age":42}`
+	})
+
+	job := startJob(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	if polled := pollJobOnce(t, server, job.ID); polled.Status != jobPending {
+		t.Errorf("Job = %+v while enrichment is running, want pending", polled)
+	}
+
+	close(release)
+	job = pollJob(t, server, job.ID)
+	if job.Status != jobSucceeded || job.PersonID == 0 || job.FinishedAt == nil {
+		t.Fatalf("Job = %+v, want succeeded with a person", job)
+	}
+
+	var stored Person
+	if err := db.First(&stored, job.PersonID).Error; err != nil || stored.Age == nil || *stored.Age != 42 {
+		t.Errorf("Job's person = %+v (%v), want it enriched", stored, err)
+	}
+
+	if resp, _ := call(t, server, http.MethodGet, "/jobs/unknown", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unknown job answered %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAsyncCreateReportsQuotaRetry(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("REQUIRED_PROVIDERS", agifyProvider.Name)
+	stub.answer(agifyProvider.Name, func(string) (int, string) {
+		return http.StatusTooManyRequests, `{"error":"Request limit reached"}`
+	})
+
+	job := pollJob(t, server, startJob(t, server, `{"name":"Dmitriy"}`).ID)
+	if job.Status != jobFailed || job.Error != (quotaRetryError{}).Error() || job.RetryAfter <= 0 {
+		t.Errorf("Job = %+v, want failed on quota with a retry delay", job)
+	}
+}
+
+func TestAsyncCreatesBoundedByJobQueue(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("JOB_WORKERS", "1")
+	t.Setenv("JOB_QUEUE_SIZE", "1")
+	restartJobPool()
+	t.Cleanup(restartJobPool)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	stub.answer(agifyProvider.Name, func(string) (int, string) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return http.StatusOK, `{"age":42}`
+	})
+
+	running := startJob(t, server, `{"name":"Dmitriy"}`)
+	<-started
+	queued := startJob(t, server, `{"name":"Ivan"}`)
+
+	resp, data := call(t, server, http.MethodPost, "/people?async=true", `{"name":"Petr"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Create beyond the job queue answered %d: %s", resp.StatusCode, data)
+	}
+
+	close(release)
+	for _, id := range []string{running.ID, queued.ID} {
+		if job := pollJob(t, server, id); job.Status != jobSucceeded {
+			t.Errorf("Job = %+v, want succeeded", job)
+		}
+	}
+}
@@ -2,32 +2,64 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/joho/godotenv"
-	"github.com/go-resty/resty"
 )
 
 // Person model
 type Person struct {
 	gorm.Model
+	// PublicID is the UUID or ULID clients may address the person by, per ID_STRATEGY
+	PublicID    *string `gorm:"unique_index" json:",omitempty"`
 	Name        string
 	Surname     string
 	Patronymic  string
-	Age         int
+	Age         *int
 	Gender      string
 	Nationality string
+
+	// GenderProbability is Genderize's confidence in Gender, between 0 and 1
+	GenderProbability float64
+	// Nationalities are the top Nationalize candidates; Nationality is the first of them
+	Nationalities NationalityCandidates `gorm:"type:text"`
+	// EnrichedAt is when every provider last answered for Name; the per-field
+	// times record when each provider last refreshed its own fields
+	EnrichedAt            *time.Time
+	AgeEnrichedAt         *time.Time
+	GenderEnrichedAt      *time.Time
+	NationalityEnrichedAt *time.Time
+	// ManualOverride marks the derived fields as set by an operator, so re-enrichment leaves them alone
+	ManualOverride bool
+
+	// LikelyRegion is computed from Nationality whenever a person is loaded or saved, never stored
+	LikelyRegion string `gorm:"-" json:",omitempty"`
+}
+
+// PersonInput is what a client sends to create a person: the name fields and an
+// optional Nationality, used as the country hint under ENRICH_COUNTRY_HINT. IDs,
+// timestamps and the enriched fields are the server's to set, so they can't be sent.
+type PersonInput struct {
+	Name        string
+	Surname     string
+	Patronymic  string
+	Nationality string
+}
+
+// person returns the new, not yet stored person in describes
+func (in PersonInput) person() Person {
+	return Person{Name: in.Name, Surname: in.Surname, Patronymic: in.Patronymic, Nationality: in.Nationality}
 }
 
 var db *gorm.DB
-var client = resty.New()
 
 func main() {
 	err := godotenv.Load()
@@ -35,193 +67,348 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	loadFeatureFlags()
+	loadDisabledProviders()
+	loadEnrichmentCache()
+
+	seed := flag.Int("seed", 0, "insert this many sample people and exit (development only)")
+	flag.Parse()
+
 	// Initialize database
 	initDB()
+	defer db.Close()
 
-	// Set up routes
+	if *seed > 0 {
+		if err := seedPeople(*seed); err != nil {
+			log.Fatalf("Error seeding database: %v", err)
+		}
+		log.Printf("Seeded %d people", *seed)
+		return
+	}
+
+	// Run the server
+	serve(withCORS(newRouter()))
+}
+
+// newRouter wires every route and middleware. It needs only the package-level db and
+// the provider env vars, so httptest can serve it against a test database and mock APIs.
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.HandleFunc("/people", getPeople).Methods("GET")
-	router.HandleFunc("/people/{id}", getPerson).Methods("GET")
+	router.HandleFunc("/people/random", getRandomPeople).Methods("GET")
+	router.HandleFunc("/people/export", exportPeople).Methods("GET")
+	router.HandleFunc("/people/deleted", getDeletedPeople).Methods("GET")
+	router.HandleFunc("/people/stats", cacheGET(getPeopleStats)).Methods("GET")
+	router.HandleFunc("/people/stats/age-histogram", cacheGET(getAgeHistogram)).Methods("GET")
+	router.HandleFunc("/people/nationalities", cacheGET(getNationalityCounts)).Methods("GET")
+	router.HandleFunc("/people/{id}", withPersonID(getPerson)).Methods("GET")
 	router.HandleFunc("/people", createPerson).Methods("POST")
-	router.HandleFunc("/people/{id}", updatePerson).Methods("PUT")
-	router.HandleFunc("/people/{id}", deletePerson).Methods("DELETE")
-
-	// Run the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	router.HandleFunc("/people/bulk-update", bulkUpdatePeople).Methods("POST")
+	router.HandleFunc("/people/batch", requireFeature(featureBatch, createPeopleBatch)).Methods("POST")
+	router.HandleFunc("/people/{id}", withPersonID(updatePerson)).Methods("PUT")
+	router.HandleFunc("/people/{id}", withPersonID(patchPerson)).Methods("PATCH")
+	router.HandleFunc("/people/{id}", withPersonID(deletePerson)).Methods("DELETE")
+	router.HandleFunc("/people/{id}/history", withPersonID(getPersonHistory)).Methods("GET")
+	router.HandleFunc("/people/{id}/override", withPersonID(overridePerson)).Methods("PATCH")
+	router.HandleFunc("/people/{id}/clear-enrichment", withPersonID(clearEnrichment)).Methods("POST")
+	router.HandleFunc("/people/{id}/reconcile", withPersonID(reconcilePerson)).Methods("GET")
+	router.HandleFunc("/people/{id}/reconcile", withPersonID(applyReconciliation)).Methods("POST")
+	router.HandleFunc("/admin/people/query", queryPeople).Methods("POST")
+	router.HandleFunc("/admin/providers/{name}/enable", setProviderEnabled(true)).Methods("POST")
+	router.HandleFunc("/admin/providers/{name}/disable", setProviderEnabled(false)).Methods("POST")
+	router.HandleFunc("/admin/config", getEffectiveConfig).Methods("GET")
+	router.HandleFunc("/admin/export", exportDataset).Methods("GET")
+	router.HandleFunc("/admin/import", importDataset).Methods("POST")
+	router.HandleFunc("/admin/cache/flush", flushEnrichmentCache).Methods("POST")
+	router.HandleFunc("/admin/enrichment/failures", getEnrichmentFailures).Methods("GET")
+	router.HandleFunc("/admin/nationality/convert", convertNationalities).Methods("POST")
+	router.HandleFunc("/admin/gender/patronymic", correctPatronymicGenders).Methods("POST")
+	router.HandleFunc("/admin/reenrich/low-confidence", requireFeature(featureReenrich, reenrichLowConfidence)).Methods("POST")
+	router.HandleFunc("/enrich/compare", compareEnrichment).Methods("GET")
+	router.HandleFunc("/enrich/check", checkEnrichment).Methods("GET")
+	router.HandleFunc("/enrich/distribution", getDistribution).Methods("GET")
+	router.HandleFunc("/enrich/batch", requireFeature(featureBatch, enrichBatch)).Methods("POST")
+	router.HandleFunc("/enrich/csv", requireFeature(featureBatch, enrichCSV)).Methods("POST")
+	router.HandleFunc("/jobs/{id}", getJob).Methods("GET")
+	router.HandleFunc("/status/providers", getProviderStatus).Methods("GET")
+	router.HandleFunc("/healthz", healthz).Methods("GET")
+	router.HandleFunc("/debug/vars", debugVars).Methods("GET")
+	registerOptions(router)
+	router.Use(withRequestID, logBodies, responseOptions, requireJSONContentType)
+
+	return router
 }
 
 func initDB() {
-	var err error
-	dbURL := os.Getenv("DATABASE_URL")
+	dbURL, err := databaseURL()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Connecting to %s", describeDSN(dbURL))
 	db, err = gorm.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	// Automigrate the Person model
-	db.AutoMigrate(&Person{})
+	invalidateResponseCacheOnWrite(db)
+
+	// Route every query through the logger so slow ones can be reported
+	db.SetLogger(newSlowQueryLogger())
+	db.LogMode(true)
+
+	migrateDB()
+}
+
+// migrateDB brings the schema up to date
+func migrateDB() {
+	// Automigrate the models
+	db.AutoMigrate(&Person{}, &PersonHistory{}, &EnrichmentFailure{})
+	migrateIndexes()
+	backfillPublicIDs()
 }
 
 func getPeople(w http.ResponseWriter, r *http.Request) {
+	conn, done := readDB(r)
+	defer done()
+
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		getPeopleByIDs(w, conn, ids)
+		return
+	}
+
+	query, err := applyPeopleFilters(conn, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if countOnly, _ := strconv.ParseBool(r.URL.Query().Get("count_only")); countOnly {
+		var total int
+		if err := query.Model(&Person{}).Count(&total).Error; err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to count people")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]int{"total": total})
+		return
+	}
+
+	if r.URL.Query().Get("updated_since") != "" {
+		getPeopleUpdatedSince(w, r, query)
+		return
+	}
+
+	if stream, _ := strconv.ParseBool(r.URL.Query().Get("stream")); stream || wantsNDJSON(r) {
+		query, err = applyStreamPagination(query, r)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		streamPeople(w, query, wantsNDJSON(r))
+		return
+	}
+
+	query, err = applyPagination(query, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	var people []Person
-	db.Find(&people)
+	if err := query.Find(&people).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
 	respondJSON(w, http.StatusOK, people)
 }
 
 func getPerson(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	personID, err := strconv.Atoi(params["id"])
+	personID := personIDFrom(r)
+
+	conn, done := readDB(r)
+	person, err := findPerson(conn, personID)
+	// The read is over, so the transaction isn't held open during a debug lookup
+	done()
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid person ID")
+		respondServiceError(w, err, "Failed to load person")
 		return
 	}
 
-	var person Person
-	if err := db.First(&person, personID).Error; err != nil {
-		respondError(w, http.StatusNotFound, "Person not found")
+	if debugRequested(r) {
+		// Raw responses aren't stored, so debugging a read runs a fresh lookup
+		debug := getEnrichedData(r.Context(), inputOf(&person)).debug()
+		debug.Decisions = append([]string{"fresh lookup, stored values may differ"}, debug.Decisions...)
+		respondJSON(w, http.StatusOK, personWithDebug{person, debug})
 		return
 	}
-
 	respondJSON(w, http.StatusOK, person)
 }
 
 func createPerson(w http.ResponseWriter, r *http.Request) {
-	var person Person
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&person); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request payload")
+	var input PersonInput
+	if !decodeJSONBody(w, r, &input) {
 		return
 	}
-	defer r.Body.Close()
+	person := input.person()
 
-	enrichPersonData(&person)
+	if err := validatePerson(&person); err != nil {
+		respondServiceError(w, err, "Internal server error")
+		return
+	}
 
-	db.Create(&person)
+	warnOnDuplicateCreate(&person, requestID(r))
 
-	respondJSON(w, http.StatusCreated, person)
-}
+	if wantsAsync(r) {
+		startCreateJob(w, r, person)
+		return
+	}
 
-func enrichPersonData(person *Person) {
-	age, gender, nationality := getEnrichedData(person.Name)
+	e := getEnrichedData(r.Context(), inputOf(&person))
+	if err := createEnrichedPerson(&person, e); err != nil {
+		var quota quotaRetryError
+		if errors.As(err, &quota) {
+			// Running out of quota is temporary, so the client is told when to come back
+			w.Header().Set("Retry-After", strconv.Itoa(quota.seconds()))
+			respondError(w, http.StatusServiceUnavailable, quota.Error())
+			return
+		}
+		respondServiceError(w, err, "Failed to create person")
+		return
+	}
 
-	person.Age = age
-	person.Gender = gender
-	person.Nationality = nationality
+	if debugRequested(r) {
+		respondWritten(w, r, http.StatusCreated, &person, personWithDebug{person, e.debug()})
+		return
+	}
+	respondWritten(w, r, http.StatusCreated, &person, person)
 }
 
-func getEnrichedData(name string) (int, string, string) {
-	age := getAgifyAge(name)
-	gender := getGenderizeGender(name)
-	nationality := getNationality(name)
+// createEnrichedPerson stores a validated person enriched with e, the one create path
+// shared by single, batch and async creates. A failed required provider rejects the
+// person, as a quotaRetryError when it only ran out of quota; a failure of any other
+// provider stores the person without its fields and records it in the deadletter table.
+func createEnrichedPerson(person *Person, e enrichment) error {
+	if err := e.requiredFailure(); err != nil {
+		if retryAfter, ok := e.requiredQuotaRetryAfter(); ok {
+			return quotaRetryError{retryAfter}
+		}
+		return err
+	}
+	applyEnrichment(person, e)
 
-	return age, gender, nationality
+	if err := db.Create(person).Error; err != nil {
+		return dbError(err)
+	}
+	recordEnrichmentFailure(person.ID, e)
+	return nil
 }
 
-func getAgifyAge(name string) int {
-	var response map[string]interface{}
-	_, err := client.R().
-		SetResult(&response).
-		Get(fmt.Sprintf("%s/?name=%s", os.Getenv("AGIFY_API"), name))
+// updatePerson replaces the name fields of a person. Derived fields such as Age may
+// be sent back as a GET returned them, but changing them is refused; see personFromPut.
+func updatePerson(w http.ResponseWriter, r *http.Request) {
+	personID := personIDFrom(r)
+
+	existingPerson, err := findPerson(db, personID)
 	if err != nil {
-		log.Printf("Error fetching Agify data: %v", err)
-		return 0
+		respondServiceError(w, err, "Failed to load person")
+		return
 	}
 
-	age, _ := response["age"].(float64)
-	return int(age)
-}
-
-func getGenderizeGender(name string) string {
-	var response map[string]interface{}
-	_, err := client.R().
-		SetResult(&response).
-		Get(fmt.Sprintf("%s/?name=%s", os.Getenv("GENDERIZE_API"), name))
+	var fields map[string]json.RawMessage
+	if !decodeJSONBody(w, r, &fields) {
+		return
+	}
+	updatedPerson, err := personFromPut(fields, &existingPerson)
 	if err != nil {
-		log.Printf("Error fetching Genderize data: %v", err)
-		return ""
+		respondServiceError(w, err, "Internal server error")
+		return
 	}
 
-	gender, _ := response["gender"].(string)
-	return gender
+	saveNameUpdate(w, r, existingPerson, updatedPerson)
 }
 
-func getNationality(name string) string {
-	var response map[string]interface{}
-	_, err := client.R().
-		SetResult(&response).
-		Get(fmt.Sprintf("%s/?name=%s", os.Getenv("NATIONALIZE_API"), name))
-	if err != nil {
-		log.Printf("Error fetching Nationalize data: %v", err)
-		return ""
+// saveNameUpdate finishes a PUT or PATCH that sets the name fields of existing to
+// those of updated: the result is validated, re-enriched when the change or stale
+// data calls for it unless manually overridden, and saved with its history
+func saveNameUpdate(w http.ResponseWriter, r *http.Request, existing, updated Person) {
+	before := existing
+	existing.Name = updated.Name
+	existing.Surname = updated.Surname
+	existing.Patronymic = updated.Patronymic
+
+	if err := validatePerson(&existing); err != nil {
+		respondServiceError(w, err, "Internal server error")
+		return
+	}
+
+	if forceEnrichment(r, &existing) ||
+		!existing.ManualOverride && (enrichmentTriggered(&before, &existing) || !enrichmentFresh(&existing)) {
+		enrichPersonData(r.Context(), &existing)
 	}
 
-	nationality, _ := response["country"].([]interface{})[0].(map[string]interface{})["country_id"].(string)
-	return nationality
+	if err := savePersonWithHistory(r, &before, &existing); err != nil {
+		respondDBError(w, err, "Failed to update person")
+		return
+	}
+
+	respondWritten(w, r, http.StatusOK, &existing, existing)
 }
 
-func updatePerson(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	personID, err := strconv.Atoi(params["id"])
+// patchPerson applies a JSON Merge Patch (RFC 7386): null clears a field, omitted fields
+// are left untouched. Fields other than the names are handled as by PUT; see personFromPatch.
+func patchPerson(w http.ResponseWriter, r *http.Request) {
+	personID := personIDFrom(r)
+
+	existingPerson, err := findPerson(db, personID)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid person ID")
+		respondServiceError(w, err, "Failed to load person")
 		return
 	}
 
-	var existingPerson Person
-	if err := db.First(&existingPerson, personID).Error; err != nil {
-		respondError(w, http.StatusNotFound, "Person not found")
+	var fields map[string]json.RawMessage
+	if !decodeJSONBody(w, r, &fields) {
 		return
 	}
-
-	var updatedPerson Person
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&updatedPerson); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request payload")
+	patchedPerson, err := personFromPatch(fields, &existingPerson)
+	if err != nil {
+		respondServiceError(w, err, "Internal server error")
 		return
 	}
-	defer r.Body.Close()
-
-	existingPerson.Name = updatedPerson.Name
-	existingPerson.Surname = updatedPerson.Surname
-	existingPerson.Patronymic = updatedPerson.Patronymic
-
-	enrichPersonData(&existingPerson)
 
-	db.Save(&existingPerson)
-
-	respondJSON(w, http.StatusOK, existingPerson)
+	saveNameUpdate(w, r, existingPerson, patchedPerson)
 }
 
+// deletePerson soft-deletes a person. Deletes are idempotent by default: deleting a
+// person that doesn't exist (or was already deleted) succeeds as if it had just been
+// deleted, so a retried request doesn't report a failure. Set IDEMPOTENT_DELETE=false
+// to answer 404 instead.
+//
+// A successful delete answers 200 with a JSON message by default, kept for existing
+// clients; DELETE_NO_CONTENT=true switches to 204 No Content with an empty body.
 func deletePerson(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	personID, err := strconv.Atoi(params["id"])
+	personID := personIDFrom(r)
+
+	person, err := findPerson(db, personID)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid person ID")
+		if errors.Is(err, errNotFound) && idempotentDeleteSetting.get() {
+			respondDeleted(w)
+			return
+		}
+		respondServiceError(w, err, "Failed to load person")
 		return
 	}
 
-	var person Person
-	if err := db.First(&person, personID).Error; err != nil {
-		respondError(w, http.StatusNotFound, "Person not found")
+	if err := deletePersonWithHistory(r, &person); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete person")
 		return
 	}
 
-	db.Delete(&person)
-
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Person deleted successfully"})
+	respondDeleted(w)
 }
 
-func respondJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+func respondDeleted(w http.ResponseWriter) {
+	if deleteNoContentSetting.get() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Person deleted successfully"})
 }
-
-func respondError(w http.ResponseWriter, code int, message string) {
-	respondJSON(w, code, map[string]string{"error": message})
-}
\ No newline at end of file
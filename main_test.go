@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// testAdminToken is the ADMIN_TOKEN every test server runs with
+const testAdminToken = "test-admin-token"
+
+// stubProviders answers the provider APIs in place of the network, through the
+// resty client's transport. Each provider is reached at http://<name>.test and
+// answered by its handler, which gets the query it was called with, along with
+// any headers set for it; calls are counted.
+type stubProviders struct {
+	sync.Mutex
+	calls    map[string]int
+	handlers map[string]func(query url.Values) (int, string)
+	headers  map[string]http.Header
+}
+
+func newStubProviders() *stubProviders {
+	s := &stubProviders{calls: map[string]int{}, handlers: map[string]func(url.Values) (int, string){}, headers: map[string]http.Header{}}
+	s.answer(agifyProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, fmt.Sprintf(`{"name":%q,"age":42,"count":10}`, name)
+	})
+	s.answer(genderizeProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, fmt.Sprintf(`{"name":%q,"gender":"male","probability":0.99}`, name)
+	})
+	s.answer(nationalizeProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, fmt.Sprintf(`{"name":%q,"country":[{"country_id":"RU","probability":0.7},{"country_id":"UA","probability":0.2}]}`, name)
+	})
+	return s
+}
+
+// answer replaces the handler of the provider called name with one given only the name looked up
+func (s *stubProviders) answer(name string, handler func(name string) (int, string)) {
+	s.answerQuery(name, func(query url.Values) (int, string) {
+		return handler(query.Get("name"))
+	})
+}
+
+// answerQuery replaces the handler of the provider called name with one given the whole query
+func (s *stubProviders) answerQuery(name string, handler func(query url.Values) (int, string)) {
+	s.Lock()
+	s.handlers[name] = handler
+	s.Unlock()
+}
+
+// setHeaders adds header to every response of the provider called name
+func (s *stubProviders) setHeaders(name string, header http.Header) {
+	s.Lock()
+	s.headers[name] = header
+	s.Unlock()
+}
+
+// fail makes the provider called name answer 500 to every lookup
+func (s *stubProviders) fail(name string) {
+	s.answer(name, func(string) (int, string) {
+		return http.StatusInternalServerError, `{"error":"stubbed failure"}`
+	})
+}
+
+func (s *stubProviders) callCount(name string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.calls[name]
+}
+
+func (s *stubProviders) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := strings.TrimSuffix(req.URL.Hostname(), ".test")
+	s.Lock()
+	s.calls[name]++
+	handler, ok := s.handlers[name]
+	extra := s.headers[name]
+	s.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no stub for %s", req.URL.Host)
+	}
+
+	// Like a real transport, give up once the call's context ends, however slow the handler
+	type answer struct {
+		status int
+		body   string
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		status, body := handler(req.URL.Query())
+		answered <- answer{status, body}
+	}()
+	var status int
+	var body string
+	select {
+	case a := <-answered:
+		status, body = a.status, a.body
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	for key, values := range extra {
+		header[key] = values
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// newTestServer serves the router as main does, against a fresh SQLite database
+// and stubbed providers. The package-level state it replaces is restored when
+// the test ends, so tests must not run in parallel.
+func newTestServer(t testing.TB) (*httptest.Server, *stubProviders) {
+	t.Helper()
+
+	conn, err := gorm.Open("sqlite3", filepath.Join(t.TempDir(), "people.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("Error opening test database: %v", err)
+	}
+	// gorm's own logging of callbacks and Postgres-only migrations would drown the test output
+	conn.SetLogger(gorm.Logger{LogWriter: log.New(io.Discard, "", 0)})
+	previousDB := db
+	db = conn
+	invalidateResponseCacheOnWrite(db)
+	migrateDB()
+
+	stub := newStubProviders()
+	previousTransport := client.GetClient().Transport
+	client.SetTransport(stub)
+	for _, p := range enrichmentProviders {
+		t.Setenv(p.EnvVar, "http://"+p.Name+".test")
+		t.Setenv(p.FallbackEnvVar, "")
+	}
+	t.Setenv("ADMIN_TOKEN", testAdminToken)
+
+	previousCache := enrichCache
+	enrichCache = newMemoryCache(defaultEnrichCacheSize)
+	flushResponseCache()
+	providerStatusCache.Lock()
+	providerStatusCache.statuses = nil
+	providerStatusCache.Unlock()
+	providerQuotas.Lock()
+	providerQuotas.byProvider = map[string]ProviderQuota{}
+	providerQuotas.Unlock()
+
+	server := httptest.NewServer(withCORS(newRouter()))
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+		db = previousDB
+		client.SetTransport(previousTransport)
+		enrichCache = previousCache
+		disabledProviders.Lock()
+		disabledProviders.names = map[string]bool{}
+		disabledProviders.Unlock()
+	})
+	return server, stub
+}
+
+// call sends a request with an optional JSON body and returns the response with its body read
+func call(t testing.TB, server *httptest.Server, method, path, body string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatalf("Error building request: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error calling %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response of %s %s: %v", method, path, err)
+	}
+	return resp, data
+}
+
+// adminCall is call authorized with the test ADMIN_TOKEN
+func adminCall(t testing.TB, server *httptest.Server, method, path, body string) (*http.Response, []byte) {
+	t.Helper()
+	return call(t, server, method, path, body, "Authorization", "Bearer "+testAdminToken)
+}
+
+// decode unmarshals a response body, failing the test if it isn't valid JSON
+func decode(t testing.TB, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("Error decoding %s: %v", data, err)
+	}
+}
+
+// createTestPerson creates a person through the API and returns it as answered
+func createTestPerson(t testing.TB, server *httptest.Server, body string) Person {
+	t.Helper()
+
+	resp, data := call(t, server, http.MethodPost, "/people", body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /people answered %d: %s", resp.StatusCode, data)
+	}
+	var person Person
+	decode(t, data, &person)
+	return person
+}
+
+func TestCreateAndGetPerson(t *testing.T) {
+	server, stub := newTestServer(t)
+
+	created := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+	if created.Age == nil || *created.Age != 42 || created.Gender != "male" || created.Nationality != "RU" {
+		t.Fatalf("Created person not enriched: %+v", created)
+	}
+	if created.LikelyRegion != "Europe" {
+		t.Errorf("LikelyRegion = %q, want Europe", created.LikelyRegion)
+	}
+
+	resp, data := call(t, server, http.MethodGet, fmt.Sprintf("/people/%d", created.ID), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET answered %d: %s", resp.StatusCode, data)
+	}
+	var fetched Person
+	decode(t, data, &fetched)
+	if fetched.Name != "Dmitriy" || fetched.Surname != "Ushakov" || fetched.Nationality != "RU" {
+		t.Errorf("Fetched %+v, want the created person", fetched)
+	}
+
+	for _, p := range enrichmentProviders {
+		if n := stub.callCount(p.Name); n != 1 {
+			t.Errorf("%s called %d times, want 1", p.Name, n)
+		}
+	}
+}
+
+func TestGetMissingPerson(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, data := call(t, server, http.MethodGet, "/people/999", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /people/999 answered %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestDatabaseFailureIsNotReportedNotFound(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+	t.Setenv("IDEMPOTENT_DELETE", "false")
+	methods := map[string]string{http.MethodGet: "", http.MethodPut: `{"name":"Ivan"}`, http.MethodPatch: `{"name":"Ivan"}`, http.MethodDelete: ""}
+
+	for method, body := range methods {
+		if resp, data := call(t, server, method, "/people/999", body); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of a missing person answered %d: %s", method, resp.StatusCode, data)
+		}
+	}
+
+	db.DropTable(&Person{})
+	for method, body := range methods {
+		resp, data := call(t, server, method, path, body)
+		if resp.StatusCode != http.StatusInternalServerError || strings.Contains(string(data), "not found") {
+			t.Errorf("%s without a people table answered %d: %s", method, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestListFailureIsInternal(t *testing.T) {
+	server, _ := newTestServer(t)
+	storePeople(t, 1)
+	db.DropTable(&Person{})
+
+	for _, path := range []string{"/people", "/people?limit=1"} {
+		if resp, data := call(t, server, http.MethodGet, path, ""); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("GET %s without a people table answered %d: %s", path, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestCreatePersonValidation(t *testing.T) {
+	server, stub := newTestServer(t)
+
+	resp, data := call(t, server, http.MethodPost, "/people", `{"surname":"Ushakov"}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("POST without a name answered %d: %s", resp.StatusCode, data)
+	}
+	if n := stub.callCount(agifyProvider.Name); n != 0 {
+		t.Errorf("Invalid person was enriched: agify called %d times", n)
+	}
+}
+
+func TestMergePatchPerson(t *testing.T) {
+	server, stub := newTestServer(t)
+	// Every lookup reaches the stubs, so the call count shows when a patch re-enriched
+	enrichCache = noCache{}
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	patch := func(body string) Person {
+		t.Helper()
+		resp, data := call(t, server, http.MethodPatch, path, body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PATCH %s answered %d: %s", body, resp.StatusCode, data)
+		}
+		var patched Person
+		decode(t, data, &patched)
+		return patched
+	}
+
+	patched := patch(`{"patronymic":"Petrovich"}`)
+	if patched.Name != "Dmitriy" || patched.Surname != "Ushakov" || patched.Patronymic != "Petrovich" {
+		t.Errorf("Setting the patronymic gave %+v, want the other names untouched", patched)
+	}
+	patched = patch(`{"patronymic":null}`)
+	if patched.Patronymic != "" || patched.Surname != "Ushakov" {
+		t.Errorf("Clearing the patronymic gave %+v", patched)
+	}
+	if n := stub.callCount(agifyProvider.Name); n != 1 {
+		t.Errorf("Agify called %d times after patches leaving the name alone, want 1", n)
+	}
+
+	patch(`{"name":"Ivan"}`)
+	if n := stub.callCount(agifyProvider.Name); n != 2 {
+		t.Errorf("Agify called %d times after the name changed, want 2", n)
+	}
+}
+
+func TestDeleteIsIdempotent(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK} {
+		if resp, data := call(t, server, http.MethodDelete, path, ""); resp.StatusCode != want {
+			t.Errorf("Delete %d answered %d: %s", i+1, resp.StatusCode, data)
+		}
+	}
+	if resp, _ := call(t, server, http.MethodGet, path, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after the delete answered %d, want 404", resp.StatusCode)
+	}
+
+	t.Setenv("DELETE_NO_CONTENT", "true")
+	if resp, data := call(t, server, http.MethodDelete, "/people/999", ""); resp.StatusCode != http.StatusNoContent || len(data) != 0 {
+		t.Errorf("Delete of a missing person answered %d: %q, want 204 and no body", resp.StatusCode, data)
+	}
+
+	t.Setenv("IDEMPOTENT_DELETE", "false")
+	if resp, data := call(t, server, http.MethodDelete, path, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Repeated delete with IDEMPOTENT_DELETE=false answered %d: %s", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when the client accepts none of the catalog languages
+const defaultLanguage = "en"
+
+// messageCatalog translates the English error messages, keyed by language then message.
+// Messages missing from a language fall back to English. An entry with formatting
+// verbs translates every message built from it; see translate.
+var messageCatalog = map[string]map[string]string{
+	"ru": {
+		"Invalid person ID":                                                    "Некорректный ID человека",
+		"Invalid request payload":                                              "Некорректное тело запроса",
+		"Provider not found":                                                   "Провайдер не найден",
+		"Age must be between 0 and 150":                                        "Возраст должен быть от 0 до 150",
+		"Age must be an integer between 0 and 150":                             "Возраст должен быть целым числом от 0 до 150",
+		"Gender must be male or female":                                        "Пол должен быть male или female",
+		"Nationality must be an ISO 3166-1 country code":                       "Национальность должна быть кодом страны ISO 3166-1",
+		"Person not found":                                                     "Человек не найден",
+		"Not found":                                                            "Не найдено",
+		"Name is required":                                                     "Имя обязательно",
+		"Internal server error":                                                "Внутренняя ошибка сервера",
+		"Failed to count people":                                               "Не удалось посчитать людей",
+		"Failed to load people":                                                "Не удалось загрузить людей",
+		"Failed to load history":                                               "Не удалось загрузить историю",
+		"Failed to update person":                                              "Не удалось обновить данные человека",
+		"Failed to update people":                                              "Не удалось обновить данные людей",
+		"Failed to delete person":                                              "Не удалось удалить человека",
+		"Failed to create person":                                              "Не удалось создать человека",
+		"Person already exists":                                                "Такой человек уже существует",
+		"Invalid threshold, expected a number between 0 and 1":                 "Некорректный порог, ожидается число от 0 до 1",
+		"Updating every person requires confirm=true":                          "Для обновления всех людей требуется confirm=true",
+		"A valid name is required":                                             "Требуется корректное имя",
+		"Content-Type must be application/json":                                "Content-Type должен быть application/json",
+		"A valid country code is required":                                     "Требуется корректный код страны",
+		"Invalid format, expected csv or json":                                 "Некорректный формат, ожидается csv или json",
+		"Failed to load enrichment failures":                                   "Не удалось загрузить ошибки обогащения",
+		"Person has a manual override, use force=true to clear it":             "У человека ручные значения, используйте force=true, чтобы их сбросить",
+		"Admin authorization required":                                         "Требуется авторизация администратора",
+		"Failed to import dataset":                                             "Не удалось импортировать данные",
+		"Invalid on_conflict, expected fail, skip, overwrite or renumber":      "Некорректный on_conflict, ожидается fail, skip, overwrite или renumber",
+		"Invalid updated_since, expected an RFC 3339 timestamp":                "Некорректный updated_since, ожидается метка времени RFC 3339",
+		"Invalid cursor":                                                       "Некорректный курсор",
+		"Invalid limit, expected a positive integer":                           "Некорректный limit, ожидается положительное целое число",
+		"Failed to load person":                                                "Не удалось загрузить данные человека",
+		"Job not found":                                                        "Задача не найдена",
+		"Too many pending jobs, try again later":                               "Слишком много ожидающих задач, повторите попытку позже",
+		"Server is overloaded, try again later":                                "Сервер перегружен, повторите попытку позже",
+		"Invalid enriched, expected true or false":                             "Некорректный enriched, ожидается true или false",
+		"Enrichment quota exhausted, retry later":                              "Квота обогащения исчерпана, повторите попытку позже",
+		"Person has a manual override, use force=true to replace it":           "У человека ручные значения, используйте force=true, чтобы их заменить",
+		"Invalid target format, expected alpha2 or alpha3":                     "Некорректный целевой формат, ожидается alpha2 или alpha3",
+		"Invalid bucket, it must be a whole number of years between 1 and 120": "Некорректный интервал, он должен быть целым числом лет от 1 до 120",
+		"Failed to compute age histogram":                                      "Не удалось построить гистограмму возрастов",
+		"Failed to compute people stats":                                       "Не удалось подсчитать статистику людей",
+		"Failed to count nationalities":                                        "Не удалось подсчитать гражданства",
+		"Invalid CSV payload":                                                  "Некорректные данные CSV",
+		"Failed to flush enrichment cache":                                     "Не удалось очистить кэш обогащения",
+
+		// Messages built with formatting verbs, matched by catalogFormats
+		"%s must be at most %d characters":                             "%s: не более %d символов",
+		"%s may only contain letters, hyphens, apostrophes and spaces": "%s может содержать только буквы, дефисы, апострофы и пробелы",
+		"Field %s cannot be set through %s":                            "Поле %s нельзя изменить через %s",
+		"Invalid value for field %s":                                   "Некорректное значение поля %s",
+		"A batch can hold at most %d items, got %d":                    "Пакет может содержать не более %d элементов, получено %d",
+		"A batch can hold at most %d items":                            "Пакет может содержать не более %d элементов",
+		"A CSV upload can be at most %d bytes":                         "Загружаемый CSV может занимать не более %d байт",
+		"At most %d random people can be requested":                    "Можно запросить не более %d случайных людей",
+		"Person %d: %v":                                                   "Человек %d: %v",
+		"Person %d already exists":                                        "Человек %d уже существует",
+		"No fields to update":                                             "Нет полей для обновления",
+		"Field %s cannot be bulk updated":                                 "Поле %s нельзя изменить массовым обновлением",
+		"Invalid %s, expected a non-negative integer":                     "Некорректный %s, ожидается неотрицательное целое число",
+		"Invalid %s, expected an RFC 3339 timestamp or a YYYY-MM-DD date": "Некорректный %s, ожидается метка времени RFC 3339 или дата YYYY-MM-DD",
+		"At most %d nationalities can be filtered on":                     "Фильтровать можно не более чем по %d гражданствам",
+		"Invalid nationality code %q":                                     "Некорректный код гражданства %q",
+		"At most %d ids can be requested at once":                         "За раз можно запросить не более %d ID",
+		"Invalid person ID %q":                                            "Некорректный ID человека %q",
+		"Required provider %s is disabled":                                "Обязательный провайдер %s отключён",
+		"Enrichment failed for required provider %s":                      "Не удалось обогащение обязательным провайдером %s",
+		"enrichment failed for %s":                                        "не удалось обогащение провайдерами: %s",
+		"Operator like needs a text field, %s is not one":                 "Оператору like нужно текстовое поле, а %s им не является",
+		"Field %q cannot be queried":                                      "Поле %q недоступно для запроса",
+		"Operator %q is not allowed":                                      "Оператор %q не разрешён",
+		"Operator in needs a non-empty list for field %s":                 "Оператору in нужен непустой список для поля %s",
+		"Operator like needs a string for field %s":                       "Оператору like нужна строка для поля %s",
+	},
+}
+
+// catalogFormat is a catalog entry with formatting verbs: pattern matches the
+// messages its English format produces, capturing the values filled in, and
+// translated takes them back in the same order
+type catalogFormat struct {
+	pattern    *regexp.Regexp
+	translated string
+}
+
+// formatVerb matches the verbs catalog formats may use
+var formatVerb = regexp.MustCompile(`%(\[\d+\])?[sdvq]`)
+
+// catalogFormats lists the entries with verbs of each language, longest first
+// so that the most specific format wins
+var catalogFormats = compileCatalogFormats()
+
+func compileCatalogFormats() map[string][]catalogFormat {
+	formats := map[string][]catalogFormat{}
+	for lang, messages := range messageCatalog {
+		var english []string
+		for message := range messages {
+			if formatVerb.MatchString(message) {
+				english = append(english, message)
+			}
+		}
+		sort.Slice(english, func(i, j int) bool {
+			if len(english[i]) != len(english[j]) {
+				return len(english[i]) > len(english[j])
+			}
+			return english[i] < english[j]
+		})
+
+		for _, message := range english {
+			formats[lang] = append(formats[lang], catalogFormat{
+				pattern: formatPattern(message),
+				// The captured values are text, whatever verb printed them
+				translated: formatVerb.ReplaceAllString(messages[message], "%${1}s"),
+			})
+		}
+	}
+	return formats
+}
+
+// formatPattern turns a format into a regexp matching the whole messages it produces
+func formatPattern(format string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range formatVerb.FindAllStringIndex(format, -1) {
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		switch format[loc[1]-1] {
+		case 'd':
+			pattern.WriteString(`(-?\d+)`)
+		case 'q':
+			pattern.WriteString(`("(?:[^"\\]|\\.)*")`)
+		default:
+			pattern.WriteString(`(.+?)`)
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]) + "$")
+	return regexp.MustCompile(pattern.String())
+}
+
+// translate returns message in lang, or unchanged when no translation exists.
+// A message built from a catalog format, such as "Invalid value for field Age",
+// is translated with the values it was built from kept as they are.
+func translate(lang, message string) string {
+	if translated, ok := messageCatalog[lang][message]; ok {
+		return translated
+	}
+	for _, format := range catalogFormats[lang] {
+		if values := format.pattern.FindStringSubmatch(message); values != nil {
+			args := make([]interface{}, len(values)-1)
+			for i, v := range values[1:] {
+				args[i] = v
+			}
+			return fmt.Sprintf(format.translated, args...)
+		}
+	}
+	return message
+}
+
+// negotiateLanguage picks the catalog language the client prefers most from its Accept-Language header
+func negotiateLanguage(r *http.Request) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.SplitN(fields[0], "-", 2)[0])
+		if lang == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		// q=0 marks a language the client does not accept
+		if q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{lang, q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if _, ok := messageCatalog[c.lang]; ok || c.lang == defaultLanguage {
+			return c.lang
+		}
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLanguageSkipsRefusedLanguages(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        defaultLanguage,
+		"ru-RU,ru;q=0.9,en;q=0.5": "ru",
+		"en;q=0.4, ru;q=0.8":      "ru",
+		"ru;q=0, en":              "en",
+		"ru;q=0":                  defaultLanguage,
+		"de, ru;q=0.5":            "ru",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/people", nil)
+		r.Header.Set("Accept-Language", header)
+		if got := negotiateLanguage(r); got != want {
+			t.Errorf("Accept-Language %q negotiated %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestFormattedMessagesTranslated(t *testing.T) {
+	for message, want := range map[string]string{
+		"Person not found":                                 "Человек не найден",
+		"Invalid value for field Age":                      "Некорректное значение поля Age",
+		"A batch can hold at most 5 items, got 7":          "Пакет может содержать не более 5 элементов, получено 7",
+		"A batch can hold at most 5 items":                 "Пакет может содержать не более 5 элементов",
+		`Invalid nationality code "XX"`:                    `Некорректный код гражданства "XX"`,
+		"Invalid age_min, expected a non-negative integer": "Некорректный age_min, ожидается неотрицательное целое число",
+		"Something nobody translated":                      "Something nobody translated",
+	} {
+		if got := translate("ru", message); got != want {
+			t.Errorf("translate(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestValidationErrorsAnsweredInRussian(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, data := call(t, server, http.MethodPost, "/people", `{"name":"R2D2"}`, "Accept-Language", "ru")
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Create answered %d: %s", resp.StatusCode, data)
+	}
+	var body struct{ Error string }
+	decode(t, data, &body)
+	if want := "Name может содержать только буквы, дефисы, апострофы и пробелы"; body.Error != want {
+		t.Errorf("Error = %q, want %q", body.Error, want)
+	}
+}
+
+func TestNotFoundAnsweredInRequestedLanguage(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for language, want := range map[string]string{
+		"":      "Person not found",
+		"ru":    "Человек не найден",
+		"de-DE": "Person not found",
+	} {
+		resp, data := call(t, server, http.MethodGet, "/people/999", "", "Accept-Language", language)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("GET of a missing person answered %d: %s", resp.StatusCode, data)
+		}
+		var body struct{ Error string }
+		decode(t, data, &body)
+		if body.Error != want {
+			t.Errorf("Accept-Language %q: error = %q, want %q", language, body.Error, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+// providerMetrics exposes per-provider call counts and latency at /debug/vars.
+// malformed counts responses rejected by decodeProviderResponse.
+var providerMetrics = struct {
+	calls      *expvar.Map
+	failures   *expvar.Map
+	malformed  *expvar.Map
+	durationMs *expvar.Map
+}{
+	calls:      expvar.NewMap("provider_calls"),
+	failures:   expvar.NewMap("provider_failures"),
+	malformed:  expvar.NewMap("provider_malformed_responses"),
+	durationMs: expvar.NewMap("provider_duration_ms"),
+}
+
+// observeProviderCall records the latency and outcome of one upstream call.
+// The log line can be turned off with LOG_PROVIDER_LATENCY=false.
+func observeProviderCall(provider, name string, duration time.Duration, err error) {
+	providerMetrics.calls.Add(provider, 1)
+	providerMetrics.durationMs.Add(provider, duration.Milliseconds())
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+		providerMetrics.failures.Add(provider, 1)
+	}
+
+	if logProviderLatencySetting.get() {
+		log.Printf("Provider %s answered for name %q in %s: %s", provider, logName(name), duration.Round(time.Millisecond), outcome)
+	}
+}
+
+// debugVars serves /debug/vars like expvar.Handler, but only to admins or in a
+// development environment, as the metrics reveal traffic and provider health
+func debugVars(w http.ResponseWriter, r *http.Request) {
+	if !isDevEnvironment() && !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugVarsRequireAdmin(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("APP_ENV", "production")
+
+	if resp, data := call(t, server, http.MethodGet, "/debug/vars", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unauthorized /debug/vars answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodGet, "/debug/vars", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), "provider_calls") {
+		t.Errorf("Admin /debug/vars answered %d: %s", resp.StatusCode, data)
+	}
+}
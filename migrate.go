@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+)
+
+// peopleIndexes back the list filters in applyPeopleFilters:
+//
+//	name, surname       -> trigram GIN indexes serving ILIKE '%...%'
+//	gender, nationality -> btree indexes serving equality and IN lookups
+//	age                 -> btree index serving age_min/age_max ranges
+//	created_at          -> btree index serving created_after/created_before
+//	updated_at, id      -> btree index serving updated_since and its cursor order
+var peopleIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_people_name_trgm ON people USING gin (name gin_trgm_ops)",
+	"CREATE INDEX IF NOT EXISTS idx_people_surname_trgm ON people USING gin (surname gin_trgm_ops)",
+	"CREATE INDEX IF NOT EXISTS idx_people_gender ON people (gender)",
+	"CREATE INDEX IF NOT EXISTS idx_people_nationality ON people (nationality)",
+	"CREATE INDEX IF NOT EXISTS idx_people_age ON people (age)",
+	"CREATE INDEX IF NOT EXISTS idx_people_created_at ON people (created_at)",
+	"CREATE INDEX IF NOT EXISTS idx_people_updated_at ON people (updated_at, id)",
+}
+
+// migrateIndexes creates the indexes behind the list filters. The trigram
+// indexes need the pg_trgm extension; if it can't be installed they are
+// skipped and ILIKE filters fall back to sequential scans.
+func migrateIndexes() {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("Could not enable pg_trgm, name searches will not be indexed: %v", err)
+	}
+
+	for _, stmt := range peopleIndexes {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Error creating index: %v", err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestMigrationCreatesFilterIndexes(t *testing.T) {
+	newTestServer(t)
+
+	// The trigram indexes need Postgres, the btree ones are created on SQLite too
+	for _, index := range []string{"idx_people_gender", "idx_people_nationality", "idx_people_age", "idx_people_created_at", "idx_people_updated_at"} {
+		var count int
+		db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'people' AND name = ?", index).Row().Scan(&count)
+		if count != 1 {
+			t.Errorf("Index %s missing after migration", index)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Field naming conventions for response bodies. Keys are the Go field names
+// unless RESPONSE_FIELD_NAMING or a naming parameter on the Accept media type,
+// such as "application/json; naming=camel", asks for another one.
+const (
+	namingDefault = ""
+	namingSnake   = "snake"
+	namingCamel   = "camel"
+)
+
+// namingSerializer renames struct fields before handing the value to the wrapped serializer.
+// Only struct field names change; map keys, such as provider names, are data and kept as is.
+type namingSerializer struct {
+	serializer
+	naming string
+}
+
+func (s namingSerializer) Encode(w io.Writer, v interface{}, pretty bool) error {
+	return s.serializer.Encode(w, renameFields(reflect.ValueOf(v), s.naming), pretty)
+}
+
+// withNaming wraps s so its output follows naming
+func withNaming(s serializer, naming string) serializer {
+	if naming != namingSnake && naming != namingCamel {
+		return s
+	}
+	return namingSerializer{s, naming}
+}
+
+// withoutNaming undoes withNaming, for output that must keep the Go field names
+func withoutNaming(s serializer) serializer {
+	if n, ok := s.(namingSerializer); ok {
+		return n.serializer
+	}
+	return s
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// renameFields converts structs reachable from v into maps keyed by their renamed
+// JSON field names, honoring json tags and embedded structs such as gorm.Model.
+// Values that marshal themselves, like time.Time, are left alone.
+func renameFields(v reflect.Value, naming string) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return renameFields(v.Elem(), naming)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = renameFields(v.Index(i), naming)
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = renameFields(iter.Value(), naming)
+		}
+		return out
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		addStructFields(out, v, naming)
+		return out
+	}
+	return v.Interface()
+}
+
+func addStructFields(out map[string]interface{}, v reflect.Value, naming string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, opts := field.Name, ""
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			var tagName string
+			tagName, opts, _ = strings.Cut(tag, ",")
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		value := v.Field(i)
+		if field.Anonymous && name == field.Name && value.Kind() == reflect.Struct {
+			addStructFields(out, value, naming)
+			continue
+		}
+		if strings.Contains(opts, "omitempty") && value.IsZero() {
+			continue
+		}
+		out[renameField(name, naming)] = renameFields(value, naming)
+	}
+}
+
+// renameField converts a Go field name such as GenderProbability or PersonID to
+// gender_probability and person_id, or genderProbability and personId
+func renameField(name, naming string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if naming == namingCamel && i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	if naming == namingCamel {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words, "_")
+}
+
+// splitWords splits an identifier at underscores and case changes, keeping acronyms whole
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && runes[i] == '_' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		boundary := i == len(runes)
+		if !boundary && unicode.IsUpper(runes[i]) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			acronymEnd := unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			boundary = prevLower || acronymEnd
+		}
+		if boundary && i > start {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return words
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// defaultNationalityCandidates is how many nationality candidates are kept when NATIONALITY_CANDIDATES is unset
+const defaultNationalityCandidates = 3
+
+// NationalityCandidate is one country Nationalize considers likely for a name
+type NationalityCandidate struct {
+	CountryID   string
+	Probability float64
+}
+
+// NationalityCandidates is stored as a JSON array in a text column
+type NationalityCandidates []NationalityCandidate
+
+// Value implements driver.Valuer
+func (c NationalityCandidates) Value() (driver.Value, error) {
+	if c == nil {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal(c)
+	return string(encoded), err
+}
+
+// Scan implements sql.Scanner
+func (c *NationalityCandidates) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("cannot scan %T into NationalityCandidates", src)
+	}
+}
+
+// topNationalityCandidates sorts candidates by descending probability and keeps the configured number
+func topNationalityCandidates(candidates NationalityCandidates) NationalityCandidates {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+
+	limit := nationalityCandidatesSetting.get()
+	if limit >= 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOnlyTopNationalityCandidatesStored(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("NATIONALITY_CANDIDATES", "2")
+	stub.answer(nationalizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"country":[
+			{"country_id":"UA","probability":0.1},
+			{"country_id":"KZ","probability":0.3},
+			{"country_id":"BY","probability":0.05},
+			{"country_id":"RU","probability":0.4},
+			{"country_id":"PL","probability":0.15}]}`
+	})
+
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	var stored Person
+	db.First(&stored, person.ID)
+	if len(stored.Nationalities) != 2 || stored.Nationalities[0].CountryID != "RU" || stored.Nationalities[1].CountryID != "KZ" {
+		t.Errorf("Stored candidates %+v, want RU then KZ", stored.Nationalities)
+	}
+	if stored.Nationality != "RU" {
+		t.Errorf("Nationality = %q, want the likeliest RU", stored.Nationality)
+	}
+}
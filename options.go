@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// registerOptions answers OPTIONS on every route path with 204 and an Allow header
+// listing the methods the path supports, for API discovery tools. CORS preflights
+// never get here, withCORS answers them first.
+func registerOptions(router *mux.Router) {
+	var paths []string
+	methods := map[string][]string{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		if _, ok := methods[path]; !ok {
+			paths = append(paths, path)
+		}
+		methods[path] = append(methods[path], routeMethods...)
+		return nil
+	})
+
+	// Literal paths such as /people/batch must be registered before /people/{id} would match them
+	sort.SliceStable(paths, func(i, j int) bool {
+		return !strings.Contains(paths[i], "{") && strings.Contains(paths[j], "{")
+	})
+	for _, path := range paths {
+		allow := strings.Join(methods[path], ", ")
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		}).Methods("OPTIONS")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOptionsListsAllowedMethods(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for path, want := range map[string]string{
+		"/people":             "GET, POST",
+		"/people/1":           "GET, PUT, PATCH, DELETE",
+		"/people/1/reconcile": "GET, POST",
+		"/people/batch":       "POST",
+	} {
+		resp, data := call(t, server, http.MethodOptions, path, "")
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Allow") != want {
+			t.Errorf("OPTIONS %s answered %d with Allow %q: %s, want 204 with %q", path, resp.StatusCode, resp.Header.Get("Allow"), data, want)
+		}
+	}
+
+	// A CORS preflight is answered by withCORS rather than with the route's methods
+	resp, _ := call(t, server, http.MethodOptions, "/people/1", "", "Origin", "https://app.example", "Access-Control-Request-Method", "DELETE")
+	if resp.Header.Get("Access-Control-Allow-Methods") == "" || resp.Header.Get("Allow") != "" {
+		t.Errorf("Preflight answered Allow %q and Access-Control-Allow-Methods %q, want only the CORS header", resp.Header.Get("Allow"), resp.Header.Get("Access-Control-Allow-Methods"))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PersonOverride is the body of PATCH /people/{id}/override. Omitted fields keep
+// their value; ManualOverride defaults to true and can be sent as false to hand
+// the record back to enrichment.
+type PersonOverride struct {
+	Age            *int
+	Gender         *string
+	Nationality    *string
+	ManualOverride *bool
+}
+
+// overridePerson lets an operator set the enrichment-derived fields authoritatively
+func overridePerson(w http.ResponseWriter, r *http.Request) {
+	personID := personIDFrom(r)
+
+	existingPerson, err := findPerson(db, personID)
+	if err != nil {
+		respondServiceError(w, err, "Failed to load person")
+		return
+	}
+
+	var override PersonOverride
+	if !decodeJSONBody(w, r, &override) {
+		return
+	}
+
+	before := existingPerson
+	var errs validationErrors
+	if override.Age != nil {
+		if *override.Age < 0 || *override.Age > 150 {
+			errs = append(errs, fieldError{"Age", "Age must be between 0 and 150"})
+		} else {
+			existingPerson.Age = intPtr(*override.Age)
+		}
+	}
+	if override.Gender != nil {
+		gender := strings.ToLower(*override.Gender)
+		if gender != "male" && gender != "female" && gender != "" {
+			errs = append(errs, fieldError{"Gender", "Gender must be male or female"})
+		} else {
+			existingPerson.Gender = gender
+			existingPerson.GenderProbability = 1
+		}
+	}
+	if override.Nationality != nil {
+		code, ok := storedCountryCode(*override.Nationality)
+		if *override.Nationality != "" && !ok {
+			errs = append(errs, fieldError{"Nationality", "Nationality must be an ISO 3166-1 country code"})
+		} else {
+			existingPerson.Nationality = checkKnownCountryCode("override", code)
+			existingPerson.Nationalities = nil
+			if code != "" {
+				existingPerson.Nationalities = NationalityCandidates{{CountryID: code, Probability: 1}}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		respondServiceError(w, errs, "Internal server error")
+		return
+	}
+
+	existingPerson.ManualOverride = true
+	if override.ManualOverride != nil {
+		existingPerson.ManualOverride = *override.ManualOverride
+	}
+
+	if err := savePersonWithHistory(r, &before, &existingPerson); err != nil {
+		respondDBError(w, err, "Failed to update person")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, existingPerson)
+}
+
+// clearEnrichment empties the enrichment-derived fields of a person, for when provider
+// data has changed, and with ?reenrich=true looks them up again straight away.
+// Manually overridden records are refused unless ?force=true, which also hands
+// them back to enrichment.
+func clearEnrichment(w http.ResponseWriter, r *http.Request) {
+	personID := personIDFrom(r)
+
+	existingPerson, err := findPerson(db, personID)
+	if err != nil {
+		respondServiceError(w, err, "Failed to load person")
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if existingPerson.ManualOverride && !force {
+		respondError(w, http.StatusConflict, "Person has a manual override, use force=true to clear it")
+		return
+	}
+
+	before := existingPerson
+	existingPerson.Age = nil
+	existingPerson.Gender = ""
+	existingPerson.GenderProbability = 0
+	existingPerson.Nationality = ""
+	existingPerson.Nationalities = nil
+	existingPerson.EnrichedAt = nil
+	existingPerson.AgeEnrichedAt = nil
+	existingPerson.GenderEnrichedAt = nil
+	existingPerson.NationalityEnrichedAt = nil
+	existingPerson.ManualOverride = false
+
+	if reenrich, _ := strconv.ParseBool(r.URL.Query().Get("reenrich")); reenrich {
+		enrichPersonData(r.Context(), &existingPerson)
+	}
+
+	if err := savePersonWithHistory(r, &before, &existingPerson); err != nil {
+		respondDBError(w, err, "Failed to update person")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, existingPerson)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestOverrideSurvivesReenrichment(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	overridden := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	other := createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+	path := fmt.Sprintf("/people/%d", overridden.ID)
+
+	resp, data := call(t, server, http.MethodPatch, path+"/override", `{"Age":30,"Gender":"female","Nationality":"KZ"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Override answered %d: %s", resp.StatusCode, data)
+	}
+	var person Person
+	decode(t, data, &person)
+	if !person.ManualOverride || *person.Age != 30 || person.Gender != "female" || person.GenderProbability != 1 || person.Nationality != "KZ" {
+		t.Fatalf("Override answered %+v", person)
+	}
+
+	// Both look unsure, so only the override flag keeps one out of the low-confidence re-enrichment
+	db.Model(&Person{}).Where("id IN (?)", []uint{overridden.ID, other.ID}).UpdateColumn("gender_probability", 0.5)
+	resp, data = adminCall(t, server, http.MethodPost, "/admin/reenrich/low-confidence", "")
+	var result map[string]int
+	decode(t, data, &result)
+	if resp.StatusCode != http.StatusOK || result["reenriched"] != 1 {
+		t.Errorf("Re-enrichment answered %d: %s, want only the other person re-enriched", resp.StatusCode, data)
+	}
+
+	calls := stub.callCount(agifyProvider.Name)
+	if resp, data := call(t, server, http.MethodPut, path+"?force_enrich=true", `{"name":"Dmitry","surname":"Ushakov"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Rename answered %d: %s", resp.StatusCode, data)
+	}
+	if resp, _ := call(t, server, http.MethodPost, path+"/clear-enrichment", ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Clearing an override answered %d, want 409", resp.StatusCode)
+	}
+	if n := stub.callCount(agifyProvider.Name) - calls; n != 0 {
+		t.Errorf("Overridden person re-enriched with %d agify calls", n)
+	}
+
+	var stored Person
+	db.First(&stored, overridden.ID)
+	if !stored.ManualOverride || *stored.Age != 30 || stored.Gender != "female" || stored.Nationality != "KZ" {
+		t.Errorf("Stored %+v, want the override kept", stored)
+	}
+
+	// Sending ManualOverride false hands the record back to enrichment
+	call(t, server, http.MethodPatch, path+"/override", `{"ManualOverride":false}`)
+	call(t, server, http.MethodPut, path+"?force_enrich=true", `{"name":"Dmitry","surname":"Ushakov"}`)
+	db.First(&stored, overridden.ID)
+	if stored.ManualOverride || *stored.Age != 42 || stored.Gender != "male" {
+		t.Errorf("Stored %+v, want it re-enriched once released", stored)
+	}
+}
+
+func TestClearEnrichment(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("UNKNOWN_AGE", "null")
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d/clear-enrichment", person.ID)
+
+	calls := stub.callCount(agifyProvider.Name)
+	resp, data := call(t, server, http.MethodPost, path, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Clear answered %d: %s", resp.StatusCode, data)
+	}
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.Age != nil || stored.Gender != "" || stored.Nationality != "" || len(stored.Nationalities) != 0 || stored.EnrichedAt != nil {
+		t.Errorf("Stored %+v after a clear, want no enrichment", stored)
+	}
+	if n := stub.callCount(agifyProvider.Name) - calls; n != 0 {
+		t.Errorf("Clear-only called agify %d times", n)
+	}
+
+	resp, data = call(t, server, http.MethodPost, path+"?reenrich=true", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Clear and re-enrich answered %d: %s", resp.StatusCode, data)
+	}
+	db.First(&stored, person.ID)
+	if stored.Age == nil || *stored.Age != 42 || stored.Gender != "male" || stored.Nationality != "RU" || stored.EnrichedAt == nil {
+		t.Errorf("Stored %+v after clear and re-enrich, want it enriched again", stored)
+	}
+
+	call(t, server, http.MethodPatch, fmt.Sprintf("/people/%d/override", person.ID), `{"Age":30}`)
+	if resp, _ := call(t, server, http.MethodPost, path, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Clearing an override answered %d, want 409", resp.StatusCode)
+	}
+	if resp, data := call(t, server, http.MethodPost, path+"?force=true", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Forced clear of an override answered %d: %s", resp.StatusCode, data)
+	}
+	db.First(&stored, person.ID)
+	if stored.ManualOverride || stored.Age != nil {
+		t.Errorf("Stored %+v after a forced clear, want the override released and cleared", stored)
+	}
+}
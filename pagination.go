@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// defaultMaxPageLimit caps page sizes when MAX_PAGE_LIMIT is unset
+const defaultMaxPageLimit = 200
+
+// clampLimit lowers limit to the configured maximum page size, logging when it does
+func clampLimit(limit int) int {
+	max := maxPageLimitSetting.get()
+	if limit > max {
+		log.Printf("Clamping requested limit %d to the maximum of %d", limit, max)
+		return max
+	}
+	return limit
+}
+
+// applyPagination applies ?limit= and ?offset= to query, ordered by id so pages are stable.
+// Without a limit every matching row is returned; a given limit is clamped to MAX_PAGE_LIMIT.
+func applyPagination(query *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	return paginate(query, r, clampLimit)
+}
+
+// applyStreamPagination is applyPagination without the clamp, for streamed lists:
+// they hold one row in memory at a time, so a large limit costs nothing
+func applyStreamPagination(query *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	return paginate(query, r, func(limit int) int { return limit })
+}
+
+func paginate(query *gorm.DB, r *http.Request, clamp func(int) int) (*gorm.DB, error) {
+	params := r.URL.Query()
+	if params.Get("limit") == "" && params.Get("offset") == "" {
+		return query, nil
+	}
+
+	query = query.Order("id")
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("Invalid limit, expected a positive integer")
+		}
+		query = query.Limit(clamp(limit))
+	}
+	if raw := params.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("Invalid offset, expected a non-negative integer")
+		}
+		query = query.Offset(offset)
+	}
+	return query, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimitClampedOnlyWhenGiven(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("MAX_PAGE_LIMIT", "3")
+	for _, name := range []string{"Anna", "Boris", "Vera", "Gleb"} {
+		createTestPerson(t, server, `{"name":"`+name+`"}`)
+	}
+
+	for path, want := range map[string]int{
+		"/people":          4,
+		"/people?limit=2":  2,
+		"/people?limit=10": 3,
+	} {
+		resp, data := call(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		if len(people) != want {
+			t.Errorf("GET %s returned %d people, want %d", path, len(people), want)
+		}
+	}
+}
+
+func TestDeletedPeopleListedSeparately(t *testing.T) {
+	server, _ := newTestServer(t)
+	var deleted []uint
+	for i, name := range []string{"Anna", "Boris", "Vera", "Gleb"} {
+		person := createTestPerson(t, server, `{"name":"`+name+`"}`)
+		if i == 0 {
+			continue
+		}
+		time.Sleep(5 * time.Millisecond)
+		call(t, server, http.MethodDelete, fmt.Sprintf("/people/%d", person.ID), "")
+		deleted = append(deleted, person.ID)
+	}
+
+	list := func(path string) []Person {
+		t.Helper()
+		resp, data := call(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		return people
+	}
+
+	bin := list("/people/deleted")
+	if len(bin) != 3 || bin[0].ID != deleted[2] || bin[2].ID != deleted[0] {
+		t.Fatalf("GET /people/deleted = %+v, want the 3 deleted people, latest first", bin)
+	}
+	for _, person := range bin {
+		if person.DeletedAt == nil {
+			t.Errorf("Listed %+v, which isn't deleted", person)
+		}
+	}
+	if people := list("/people"); len(people) != 1 || people[0].Name != "Anna" {
+		t.Errorf("GET /people = %+v, want only the live person", people)
+	}
+
+	first, second := list("/people/deleted?limit=2"), list("/people/deleted?limit=2&offset=2")
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("Pages held %d and %d people, want 2 and 1", len(first), len(second))
+	}
+	seen := map[uint]bool{first[0].ID: true, first[1].ID: true, second[0].ID: true}
+	if len(seen) != 3 {
+		t.Errorf("Pages repeated people: %+v then %+v", first, second)
+	}
+	db.Unscoped().Model(&Person{}).Where("id = ?", deleted[1]).UpdateColumn("nationality", "KZ")
+	if people := list("/people/deleted?nationality=KZ"); len(people) != 1 || people[0].ID != deleted[1] {
+		t.Errorf("Filtered bin = %+v, want only Vera", people)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultEnrichConcurrency bounds concurrent enrichment workers when ENRICH_CONCURRENCY is unset
+const defaultEnrichConcurrency = 4
+
+// runBounded calls fn for every index in [0, n), with at most ENRICH_CONCURRENCY
+// calls in flight, and returns each call's error at its index.
+func runBounded(n int, fn func(i int) error) []error {
+	return runBoundedContext(context.Background(), n, fn)
+}
+
+// runBoundedContext is runBounded that stops launching calls once ctx is done,
+// leaving ctx's error at the indexes that were never called. Calls already in
+// flight run to completion.
+func runBoundedContext(ctx context.Context, n int, fn func(i int) error) []error {
+	limit := enrichConcurrencySetting.get()
+	if limit < 1 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// Checked again after acquiring, since select picks at random when both are ready
+		if ctx.Err() != nil {
+			for j := i; j < n; j++ {
+				errs[j] = ctx.Err()
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// countErrors returns how many of errs are non-nil
+func countErrors(errs []error) int {
+	n := 0
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyGauge records the most calls running at once between enter and leave
+type concurrencyGauge struct {
+	running, peak int64
+}
+
+func (g *concurrencyGauge) enter() {
+	n := atomic.AddInt64(&g.running, 1)
+	for {
+		peak := atomic.LoadInt64(&g.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&g.peak, peak, n) {
+			return
+		}
+	}
+}
+
+func (g *concurrencyGauge) leave() { atomic.AddInt64(&g.running, -1) }
+
+func TestRunBoundedLimitsConcurrency(t *testing.T) {
+	t.Setenv("ENRICH_CONCURRENCY", "3")
+
+	var gauge concurrencyGauge
+	errs := runBounded(20, func(i int) error {
+		gauge.enter()
+		defer gauge.leave()
+		time.Sleep(5 * time.Millisecond)
+		if i%4 == 0 {
+			return fmt.Errorf("item %d failed", i)
+		}
+		return nil
+	})
+
+	if gauge.peak != 3 {
+		t.Errorf("Peak concurrency = %d, want 3", gauge.peak)
+	}
+	if len(errs) != 20 || countErrors(errs) != 5 {
+		t.Fatalf("Got %d errors out of %d, want 5 of 20", countErrors(errs), len(errs))
+	}
+	for i, err := range errs {
+		if want := i%4 == 0; (err != nil) != want || (want && err.Error() != fmt.Sprintf("item %d failed", i)) {
+			t.Errorf("errs[%d] = %v", i, err)
+		}
+	}
+}
+
+func TestBatchCreateBoundsProviderCalls(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("ENRICH_CONCURRENCY", "2")
+
+	var gauge concurrencyGauge
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		gauge.enter()
+		defer gauge.leave()
+		time.Sleep(10 * time.Millisecond)
+		return http.StatusOK, `{"age":42}`
+	})
+
+	var items []string
+	for _, name := range []string{"Dmitriy", "Ivan", "Petr", "Oleg", "Sergey", "Anton"} {
+		items = append(items, fmt.Sprintf(`{"name":%q}`, name))
+	}
+	status, results := createBatch(t, server, "["+strings.Join(items, ",")+"]")
+	if status != http.StatusCreated || len(results) != len(items) {
+		t.Fatalf("Batch answered %d: %+v", status, results)
+	}
+	if gauge.peak > 2 {
+		t.Errorf("Agify saw %d concurrent calls, want at most 2", gauge.peak)
+	}
+}
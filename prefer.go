@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// prefersMinimal reports whether the client sent Prefer: return=minimal (RFC 7240),
+// asking a write to answer without a body. return=representation, the default,
+// keeps the full body.
+func prefersMinimal(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			// Preferences may carry parameters after a semicolon
+			token := strings.TrimSpace(strings.SplitN(pref, ";", 2)[0])
+			if strings.EqualFold(strings.ReplaceAll(token, " ", ""), "return=minimal") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// respondWritten answers a successful create (201) or update (200) of person with
+// body, or with no body when the client prefers a minimal return: 201 with just
+// the Location of the new person, or 204 for an update.
+func respondWritten(w http.ResponseWriter, r *http.Request, status int, person *Person, body interface{}) {
+	if status == http.StatusCreated {
+		w.Header().Set("Location", personPath(person))
+	}
+
+	if prefersMinimal(r) {
+		w.Header().Set("Preference-Applied", "return=minimal")
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+		return
+	}
+	respondJSON(w, status, body)
+}
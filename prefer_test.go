@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPreferReturn(t *testing.T) {
+	server, _ := newTestServer(t)
+	body := `{"name":"Dmitriy","surname":"Ushakov"}`
+
+	resp, data := call(t, server, http.MethodPost, "/people", body, "Prefer", "return=minimal")
+	if resp.StatusCode != http.StatusCreated || len(data) != 0 || resp.Header.Get("Preference-Applied") != "return=minimal" {
+		t.Fatalf("Minimal create answered %d with %q", resp.StatusCode, data)
+	}
+	location := resp.Header.Get("Location")
+	if resp, data := call(t, server, http.MethodGet, location, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET %s answered %d: %s", location, resp.StatusCode, data)
+	}
+
+	resp, data = call(t, server, http.MethodPut, location, `{"name":"Ivan"}`, "Prefer", "return=minimal; foo=bar")
+	if resp.StatusCode != http.StatusNoContent || len(data) != 0 {
+		t.Errorf("Minimal update answered %d with %q, want 204 and no body", resp.StatusCode, data)
+	}
+
+	resp, data = call(t, server, http.MethodPost, "/people", body, "Prefer", "return=representation")
+	var created Person
+	decode(t, data, &created)
+	if resp.StatusCode != http.StatusCreated || created.Name != "Dmitriy" || resp.Header.Get("Preference-Applied") != "" {
+		t.Errorf("Create preferring the representation answered %d: %s", resp.StatusCode, data)
+	}
+	if want := fmt.Sprintf("/people/%d", created.ID); resp.Header.Get("Location") != want {
+		t.Errorf("Location = %q, want %q", resp.Header.Get("Location"), want)
+	}
+
+	resp, data = call(t, server, http.MethodPut, fmt.Sprintf("/people/%d", created.ID), `{"name":"Ivan"}`, "Prefer", "return=representation")
+	var updated Person
+	decode(t, data, &updated)
+	if resp.StatusCode != http.StatusOK || updated.Name != "Ivan" {
+		t.Errorf("Update preferring the representation answered %d: %s", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// privacyMode reports whether PRIVACY_MODE is on. Names are personal data, so in
+// privacy mode they are hashed wherever they would be logged; the database still
+// stores them as given.
+func privacyMode() bool {
+	return privacyModeSetting.get()
+}
+
+// logName returns name as it should appear in logs
+func logName(name string) string {
+	if !privacyMode() {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// redactedError replaces the message of err, which quoted a name, while still
+// letting errors.Is see what it wraps
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e redactedError) Error() string { return e.msg }
+
+func (e redactedError) Unwrap() error { return e.err }
+
+// redactName replaces name, raw or URL-encoded, inside s when privacy mode is on
+func redactName(s, name string) string {
+	if !privacyMode() || name == "" {
+		return s
+	}
+	hashed := logName(name)
+	return strings.NewReplacer(url.QueryEscape(name), hashed, name, hashed).Replace(s)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// disabledProviders holds the providers skipped during enrichment, e.g. while a quota is exhausted.
+// It starts from DISABLED_PROVIDERS and can be changed at runtime through the admin endpoints.
+var disabledProviders = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+func loadDisabledProviders() {
+	for _, name := range disabledProvidersSetting.get() {
+		disabledProviders.names[name] = true
+	}
+}
+
+// errProviderDisabled explains a provider that was skipped because it is disabled
+var errProviderDisabled = errors.New("provider is disabled")
+
+// enabled reports whether the provider should be called during enrichment
+func (p provider) enabled() bool {
+	disabledProviders.RLock()
+	defer disabledProviders.RUnlock()
+	return !disabledProviders.names[p.Name]
+}
+
+func findProvider(name string) (provider, bool) {
+	for _, p := range enrichmentProviders {
+		if p.Name == strings.ToLower(name) {
+			return p, true
+		}
+	}
+	return provider{}, false
+}
+
+// setProviderEnabled handles POST /admin/providers/{name}/enable and /disable
+func setProviderEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			respondError(w, http.StatusUnauthorized, "Admin authorization required")
+			return
+		}
+
+		p, ok := findProvider(mux.Vars(r)["name"])
+		if !ok {
+			respondError(w, http.StatusNotFound, "Provider not found")
+			return
+		}
+
+		disabledProviders.Lock()
+		if enabled {
+			delete(disabledProviders.names, p.Name)
+		} else {
+			disabledProviders.names[p.Name] = true
+		}
+		disabledProviders.Unlock()
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{"provider": p.Name, "enabled": enabled})
+	}
+}
+
+// requiredProviders returns the providers listed in REQUIRED_PROVIDERS, whose
+// failure makes createPerson reject the person instead of storing it un-enriched
+func requiredProviders() []string {
+	return requiredProvidersSetting.get()
+}
+
+// requiredFailure returns the failure of the first required provider that failed in e, if any.
+// A required provider that is disabled counts as failed, since it was never asked.
+func (e enrichment) requiredFailure() error {
+	for _, name := range requiredProviders() {
+		if p, ok := findProvider(name); ok && !p.enabled() {
+			return newDomainError(errValidation, "Required provider %s is disabled", name)
+		}
+		if _, failed := e.Failures[name]; failed {
+			return newDomainError(errValidation, "Enrichment failed for required provider %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDisabledRequiredProviderRejectsCreate(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv("REQUIRED_PROVIDERS", genderizeProvider.Name)
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/providers/genderize/disable", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized disable answered %d: %s", resp.StatusCode, data)
+	}
+	if resp, data := adminCall(t, server, http.MethodPost, "/admin/providers/genderize/disable", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Disable answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy","surname":"Ushakov"}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Create without a required provider answered %d: %s", resp.StatusCode, data)
+	}
+	if n := stub.callCount(genderizeProvider.Name); n != 0 {
+		t.Errorf("Disabled genderize called %d times", n)
+	}
+}
+
+func TestCompareShowsCountryHintEffect(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answerQuery(agifyProvider.Name, func(query url.Values) (int, string) {
+		if query.Get("country_id") == "RU" {
+			return http.StatusOK, `{"age":38}`
+		}
+		return http.StatusOK, `{"age":45}`
+	})
+	stub.answerQuery(genderizeProvider.Name, func(query url.Values) (int, string) {
+		probability := 0.6
+		if query.Get("country_id") == "RU" {
+			probability = 0.95
+		}
+		return http.StatusOK, fmt.Sprintf(`{"gender":"male","probability":%v}`, probability)
+	})
+
+	resp, data := call(t, server, http.MethodGet, "/enrich/compare?name=Dmitriy&country=ru", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Compare answered %d: %s", resp.StatusCode, data)
+	}
+	var comparison EnrichComparison
+	decode(t, data, &comparison)
+	if comparison.Country != "RU" {
+		t.Errorf("Country = %q, want RU", comparison.Country)
+	}
+	if comparison.Age.WithoutCountry != 45.0 || comparison.Age.WithCountry != 38.0 {
+		t.Errorf("Age = %+v, want 45 without the hint and 38 with it", comparison.Age)
+	}
+	if comparison.GenderProbability.WithoutCountry != 0.6 || comparison.GenderProbability.WithCountry != 0.95 {
+		t.Errorf("Gender probability = %+v, want 0.6 without the hint and 0.95 with it", comparison.GenderProbability)
+	}
+
+	if resp, data := call(t, server, http.MethodGet, "/enrich/compare?name=Dmitriy&country=Russia", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid country answered %d: %s", resp.StatusCode, data)
+	}
+}
+
+func TestCompareSkipsDisabledProviders(t *testing.T) {
+	server, stub := newTestServer(t)
+	adminCall(t, server, http.MethodPost, "/admin/providers/genderize/disable", "")
+
+	resp, data := call(t, server, http.MethodGet, "/enrich/compare?name=Dmitriy&country=RU", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Compare answered %d: %s", resp.StatusCode, data)
+	}
+	var comparison EnrichComparison
+	decode(t, data, &comparison)
+	if comparison.GenderProbability.WithoutCountry != nil || comparison.GenderProbability.WithCountry != nil {
+		t.Errorf("Got gender probabilities %+v from a disabled provider", comparison.GenderProbability)
+	}
+	if comparison.Age.WithoutCountry != float64(42) {
+		t.Errorf("Age without country = %v, want 42", comparison.Age.WithoutCountry)
+	}
+	if n := stub.callCount(genderizeProvider.Name); n != 0 {
+		t.Errorf("Disabled genderize called %d times", n)
+	}
+}
+
+func TestFallbackProviderAnswersWhenPrimaryFails(t *testing.T) {
+	server, stub := newTestServer(t)
+	t.Setenv(genderizeProvider.FallbackEnvVar, "http://genderize-mirror.test")
+	stub.fail(genderizeProvider.Name)
+	stub.answer("genderize-mirror", func(name string) (int, string) {
+		return http.StatusOK, `{"gender":"female","probability":0.8}`
+	})
+
+	person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	if person.Gender != "female" || person.GenderProbability != 0.8 {
+		t.Errorf("Created %+v, want the mirror's answer", person)
+	}
+	if stub.callCount(genderizeProvider.Name) != 1 || stub.callCount("genderize-mirror") != 1 {
+		t.Errorf("Called genderize %d and the mirror %d times, want once each", stub.callCount(genderizeProvider.Name), stub.callCount("genderize-mirror"))
+	}
+
+	var failures int
+	db.Model(&EnrichmentFailure{}).Count(&failures)
+	if failures != 0 {
+		t.Errorf("%d enrichment failures recorded though the mirror answered", failures)
+	}
+}
+
+func TestDecodeProviderResponseRejectsBadShapes(t *testing.T) {
+	for body, want := range map[string]error{
+		`{"country":[{"country_id":"RU","probability":0.7}]}`: nil,
+		`<html>Bad gateway</html>`:                            errMalformedResponse,
+		`[{"country_id":"RU"}]`:                               errMalformedResponse,
+		`{"country":"RU"}`:                                    errMalformedResponse,
+		`{"error":"Invalid 'name' parameter"}`:                errProviderError,
+	} {
+		var result nationalizeResponse
+		if err := decodeProviderResponse([]byte(body), &result); !errors.Is(err, want) || (want == nil && err != nil) {
+			t.Errorf("Decoding %s returned %v, want %v", body, err, want)
+		}
+	}
+}
+
+func TestMalformedProviderResponsesRecorded(t *testing.T) {
+	server, stub := newTestServer(t)
+	stub.answer(agifyProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"error":"Invalid 'name' parameter"}`
+	})
+	stub.answer(nationalizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `not json`
+	})
+	malformed := func(name string) int64 {
+		if v, ok := providerMetrics.malformed.Get(name).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	agifyBefore, nationalizeBefore := malformed(agifyProvider.Name), malformed(nationalizeProvider.Name)
+
+	person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	if person.AgeEnrichedAt != nil || person.NationalityEnrichedAt != nil || person.Nationality != "" || person.Gender != "male" {
+		t.Errorf("Created %+v, want only the gender from the well-formed response", person)
+	}
+	if n := malformed(agifyProvider.Name) - agifyBefore; n != 1 {
+		t.Errorf("Agify malformed count rose by %d, want 1", n)
+	}
+	if n := malformed(nationalizeProvider.Name) - nationalizeBefore; n != 1 {
+		t.Errorf("Nationalize malformed count rose by %d, want 1", n)
+	}
+
+	var failure EnrichmentFailure
+	db.Where("person_id = ?", person.ID).First(&failure)
+	if !strings.Contains(failure.Reason, errProviderError.Error()) || !strings.Contains(failure.Reason, errMalformedResponse.Error()) {
+		t.Errorf("Recorded failure %q, want the error shape and the malformed body told apart", failure.Reason)
+	}
+}
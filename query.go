@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// defaultQueryLimit is the page size of an admin query that doesn't set Limit
+const defaultQueryLimit = 50
+
+// queryableColumns maps the fields an admin query may filter on to their columns
+var queryableColumns = map[string]string{
+	"id":                 "id",
+	"name":               "name",
+	"surname":            "surname",
+	"patronymic":         "patronymic",
+	"age":                "age",
+	"gender":             "gender",
+	"genderprobability":  "gender_probability",
+	"gender_probability": "gender_probability",
+	"nationality":        "nationality",
+	"createdat":          "created_at",
+	"created_at":         "created_at",
+	"updatedat":          "updated_at",
+	"updated_at":         "updated_at",
+}
+
+// textColumns are the queryable columns holding text, the only ones like can match
+var textColumns = map[string]bool{"name": true, "surname": true, "patronymic": true, "gender": true, "nationality": true}
+
+// queryOperators maps the allowed operators to their SQL form
+var queryOperators = map[string]string{
+	"eq":   "= ?",
+	"ne":   "<> ?",
+	"lt":   "< ?",
+	"lte":  "<= ?",
+	"gt":   "> ?",
+	"gte":  ">= ?",
+	"like": "ILIKE ?",
+	"in":   "IN (?)",
+}
+
+// QueryFilter is one condition of an admin people query
+type QueryFilter struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// PeopleQuery is the body of POST /admin/people/query; filters are combined with AND
+type PeopleQuery struct {
+	Filters []QueryFilter
+	Limit   int
+	Offset  int
+}
+
+// PeopleQueryResult is one page of an admin people query
+type PeopleQueryResult struct {
+	People []Person
+	Total  int
+	Limit  int
+	Offset int
+}
+
+// queryPeople runs a validated, parameterized filter built from the request body
+func queryPeople(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusUnauthorized, "Admin authorization required")
+		return
+	}
+
+	var q PeopleQuery
+	if !decodeJSONBody(w, r, &q) {
+		return
+	}
+	if err := checkLikeFilters(q.Filters); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := buildPeopleQuery(conn.Model(&Person{}), q.Filters)
+	if err != nil {
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	}
+	q.Limit = clampLimit(q.Limit)
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	result := PeopleQueryResult{People: []Person{}, Limit: q.Limit, Offset: q.Offset}
+	if err := query.Count(&result.Total).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+	if err := query.Order("id").Limit(q.Limit).Offset(q.Offset).Find(&result.People).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// checkLikeFilters rejects like on a column that doesn't hold text, where ILIKE
+// would fail in the database rather than match
+func checkLikeFilters(filters []QueryFilter) error {
+	for _, f := range filters {
+		column, ok := queryableColumns[strings.ToLower(f.Field)]
+		if ok && strings.ToLower(f.Operator) == "like" && !textColumns[column] {
+			return fmt.Errorf("Operator like needs a text field, %s is not one", f.Field)
+		}
+	}
+	return nil
+}
+
+// buildPeopleQuery adds each filter to query, rejecting fields and operators outside the allowlists
+func buildPeopleQuery(query *gorm.DB, filters []QueryFilter) (*gorm.DB, error) {
+	for _, f := range filters {
+		column, ok := queryableColumns[strings.ToLower(f.Field)]
+		if !ok {
+			return nil, fmt.Errorf("Field %q cannot be queried", f.Field)
+		}
+		op, ok := queryOperators[strings.ToLower(f.Operator)]
+		if !ok {
+			return nil, fmt.Errorf("Operator %q is not allowed", f.Operator)
+		}
+
+		value := f.Value
+		switch strings.ToLower(f.Operator) {
+		case "in":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, fmt.Errorf("Operator in needs a non-empty list for field %s", f.Field)
+			}
+		case "like":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Operator like needs a string for field %s", f.Field)
+			}
+			value = "%" + escapeLike(s) + "%"
+		default:
+			switch value.(type) {
+			case string, float64, bool:
+			default:
+				return nil, fmt.Errorf("Invalid value for field %s", f.Field)
+			}
+		}
+
+		query = query.Where(column+" "+op, value)
+	}
+	return query, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestQueryPeople(t *testing.T) {
+	server, _ := newTestServer(t)
+	createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+	body := `{"Filters":[{"Field":"surname","Operator":"like","Value":"shak"}]}`
+
+	if resp, data := call(t, server, http.MethodPost, "/admin/people/query", body); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized query answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/people/query", `{"Filters":[{"Field":"surname","Operator":"eq","Value":"Ushakov"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Query answered %d: %s", resp.StatusCode, data)
+	}
+	var result PeopleQueryResult
+	decode(t, data, &result)
+	if result.Total != 1 || len(result.People) != 1 || result.People[0].Surname != "Ushakov" {
+		t.Errorf("Got %+v, want only Ushakov", result)
+	}
+}
+
+func TestQueryPeopleRejectsLikeOnNumbers(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, data := adminCall(t, server, http.MethodPost, "/admin/people/query", `{"Filters":[{"Field":"age","Operator":"like","Value":"4"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("like on age answered %d: %s", resp.StatusCode, data)
+	}
+}
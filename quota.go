@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProviderQuota is the rate-limit state a provider reported in its response headers
+type ProviderQuota struct {
+	Limit        *int
+	Remaining    *int
+	ResetSeconds *int
+	ObservedAt   time.Time
+}
+
+// providerQuotas keeps the most recent quota seen for each provider
+var providerQuotas = struct {
+	sync.Mutex
+	byProvider map[string]ProviderQuota
+}{byProvider: map[string]ProviderQuota{}}
+
+// parseQuota reads the X-Rate-Limit-* headers sent by Agify, Genderize and Nationalize
+func parseQuota(header http.Header) ProviderQuota {
+	quota := ProviderQuota{ObservedAt: time.Now()}
+	quota.Limit = headerInt(header, "X-Rate-Limit-Limit")
+	quota.Remaining = headerInt(header, "X-Rate-Limit-Remaining")
+	quota.ResetSeconds = headerInt(header, "X-Rate-Limit-Reset")
+	return quota
+}
+
+func headerInt(header http.Header, key string) *int {
+	n, err := strconv.Atoi(header.Get(key))
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// recordQuota remembers the quota from a provider response that carried rate-limit headers
+func recordQuota(provider string, header http.Header) {
+	quota := parseQuota(header)
+	if quota.Remaining == nil {
+		return
+	}
+
+	providerQuotas.Lock()
+	providerQuotas.byProvider[provider] = quota
+	providerQuotas.Unlock()
+}
+
+// quotaSnapshot copies the latest known quota of every provider
+func quotaSnapshot() map[string]ProviderQuota {
+	providerQuotas.Lock()
+	defer providerQuotas.Unlock()
+
+	snapshot := make(map[string]ProviderQuota, len(providerQuotas.byProvider))
+	for provider, quota := range providerQuotas.byProvider {
+		snapshot[provider] = quota
+	}
+	return snapshot
+}
+
+// errQuotaExhausted marks a provider call refused with 429 Too Many Requests
+var errQuotaExhausted = errors.New("provider quota exhausted")
+
+// quotaRetryError rejects a create whose required providers are out of quota until retryAfter passes
+type quotaRetryError struct {
+	retryAfter time.Duration
+}
+
+func (e quotaRetryError) Error() string { return "Enrichment quota exhausted, retry later" }
+
+// seconds is retryAfter in whole seconds, rounded up for Retry-After
+func (e quotaRetryError) seconds() int {
+	return int(math.Ceil(e.retryAfter.Seconds()))
+}
+
+// defaultQuotaRetryAfter is suggested when a provider ran out of quota without saying when it resets
+const defaultQuotaRetryAfter = time.Minute
+
+// requiredQuotaRetryAfter reports whether every required provider that failed in e
+// did so because its quota is exhausted, and if so how long until the last of
+// them resets, going by the reset headers they last sent
+func (e enrichment) requiredQuotaRetryAfter() (time.Duration, bool) {
+	quotas := quotaSnapshot()
+	var wait time.Duration
+	exhausted := false
+	for _, name := range requiredProviders() {
+		if p, ok := findProvider(name); ok && !p.enabled() {
+			// Waiting won't bring back a disabled provider
+			return 0, false
+		}
+		err, failed := e.Failures[name]
+		if !failed {
+			continue
+		}
+		if !errors.Is(err, errQuotaExhausted) {
+			return 0, false
+		}
+		exhausted = true
+
+		providerWait := defaultQuotaRetryAfter
+		if quota, ok := quotas[name]; ok && quota.ResetSeconds != nil {
+			providerWait = time.Duration(*quota.ResetSeconds)*time.Second - time.Since(quota.ObservedAt)
+		}
+		if providerWait > wait {
+			wait = providerWait
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait, exhausted
+}
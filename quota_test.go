@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExhaustedQuotaAnswersRetryAfter(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	t.Setenv("REQUIRED_PROVIDERS", "agify,genderize")
+	limited := func(string) (int, string) {
+		return http.StatusTooManyRequests, `{"error":"Request limit reached"}`
+	}
+	stub.answer(agifyProvider.Name, limited)
+	stub.answer(genderizeProvider.Name, limited)
+	stub.setHeaders(agifyProvider.Name, http.Header{"X-Rate-Limit-Remaining": {"0"}, "X-Rate-Limit-Reset": {"120"}})
+	stub.setHeaders(genderizeProvider.Name, http.Header{"X-Rate-Limit-Remaining": {"0"}, "X-Rate-Limit-Reset": {"300"}})
+
+	// The client waits for the later of the two resets
+	resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "300" {
+		t.Errorf("Create out of quota answered %d with Retry-After %q: %s, want 503 and 300", resp.StatusCode, resp.Header.Get("Retry-After"), data)
+	}
+
+	// Without a reset header the default wait is suggested
+	stub.setHeaders(genderizeProvider.Name, nil)
+	stub.setHeaders(agifyProvider.Name, nil)
+	providerQuotas.Lock()
+	providerQuotas.byProvider = map[string]ProviderQuota{}
+	providerQuotas.Unlock()
+	resp, _ = call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("Create out of quota without reset answered %d with Retry-After %q, want 503 and 60", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// A required provider failing for another reason is not worth retrying later
+	stub.fail(genderizeProvider.Name)
+	resp, data = call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy"}`)
+	if resp.StatusCode == http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "" {
+		t.Errorf("Create with a failed provider answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), data)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// defaultRandomMaxCount caps ?count= on /people/random when RANDOM_MAX_COUNT is unset
+const defaultRandomMaxCount = 50
+
+// getRandomPeople returns up to ?count= people (default 1) picked at random
+func getRandomPeople(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if raw := r.URL.Query().Get("count"); raw != "" {
+		var err error
+		count, err = strconv.Atoi(raw)
+		if err != nil || count <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid count, expected a positive integer")
+			return
+		}
+	}
+
+	if max := randomMaxCountSetting.get(); count > max {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d random people can be requested", max))
+		return
+	}
+
+	conn, done := readDB(r)
+	defer done()
+
+	people := []Person{}
+	if err := conn.Order("RANDOM()").Limit(count).Find(&people).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, people)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRandomPeople(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("RANDOM_MAX_COUNT", "10")
+	storePeople(t, 30)
+
+	// Five draws of 5 out of 30 coming back identical would mean the order isn't random
+	orders := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		resp, data := call(t, server, http.MethodGet, "/people/random?count=5", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /people/random answered %d: %s", resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		if len(people) != 5 {
+			t.Fatalf("Got %d random people, want 5", len(people))
+		}
+		var ids []uint
+		for _, person := range people {
+			ids = append(ids, person.ID)
+		}
+		orders[fmt.Sprint(ids)] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("Every draw returned %v", orders)
+	}
+
+	for query, want := range map[string]int{
+		"":          http.StatusOK,
+		"count=10":  http.StatusOK,
+		"count=11":  http.StatusBadRequest,
+		"count=0":   http.StatusBadRequest,
+		"count=two": http.StatusBadRequest,
+	} {
+		if resp, data := call(t, server, http.MethodGet, "/people/random?"+query, ""); resp.StatusCode != want {
+			t.Errorf("GET /people/random?%s answered %d, want %d: %s", query, resp.StatusCode, want, data)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Reconciliation compares a stored person with what the providers answer now
+type Reconciliation struct {
+	PersonID       uint
+	ManualOverride bool
+	Changes        map[string]fieldChange
+	Errors         map[string]string `json:",omitempty"`
+}
+
+// reconcilePerson re-runs enrichment for a person and reports which stored values
+// would change, without saving anything, so an operator can review before
+// applying them with POST to the same path
+func reconcilePerson(w http.ResponseWriter, r *http.Request) {
+	person, ok := loadPersonForReconcile(w, r)
+	if !ok {
+		return
+	}
+
+	fresh := person
+	e := getEnrichedData(r.Context(), inputOf(&person))
+	applyEnrichment(&fresh, e)
+
+	reconciliation := Reconciliation{
+		PersonID:       person.ID,
+		ManualOverride: person.ManualOverride,
+		Changes:        diffPeople(&person, &fresh),
+	}
+	for provider, err := range e.Failures {
+		if reconciliation.Errors == nil {
+			reconciliation.Errors = map[string]string{}
+		}
+		reconciliation.Errors[provider] = err.Error()
+	}
+
+	respondJSON(w, http.StatusOK, reconciliation)
+}
+
+// applyReconciliation stores the freshly enriched values of a person. Manually
+// overridden people are refused unless ?force=true, which also hands them back
+// to enrichment.
+func applyReconciliation(w http.ResponseWriter, r *http.Request) {
+	person, ok := loadPersonForReconcile(w, r)
+	if !ok {
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if person.ManualOverride && !force {
+		respondError(w, http.StatusConflict, "Person has a manual override, use force=true to replace it")
+		return
+	}
+
+	before := person
+	person.ManualOverride = false
+	if err := enrichPersonData(r.Context(), &person); err != nil {
+		respondServiceError(w, err, "Failed to update person")
+		return
+	}
+
+	if err := savePersonWithHistory(r, &before, &person); err != nil {
+		respondDBError(w, err, "Failed to update person")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, person)
+}
+
+func loadPersonForReconcile(w http.ResponseWriter, r *http.Request) (Person, bool) {
+	personID := personIDFrom(r)
+
+	person, err := findPerson(db, personID)
+	if err != nil {
+		respondServiceError(w, err, "Failed to load person")
+		return person, false
+	}
+	return person, true
+}
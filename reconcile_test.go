@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestReconcileDiffsThenApplies(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d/reconcile", person.ID)
+
+	stub.answer(agifyProvider.Name, func(string) (int, string) { return http.StatusOK, `{"age":50}` })
+	stub.answer(genderizeProvider.Name, func(string) (int, string) {
+		return http.StatusOK, `{"gender":"female","probability":0.8}`
+	})
+
+	resp, data := call(t, server, http.MethodGet, path, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Reconcile answered %d: %s", resp.StatusCode, data)
+	}
+	var reconciliation Reconciliation
+	decode(t, data, &reconciliation)
+	changes := reconciliation.Changes
+	if len(changes) != 3 {
+		t.Errorf("Changes = %v, want age, gender and its probability", changes)
+	}
+	if changes["Age"].From != 42.0 || changes["Age"].To != 50.0 {
+		t.Errorf("Age change = %+v, want 42 to 50", changes["Age"])
+	}
+	if changes["Gender"].From != "male" || changes["Gender"].To != "female" || changes["GenderProbability"].To != 0.8 {
+		t.Errorf("Gender changes = %+v and %+v, want male to female at 0.8", changes["Gender"], changes["GenderProbability"])
+	}
+	if _, ok := changes["Nationality"]; ok {
+		t.Errorf("Reported an unchanged nationality: %+v", changes["Nationality"])
+	}
+
+	var stored Person
+	db.First(&stored, person.ID)
+	if *stored.Age != 42 || stored.Gender != "male" {
+		t.Errorf("Reconcile stored %+v, want nothing persisted", stored)
+	}
+
+	if resp, data := call(t, server, http.MethodPost, path, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Apply answered %d: %s", resp.StatusCode, data)
+	}
+	db.First(&stored, person.ID)
+	if *stored.Age != 50 || stored.Gender != "female" || stored.GenderProbability != 0.8 {
+		t.Errorf("Applied %+v, want the fresh values", stored)
+	}
+	if history := historyOf(t, server, person.ID); len(history) != 1 {
+		t.Errorf("Apply left %d history entries, want 1", len(history))
+	}
+
+	call(t, server, http.MethodPatch, fmt.Sprintf("/people/%d/override", person.ID), `{"Age":30}`)
+	if resp, _ := call(t, server, http.MethodPost, path, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Apply over an override answered %d, want 409", resp.StatusCode)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// Region schemes for LikelyRegion, chosen with REGION_SCHEME
+const (
+	regionSchemeContinent = "continent"
+	regionSchemeEU        = "eu"
+)
+
+// regionUnknown is the LikelyRegion of a nationality missing from the tables
+const regionUnknown = "Unknown"
+
+// continentCountries lists the alpha-2 codes of each continent. Transcontinental
+// countries are placed where they are usually grouped: Russia in Europe, Turkey,
+// Georgia, Armenia and Azerbaijan in Asia, Cyprus with the rest of the EU in Europe.
+var continentCountries = map[string]string{
+	"Africa": "DZ AO BJ BW BF BI CV CM CF TD KM CG CD CI DJ EG GQ ER SZ ET GA GM GH GN GW KE LS LR LY MG " +
+		"MW ML MR MU YT MA MZ NA NE NG RE RW SH ST SN SC SL SO ZA SS SD TZ TG TN UG EH ZM ZW",
+	"Antarctica": "AQ BV GS HM TF",
+	"Asia": "AF AM AZ BH BD BT BN KH CN CX CC GE HK IN ID IR IQ IL JP JO KZ KW KG LA LB MO MY MV MN MM " +
+		"NP KP OM PK PS PH QA SA SG KR LK SY TW TJ TH TL TR TM AE UZ VN YE IO",
+	"Europe": "AX AL AD AT BY BE BA BG HR CY CZ DK EE FO FI FR DE GI GR GG VA HU IS IE IM IT JE LV LI LT " +
+		"LU MT MD MC ME NL MK NO PL PT RO RU SM RS SK SI ES SJ SE CH UA GB XK",
+	"North America": "AI AG AW BS BB BZ BM BQ VG CA KY CR CU CW DM DO SV GL GD GP GT HT HN JM MQ MX MS NI " +
+		"PA PR BL KN LC MF PM VC SX TT TC US VI",
+	"Oceania":       "AS AU CK FJ PF GU KI MH FM NR NC NZ NU NF MP PW PG PN WS SB TK TO TV VU WF UM",
+	"South America": "AR BO BR CL CO EC FK GF GY PY PE SR UY VE",
+}
+
+// euMembers are the alpha-2 codes of the European Union member states
+var euMembers = "AT BE BG HR CY CZ DK EE FI FR DE GR HU IE IT LV LT LU MT NL PL PT RO SK SI ES SE"
+
+var (
+	continentOf = func() map[string]string {
+		m := map[string]string{}
+		for continent, codes := range continentCountries {
+			for _, code := range strings.Fields(codes) {
+				m[code] = continent
+			}
+		}
+		return m
+	}()
+	inEU = func() map[string]bool {
+		m := map[string]bool{}
+		for _, code := range strings.Fields(euMembers) {
+			m[code] = true
+		}
+		return m
+	}()
+)
+
+// likelyRegion maps a stored alpha-2 or alpha-3 nationality to a coarser region:
+// its continent, or with REGION_SCHEME=eu either "EU" or "Non-EU". Unknown codes
+// give regionUnknown and an empty nationality gives no region.
+func likelyRegion(nationality string) string {
+	if nationality == "" {
+		return ""
+	}
+	code, ok := convertCountryCode(nationality, nationalityAlpha2)
+	if !ok {
+		return regionUnknown
+	}
+
+	if regionSchemeSetting.get() == regionSchemeEU {
+		if inEU[code] {
+			return "EU"
+		}
+		return "Non-EU"
+	}
+	if continent, ok := continentOf[code]; ok {
+		return continent
+	}
+	return regionUnknown
+}
+
+// AfterFind completes a loaded person with what isn't stored as it is shown:
+// the unknown age representation and LikelyRegion
+func (p *Person) AfterFind() error {
+	p.reportAge()
+	p.LikelyRegion = likelyRegion(p.Nationality)
+	return nil
+}
+
+// AfterSave keeps LikelyRegion in step with a nationality just written
+func (p *Person) AfterSave() error {
+	p.LikelyRegion = likelyRegion(p.Nationality)
+	return nil
+}
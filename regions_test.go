@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLikelyRegionMapping(t *testing.T) {
+	for scheme, want := range map[string]map[string]string{
+		"": {
+			"RU": "Europe", "DE": "Europe", "DEU": "Europe", "KZ": "Asia", "TR": "Asia", "NG": "Africa",
+			"US": "North America", "BR": "South America", "AU": "Oceania", "ZZ": regionUnknown, "": "",
+		},
+		regionSchemeEU: {
+			"DE": "EU", "DEU": "EU", "CY": "EU", "RU": "Non-EU", "NO": "Non-EU", "US": "Non-EU", "ZZ": regionUnknown, "": "",
+		},
+	} {
+		t.Setenv("REGION_SCHEME", scheme)
+		for nationality, region := range want {
+			if got := likelyRegion(nationality); got != region {
+				t.Errorf("REGION_SCHEME=%q: region of %q = %q, want %q", scheme, nationality, got, region)
+			}
+		}
+	}
+
+	// A country listed under two continents would land in whichever the map ranged over last
+	listed := 0
+	for _, codes := range continentCountries {
+		listed += len(strings.Fields(codes))
+	}
+	if listed != len(continentOf) {
+		t.Errorf("%d codes listed across continents but %d distinct, want each listed once", listed, len(continentOf))
+	}
+}
+
+func TestLikelyRegionComputedOnLoad(t *testing.T) {
+	server, _ := newTestServer(t)
+	createTestPerson(t, server, `{"name":"Dmitriy"}`)
+
+	// Switching the scheme is seen at once, since the region is never stored
+	t.Setenv("REGION_SCHEME", regionSchemeEU)
+	_, data := call(t, server, http.MethodGet, "/people", "")
+	if !strings.Contains(string(data), `"LikelyRegion":"Non-EU"`) {
+		t.Errorf("GET /people answered %s, want the EU scheme's region", data)
+	}
+	if db.Dialect().HasColumn("people", "likely_region") {
+		t.Error("LikelyRegion is stored as a column")
+	}
+}
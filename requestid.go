@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+type batchItemKey struct{}
+
+// requestIDHeaderName is the header carrying the request ID in, out and upstream,
+// configurable through REQUEST_ID_HEADER
+func requestIDHeaderName() string {
+	return requestIDHeaderSetting.get()
+}
+
+// withRequestID reuses the client's request ID or generates one, storing it
+// in the request context and echoing it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := requestIDHeaderName()
+		id := r.Header.Get(header)
+		if id == "" {
+			id = newRequestID()
+		}
+
+		w.Header().Set(header, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned to r by withRequestID
+func requestID(r *http.Request) string {
+	return requestIDFromContext(r.Context())
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withBatchItem marks ctx as handling item index of a batch, so its log lines
+// can be told apart from the other items of the same request
+func withBatchItem(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, batchItemKey{}, index)
+}
+
+// logPrefix tags a log line with the request ID carried by ctx, followed by the
+// batch item index when there is one: "[<id>]" or "[<id>/<index>]"
+func logPrefix(ctx context.Context) string {
+	if index, ok := ctx.Value(batchItemKey{}).(int); ok {
+		return fmt.Sprintf("[%s/%d]", requestIDFromContext(ctx), index)
+	}
+	return fmt.Sprintf("[%s]", requestIDFromContext(ctx))
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
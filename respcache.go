@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// defaultResponseCacheTTL is how long cacheable GET responses stay fresh when RESPONSE_CACHE_TTL is unset
+const defaultResponseCacheTTL = 30 * time.Second
+
+// responseCacheMaxEntries bounds the server-side response cache; once full, new
+// responses are only kept after expired ones make room
+const responseCacheMaxEntries = 1000
+
+// responseCache holds whole responses of the routes wrapped with cacheGET while
+// RESPONSE_CACHE=true. Entries are keyed by responseCacheGeneration, so cached
+// statistics don't outlive a write, see invalidateResponseCacheOnWrite.
+var responseCache = struct {
+	sync.Mutex
+	entries map[string]cachedResponse
+}{entries: map[string]cachedResponse{}}
+
+// responseCacheGeneration is part of every response cache key. It moves on once a
+// write has committed, which retires every response cached before it at once.
+var responseCacheGeneration uint64
+
+type cachedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+	generation  uint64
+}
+
+// responseRecorder keeps a copy of the response body while passing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// cacheGET marks the responses of an idempotent GET, such as a statistics
+// endpoint, as cacheable for RESPONSE_CACHE_TTL (default 30s; 0 disables) with
+// Cache-Control and Expires. With RESPONSE_CACHE=true successful responses are
+// also kept server-side and answered from memory until the TTL passes or the
+// database is written. Responses vary with the URL and the negotiated format
+// and language, so those make up the cache key.
+func cacheGET(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl := responseCacheTTLSetting.get()
+		if ttl <= 0 {
+			h(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl.Seconds())))
+		if !responseCacheSetting.get() {
+			w.Header().Set("Expires", time.Now().Add(ttl).UTC().Format(http.TimeFormat))
+			h(w, r)
+			return
+		}
+
+		// The generation is read before the handler, so a response computed while a
+		// write commits is cached under the generation that write retires
+		generation := atomic.LoadUint64(&responseCacheGeneration)
+		key := strconv.FormatUint(generation, 10) + "\x00" + r.URL.RequestURI() + "\x00" + r.Header.Get("Accept") + "\x00" + r.Header.Get("Accept-Language")
+		responseCache.Lock()
+		cached, ok := responseCache.entries[key]
+		responseCache.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			w.Header().Set("Expires", cached.expires.UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Type", cached.contentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(cached.body)))
+			w.Header().Set("X-Cache", "HIT")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		expires := time.Now().Add(ttl)
+		w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+		w.Header().Set("X-Cache", "MISS")
+
+		// The recorder goes under the jsonWriter, which respondJSON needs to see
+		recorder := &responseRecorder{status: http.StatusOK}
+		if jw, ok := w.(*jsonWriter); ok {
+			wrapped := *jw
+			recorder.ResponseWriter = jw.ResponseWriter
+			wrapped.ResponseWriter = recorder
+			h(&wrapped, r)
+		} else {
+			recorder.ResponseWriter = w
+			h(recorder, r)
+		}
+
+		if recorder.status == http.StatusOK {
+			storeResponse(key, cachedResponse{
+				status:      recorder.status,
+				contentType: w.Header().Get("Content-Type"),
+				body:        recorder.body.Bytes(),
+				expires:     expires,
+				generation:  generation,
+			})
+		}
+	}
+}
+
+func storeResponse(key string, response cachedResponse) {
+	responseCache.Lock()
+	defer responseCache.Unlock()
+
+	if len(responseCache.entries) >= responseCacheMaxEntries {
+		now, current := time.Now(), atomic.LoadUint64(&responseCacheGeneration)
+		for k, entry := range responseCache.entries {
+			if now.After(entry.expires) || entry.generation != current {
+				delete(responseCache.entries, k)
+			}
+		}
+		if len(responseCache.entries) >= responseCacheMaxEntries {
+			return
+		}
+	}
+	responseCache.entries[key] = response
+}
+
+// flushResponseCache drops every cached response
+func flushResponseCache() {
+	responseCache.Lock()
+	responseCache.entries = map[string]cachedResponse{}
+	responseCache.Unlock()
+}
+
+// retireCachedResponses moves the response cache to a new generation, so nothing
+// cached so far is answered again
+func retireCachedResponses() {
+	atomic.AddUint64(&responseCacheGeneration, 1)
+}
+
+// invalidateResponseCacheOnWrite retires the cached responses after every create,
+// update and delete made through conn, whichever handler or job made it, once the
+// write has committed. A write inside an outer transaction hasn't committed by
+// then; writeTransaction retires the cache again after that transaction ends.
+func invalidateResponseCacheOnWrite(conn *gorm.DB) {
+	retire := func(scope *gorm.Scope) {
+		if _, inTransaction := scope.SQLDB().(*sql.Tx); !inTransaction {
+			retireCachedResponses()
+		}
+	}
+	conn.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("response_cache:retire", retire)
+	conn.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("response_cache:retire", retire)
+	conn.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("response_cache:retire", retire)
+}
+
+// writeTransaction runs fn in a transaction on db, like db.Transaction, and
+// retires the cached responses once it has committed or rolled back. The
+// transaction is bound to ctx, so a write whose client goes away is rolled back
+// instead of running to completion.
+func writeTransaction(ctx context.Context, fn func(tx *gorm.DB) error) (err error) {
+	defer retireCachedResponses()
+
+	tx := db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = fn(tx)
+	if err == nil {
+		err = tx.Commit().Error
+	}
+	panicked = false
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestStatsRoutesSendCacheHeaders(t *testing.T) {
+	server, _ := newTestServer(t)
+	createTestPerson(t, server, `{"name":"Dmitriy"}`)
+
+	for _, path := range []string{"/people/stats", "/people/stats/age-histogram", "/people/nationalities"} {
+		resp, data := call(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != "max-age=30" {
+			t.Errorf("GET %s Cache-Control = %q, want max-age=30", path, got)
+		}
+		if resp.Header.Get("Expires") == "" {
+			t.Errorf("GET %s sent no Expires", path)
+		}
+	}
+}
+
+func TestWriteRetiresCachedStats(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("RESPONSE_CACHE", "true")
+	first := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+
+	stats := func(wantCache string) PeopleStats {
+		t.Helper()
+		resp, data := call(t, server, http.MethodGet, "/people/stats", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /people/stats answered %d: %s", resp.StatusCode, data)
+		}
+		if got := resp.Header.Get("X-Cache"); got != wantCache {
+			t.Errorf("X-Cache = %q, want %s", got, wantCache)
+		}
+		var stats PeopleStats
+		decode(t, data, &stats)
+		return stats
+	}
+
+	if got := stats("MISS"); got.Total != 1 || got.ByGender["male"] != 1 || got.AverageAge == nil || *got.AverageAge != 42 {
+		t.Errorf("Stats = %+v, want one 42-year-old man", got)
+	}
+	stats("HIT")
+
+	createTestPerson(t, server, `{"name":"Ivan"}`)
+	if got := stats("MISS"); got.Total != 2 {
+		t.Errorf("Stats after a create counted %d people, want 2", got.Total)
+	}
+
+	// Deleting goes through an outer transaction with its history entry
+	resp, data := call(t, server, http.MethodDelete, fmt.Sprintf("/people/%d", first.ID), "")
+	if resp.StatusCode >= 300 {
+		t.Fatalf("DELETE answered %d: %s", resp.StatusCode, data)
+	}
+	if got := stats("MISS"); got.Total != 1 {
+		t.Errorf("Stats after a delete counted %d people, want 1", got.Total)
+	}
+}
+
+func TestCachedResponsesRetiredAfterOuterCommit(t *testing.T) {
+	newTestServer(t)
+
+	before := atomic.LoadUint64(&responseCacheGeneration)
+	err := writeTransaction(context.Background(), func(tx *gorm.DB) error {
+		if err := tx.Create(&Person{Name: "Dmitriy"}).Error; err != nil {
+			return err
+		}
+		if atomic.LoadUint64(&responseCacheGeneration) != before {
+			t.Error("Cached responses retired before the transaction committed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if atomic.LoadUint64(&responseCacheGeneration) == before {
+		t.Error("Cached responses not retired after the transaction committed")
+	}
+}
+
+func TestNationalityCounts(t *testing.T) {
+	server, stub := newTestServer(t)
+	createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	createTestPerson(t, server, `{"name":"Ivan"}`)
+	stub.answer(nationalizeProvider.Name, func(name string) (int, string) {
+		return http.StatusOK, `{"country":[{"country_id":"KZ","probability":0.6}]}`
+	})
+	createTestPerson(t, server, `{"name":"Nursultan"}`)
+
+	resp, data := call(t, server, http.MethodGet, "/people/nationalities", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /people/nationalities answered %d: %s", resp.StatusCode, data)
+	}
+	var counts []NationalityCount
+	decode(t, data, &counts)
+	if len(counts) != 2 || counts[0] != (NationalityCount{"RU", 2}) || counts[1] != (NationalityCount{"KZ", 1}) {
+		t.Errorf("Counts = %+v, want RU 2 then KZ 1", counts)
+	}
+}
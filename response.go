@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// jsonWriter wraps the ResponseWriter handed to handlers so that the
+// request's output preferences reach respondJSON.
+type jsonWriter struct {
+	http.ResponseWriter
+	pretty     bool
+	lang       string
+	serializer serializer
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (w *jsonWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// responseOptions reads ?pretty=true so debugging clients get indented JSON, and
+// negotiates the output format and the language error messages are returned in.
+func responseOptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		next.ServeHTTP(&jsonWriter{
+			ResponseWriter: w,
+			pretty:         pretty,
+			lang:           negotiateLanguage(r),
+			serializer:     negotiateSerializer(r),
+		}, r)
+	})
+}
+
+// respondJSON writes data in the negotiated format: JSON unless the client asked for MessagePack
+func respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	var s serializer = jsonSerializer{}
+	pretty := false
+	if jw, ok := w.(*jsonWriter); ok {
+		s, pretty = jw.serializer, jw.pretty
+	}
+
+	// Encode into a buffer first so a marshaling failure can still be reported as a 500
+	var buf bytes.Buffer
+	if err := s.Encode(&buf, data, pretty); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		status = http.StatusInternalServerError
+		buf.Reset()
+		s.Encode(&buf, map[string]string{"error": translate(writerLanguage(w), "Internal server error")}, false)
+	}
+
+	w.Header().Set("Content-Type", s.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func respondError(w http.ResponseWriter, code int, message string) {
+	respondJSON(w, code, map[string]string{"error": translate(writerLanguage(w), message)})
+}
+
+// writerLanguage returns the language negotiated for the response being written
+func writerLanguage(w http.ResponseWriter) string {
+	if jw, ok := w.(*jsonWriter); ok {
+		return jw.lang
+	}
+	return defaultLanguage
+}
+
+// decodeJSONBody decodes the request body into v. Malformed JSON is answered with
+// 400 and well-formed JSON of the wrong shape with 422; it reports whether v was decoded.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Invalid value for field %s", typeErr.Field))
+	} else {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+	}
+	return false
+}
+
+// respondDBError answers a failed write: 409 when it violated a uniqueness constraint, otherwise 500 with message
+func respondDBError(w http.ResponseWriter, err error, message string) {
+	respondServiceError(w, dbError(err), message)
+}
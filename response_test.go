@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestRespondJSONReportsEncodingFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	// JSON has no infinity, so encoding fails before anything is written
+	respondJSON(w, http.StatusOK, map[string]float64{"probability": math.Inf(1)})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Unencodable response answered %d, want 500", w.Code)
+	}
+	var body map[string]string
+	decode(t, w.Body.Bytes(), &body)
+	if body["error"] != "Internal server error" {
+		t.Errorf("Body = %s, want the internal error alone", w.Body.Bytes())
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length = %s for %d bytes", got, w.Body.Len())
+	}
+}
+
+func TestPrettyResponsesIndented(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	path := "/people/" + strconv.FormatUint(uint64(person.ID), 10)
+
+	for query, indented := range map[string]bool{"": false, "?pretty=false": false, "?pretty=true": true} {
+		resp, data := call(t, server, http.MethodGet, path+query, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s%s answered %d: %s", path, query, resp.StatusCode, data)
+		}
+		if got := strings.Contains(string(data), "\n  \"Name\": \"Dmitriy\""); got != indented {
+			t.Errorf("GET %s%s indented = %v, want %v:\n%s", path, query, got, indented, data)
+		}
+		var fetched Person
+		decode(t, data, &fetched)
+	}
+}
+
+func TestMessagePackResponses(t *testing.T) {
+	server, _ := newTestServer(t)
+	created := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+
+	unpack := func(data []byte, v interface{}) {
+		t.Helper()
+		decoder := msgpack.NewDecoder(bytes.NewReader(data))
+		decoder.SetCustomStructTag("json")
+		if err := decoder.Decode(v); err != nil {
+			t.Fatalf("Error decoding MessagePack %q: %v", data, err)
+		}
+	}
+
+	resp, data := call(t, server, http.MethodGet, fmt.Sprintf("/people/%d", created.ID), "", "Accept", "application/msgpack")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/msgpack" {
+		t.Fatalf("GET answered %d as %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var person Person
+	unpack(data, &person)
+	if person.ID != created.ID || person.Name != "Dmitriy" || person.Patronymic != "Vasilevich" || person.Age == nil || *person.Age != 42 || person.Nationality != "RU" {
+		t.Errorf("Decoded %+v, want the created person", person)
+	}
+
+	resp, data = call(t, server, http.MethodGet, "/people/999", "", "Accept", "application/x-msgpack")
+	var failure map[string]string
+	unpack(data, &failure)
+	if resp.StatusCode != http.StatusNotFound || failure["error"] != "Person not found" {
+		t.Errorf("Missing person answered %d: %v", resp.StatusCode, failure)
+	}
+
+	// Unsupported formats fall back to JSON
+	resp, data = call(t, server, http.MethodGet, fmt.Sprintf("/people/%d", created.ID), "", "Accept", "application/xml")
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Accept application/xml answered %q, want JSON", resp.Header.Get("Content-Type"))
+	}
+	decode(t, data, &person)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// sampleNames pairs first names with their gender; sample people never call the enrichment APIs
+var sampleNames = []struct {
+	Name   string
+	Gender string
+}{
+	{"Dmitriy", "male"}, {"Ivan", "male"}, {"Sergey", "male"}, {"Alexey", "male"}, {"Nikolay", "male"},
+	{"Anna", "female"}, {"Olga", "female"}, {"Maria", "female"}, {"Elena", "female"}, {"Natalia", "female"},
+}
+
+var sampleSurnames = []string{"Ivanov", "Petrov", "Sidorov", "Smirnov", "Kuznetsov", "Popov", "Volkov"}
+
+var sampleNationalities = []string{"RU", "UA", "KZ", "BY", "US", "DE"}
+
+// isDevEnvironment reports whether APP_ENV marks this as a development deployment
+func isDevEnvironment() bool {
+	env := appEnvSetting.get()
+	return env == "dev" || env == "development"
+}
+
+// seedPeople inserts count sample people with made-up enrichment. It refuses to
+// run outside a development environment.
+func seedPeople(count int) error {
+	if !isDevEnvironment() {
+		return fmt.Errorf("seeding is only allowed when APP_ENV=development")
+	}
+
+	tx := db.Begin()
+	for i := 0; i < count; i++ {
+		sample := sampleNames[rand.Intn(len(sampleNames))]
+		surname := sampleSurnames[rand.Intn(len(sampleSurnames))]
+		if sample.Gender == "female" {
+			surname += "a"
+		}
+
+		person := Person{
+			Name:              sample.Name,
+			Surname:           surname,
+			Age:               intPtr(18 + rand.Intn(60)),
+			Gender:            sample.Gender,
+			GenderProbability: 0.9 + rand.Float64()/10,
+			Nationality:       sampleNationalities[rand.Intn(len(sampleNationalities))],
+		}
+		if err := tx.Create(&person).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSeedPeople(t *testing.T) {
+	_, stub := newTestServer(t)
+
+	if err := seedPeople(5); err == nil {
+		t.Error("Seeding outside development succeeded")
+	}
+
+	t.Setenv("APP_ENV", "development")
+	if err := seedPeople(25); err != nil {
+		t.Fatalf("Error seeding: %v", err)
+	}
+
+	var people []Person
+	db.Find(&people)
+	if len(people) != 25 {
+		t.Errorf("Seeded %d people, want 25", len(people))
+	}
+	for _, person := range people {
+		if err := validatePerson(&person); err != nil || person.Age == nil || person.Gender == "" || person.Nationality == "" {
+			t.Errorf("Seeded %+v (%v), want a valid enriched person", person, err)
+		}
+	}
+	for _, p := range enrichmentProviders {
+		if n := stub.callCount(p.Name); n != 0 {
+			t.Errorf("Seeding called %s %d times", p.Name, n)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// serializer encodes response bodies in one media type
+type serializer interface {
+	ContentType() string
+	Encode(w io.Writer, v interface{}, pretty bool) error
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) ContentType() string { return "application/json" }
+
+func (jsonSerializer) Encode(w io.Writer, v interface{}, pretty bool) error {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
+
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) ContentType() string { return "application/msgpack" }
+
+func (msgpackSerializer) Encode(w io.Writer, v interface{}, pretty bool) error {
+	encoder := msgpack.NewEncoder(w)
+	// Honor the json tags so both formats share field names and omitempty
+	encoder.SetCustomStructTag("json")
+	return encoder.Encode(v)
+}
+
+// serializersByMediaType lists the output formats a client can ask for in Accept
+var serializersByMediaType = map[string]serializer{
+	"application/json":      jsonSerializer{},
+	"application/msgpack":   msgpackSerializer{},
+	"application/x-msgpack": msgpackSerializer{},
+	// Only streamed lists send NDJSON; elsewhere it is answered with plain JSON
+	ndjsonContentType: jsonSerializer{},
+}
+
+// negotiateSerializer picks the first supported format in the Accept header, defaulting to JSON,
+// with the field naming from its naming parameter or RESPONSE_FIELD_NAMING
+func negotiateSerializer(r *http.Request) serializer {
+	naming := responseFieldNamingSetting.get()
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if s, ok := serializersByMediaType[mediaType]; ok {
+			if n, ok := params["naming"]; ok {
+				naming = n
+			}
+			return withNaming(s, naming)
+		}
+	}
+	return withNaming(jsonSerializer{}, naming)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// defaultShutdownTimeout bounds how long in-flight requests may drain once shutdown begins
+const defaultShutdownTimeout = 10 * time.Second
+
+// defaultLoadShedRetryAfter is the Retry-After sent with shed requests when LOAD_SHED_RETRY_AFTER is unset
+const defaultLoadShedRetryAfter = time.Second
+
+// inFlight counts the requests currently being handled
+var inFlight int64
+
+// trackInFlight keeps inFlight up to date around every request
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedLoad admits at most MAX_CONCURRENT_REQUESTS requests at a time (unlimited
+// when unset or 0) and answers the rest 503 with Retry-After, protecting the
+// database and the providers' quotas under overload. Health checks are always
+// admitted so an overloaded instance isn't reported dead.
+func shedLoad(next http.Handler) http.Handler {
+	limit := maxConcurrentRequestsSetting.get()
+	if limit <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, limit)
+	retryAfter := strconv.Itoa(int(loadShedRetryAfterSetting.get().Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", retryAfter)
+			respondError(w, http.StatusServiceUnavailable, "Server is overloaded, try again later")
+		}
+	})
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "ok",
+		"in_flight": atomic.LoadInt64(&inFlight),
+	})
+}
+
+// listen opens the listener the server runs on: the Unix socket at UNIX_SOCKET
+// when set, otherwise TCP on PORT (default 8080).
+func listen() (net.Listener, error) {
+	if path := unixSocketSetting.get(); path != "" {
+		// A socket file left behind by a crashed process would make Listen fail
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+
+	port := portSetting.get()
+	if port == "" {
+		port = "8080"
+	}
+	return net.Listen("tcp", ":"+port)
+}
+
+// serve runs handler until SIGINT or SIGTERM, then stops accepting connections
+// and waits up to SHUTDOWN_TIMEOUT for in-flight requests to finish.
+// Closing a Unix listener removes its socket file.
+//
+// HTTP/1.1 is served by default; H2C=true also accepts HTTP/2 over cleartext,
+// for service meshes that speak h2c between sidecars.
+func serve(handler http.Handler) {
+	listener, err := listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	runServer(listener, handler, stop)
+}
+
+// runServer serves handler on listener until stop receives, then shuts the server down
+func runServer(listener net.Listener, handler http.Handler, stop <-chan os.Signal) {
+	handler = shedLoad(trackInFlight(handler))
+	if h2cSetting.get() {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("Listening on %s", listener.Addr())
+	<-stop
+
+	timeout := shutdownTimeoutSetting.get()
+	log.Printf("Shutting down, draining %d in-flight requests for up to %s", atomic.LoadInt64(&inFlight), timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server, %d requests still in flight: %v", atomic.LoadInt64(&inFlight), err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListensOnUnixSocket(t *testing.T) {
+	newTestServer(t)
+	path := filepath.Join(t.TempDir(), "api.sock")
+	t.Setenv("UNIX_SOCKET", path)
+	// A socket file left by a crashed run doesn't stop the server from starting
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := listen()
+	if err != nil {
+		t.Fatalf("Error listening on %s: %v", path, err)
+	}
+	go http.Serve(listener, newRouter())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/healthz")
+	if err != nil {
+		t.Fatalf("Error calling over the socket: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"ok"`) {
+		t.Errorf("GET /healthz over the socket answered %d: %s", resp.StatusCode, body)
+	}
+
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket file left after the listener closed: %v", err)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	stop := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		runServer(listener, mux, stop)
+		close(stopped)
+	}()
+
+	url := "http://" + listener.Addr().String()
+	answered := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			t.Errorf("In-flight request failed: %v", err)
+			answered <- 0
+			return
+		}
+		resp.Body.Close()
+		answered <- resp.StatusCode
+	}()
+	<-started
+
+	resp, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health map[string]interface{}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	decode(t, body, &health)
+	// The health check itself is in flight as well
+	if health["in_flight"] != 2.0 {
+		t.Errorf("GET /healthz reported %v in flight, want 2", health["in_flight"])
+	}
+
+	stop <- os.Interrupt
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown finished before the in-flight request did")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if status := <-answered; status != http.StatusOK {
+		t.Errorf("In-flight request answered %d during shutdown, want 200", status)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't finish once the request did")
+	}
+	if n := atomic.LoadInt64(&inFlight); n != 0 {
+		t.Errorf("%d requests in flight after shutdown, want 0", n)
+	}
+}
+
+func TestServesH2C(t *testing.T) {
+	t.Setenv("H2C", "true")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+
+	stop := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		runServer(listener, mux, stop)
+		close(stopped)
+	}()
+	defer func() {
+		stop <- os.Interrupt
+		<-stopped
+	}()
+
+	// An HTTP/2 client with prior knowledge, as mesh sidecars speak it
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + listener.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("Error calling over h2c: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		t.Errorf("GET /healthz over h2c answered %d over %s, want 200 over HTTP/2", resp.StatusCode, resp.Proto)
+	}
+}
+
+func TestLoadSheddingAnswersExcessRequests(t *testing.T) {
+	t.Setenv("MAX_CONCURRENT_REQUESTS", "2")
+	t.Setenv("LOAD_SHED_RETRY_AFTER", "3s")
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	handler := shedLoad(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			done <- w.Code
+		}()
+	}
+	<-started
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/people", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("Request beyond the limit answered %d with Retry-After %q, want 503 and 3", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Health checks still get through, so the instance isn't reported dead
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Health check under overload answered %d, want 200", w.Code)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Admitted request answered %d, want 200", code)
+		}
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/people", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Request after the load passed answered %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// setting is one environment variable the service reads. Each is defined once
+// below, with its default, and read through that definition, so GET
+// /admin/config reports exactly what the code uses.
+type setting interface {
+	key() string
+	// report is the value GET /admin/config shows, secrets redacted
+	report() interface{}
+}
+
+// settings lists every defined setting, in definition order
+var settings []setting
+
+func register(s setting) {
+	settings = append(settings, s)
+}
+
+// stringSetting is a text setting; a secret one is never reported, only whether it is set
+type stringSetting struct {
+	name   string
+	def    string
+	secret bool
+}
+
+func newStringSetting(name, def string) stringSetting {
+	s := stringSetting{name: name, def: def}
+	register(s)
+	return s
+}
+
+func newSecretSetting(name string) stringSetting {
+	s := stringSetting{name: name, secret: true}
+	register(s)
+	return s
+}
+
+func (s stringSetting) key() string { return s.name }
+func (s stringSetting) get() string { return getEnv(s.name, s.def) }
+
+func (s stringSetting) report() interface{} {
+	if s.secret && s.get() != "" {
+		return redacted
+	}
+	return s.get()
+}
+
+// choiceSetting is a text setting limited to a few values; anything else means the default
+type choiceSetting struct {
+	name    string
+	def     string
+	choices []string
+}
+
+func newChoiceSetting(name, def string, choices ...string) choiceSetting {
+	s := choiceSetting{name: name, def: def, choices: choices}
+	register(s)
+	return s
+}
+
+func (s choiceSetting) key() string         { return s.name }
+func (s choiceSetting) report() interface{} { return s.get() }
+
+func (s choiceSetting) get() string {
+	v := os.Getenv(s.name)
+	for _, choice := range s.choices {
+		if v == choice {
+			return v
+		}
+	}
+	return s.def
+}
+
+// listSetting is a comma-separated list of lowercase names, empty by default
+type listSetting struct {
+	name string
+}
+
+func newListSetting(name string) listSetting {
+	s := listSetting{name: name}
+	register(s)
+	return s
+}
+
+func (s listSetting) key() string         { return s.name }
+func (s listSetting) report() interface{} { return s.get() }
+
+func (s listSetting) get() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv(s.name), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type intSetting struct {
+	name string
+	def  int
+}
+
+func newIntSetting(name string, def int) intSetting {
+	s := intSetting{name: name, def: def}
+	register(s)
+	return s
+}
+
+func (s intSetting) key() string         { return s.name }
+func (s intSetting) get() int            { return getEnvInt(s.name, s.def) }
+func (s intSetting) report() interface{} { return s.get() }
+
+type boolSetting struct {
+	name string
+	def  bool
+}
+
+func newBoolSetting(name string, def bool) boolSetting {
+	s := boolSetting{name: name, def: def}
+	register(s)
+	return s
+}
+
+func (s boolSetting) key() string         { return s.name }
+func (s boolSetting) get() bool           { return getEnvBool(s.name, s.def) }
+func (s boolSetting) report() interface{} { return s.get() }
+
+type durationSetting struct {
+	name string
+	def  time.Duration
+}
+
+func newDurationSetting(name string, def time.Duration) durationSetting {
+	s := durationSetting{name: name, def: def}
+	register(s)
+	return s
+}
+
+func (s durationSetting) key() string         { return s.name }
+func (s durationSetting) get() time.Duration  { return getEnvDuration(s.name, s.def) }
+func (s durationSetting) report() interface{} { return s.get().String() }
+
+// The settings of the service. Per-provider ones, such as AGIFY_API or
+// AGIFY_TIMEOUT, are defined by the provider methods below and reported with
+// their provider instead.
+var (
+	appEnvSetting     = newStringSetting("APP_ENV", "")
+	portSetting       = newStringSetting("PORT", "")
+	unixSocketSetting = newStringSetting("UNIX_SOCKET", "")
+	adminTokenSetting = newSecretSetting("ADMIN_TOKEN")
+
+	databaseURLSetting = newSecretSetting("DATABASE_URL")
+	dbHostSetting      = newStringSetting("DB_HOST", "")
+	dbPortSetting      = newStringSetting("DB_PORT", "5432")
+	dbUserSetting      = newStringSetting("DB_USER", "")
+	dbPasswordSetting  = newSecretSetting("DB_PASSWORD")
+	dbNameSetting      = newStringSetting("DB_NAME", "")
+	dbSSLModeSetting   = newStringSetting("DB_SSLMODE", "disable")
+
+	maxConcurrentRequestsSetting = newIntSetting("MAX_CONCURRENT_REQUESTS", 0)
+	loadShedRetryAfterSetting    = newDurationSetting("LOAD_SHED_RETRY_AFTER", defaultLoadShedRetryAfter)
+	h2cSetting                   = newBoolSetting("H2C", false)
+	shutdownTimeoutSetting       = newDurationSetting("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	dbSlowQueryThresholdSetting  = newDurationSetting("DB_SLOW_QUERY_THRESHOLD", defaultDBSlowQueryThreshold)
+	dbLogLevelSetting            = newStringSetting("DB_LOG_LEVEL", "warn")
+
+	enrichmentFreshnessSetting = newDurationSetting("ENRICHMENT_FRESHNESS", defaultEnrichmentFreshness)
+	idStrategySetting          = newChoiceSetting("ID_STRATEGY", idStrategyIncrement, idStrategyUUID, idStrategyULID)
+	enrichConcurrencySetting   = newIntSetting("ENRICH_CONCURRENCY", defaultEnrichConcurrency)
+	enrichTimeoutSetting       = newDurationSetting("ENRICH_TIMEOUT", defaultEnrichTimeout)
+	enrichCacheSetting         = newStringSetting("ENRICH_CACHE", "memory")
+	enrichCacheTTLSetting      = newDurationSetting("ENRICH_CACHE_TTL", defaultEnrichCacheTTL)
+	enrichCacheSizeSetting     = newIntSetting("ENRICH_CACHE_SIZE", defaultEnrichCacheSize)
+	redisURLSetting            = newSecretSetting("REDIS_URL")
+	maxBatchSizeSetting        = newIntSetting("MAX_BATCH_SIZE", defaultMaxBatchSize)
+	enrichCountryHintSetting   = newBoolSetting("ENRICH_COUNTRY_HINT", false)
+	enrichCombineSetting       = newStringSetting("ENRICH_COMBINE", "first")
+	disabledProvidersSetting   = newListSetting("DISABLED_PROVIDERS")
+	requiredProvidersSetting   = newListSetting("REQUIRED_PROVIDERS")
+	reenrichOnFieldsSetting    = newStringSetting("REENRICH_ON_FIELDS", "name")
+
+	nationalityFormatSetting       = newChoiceSetting("NATIONALITY_FORMAT", nationalityAlpha2, nationalityAlpha3)
+	regionSchemeSetting            = newChoiceSetting("REGION_SCHEME", regionSchemeContinent, regionSchemeEU)
+	nationalityConvertBatchSetting = newIntSetting("NATIONALITY_CONVERT_BATCH", defaultNationalityConvertBatch)
+	unknownNationalitySetting      = newStringSetting("UNKNOWN_NATIONALITY", "log")
+	nationalityCandidatesSetting   = newIntSetting("NATIONALITY_CANDIDATES", defaultNationalityCandidates)
+
+	agePlausibleMinSetting = newIntSetting("AGE_PLAUSIBLE_MIN", defaultAgePlausibleMin)
+	agePlausibleMaxSetting = newIntSetting("AGE_PLAUSIBLE_MAX", defaultAgePlausibleMax)
+	ageImplausibleSetting  = newStringSetting("AGE_IMPLAUSIBLE", "null")
+	unknownAgeSetting      = newStringSetting("UNKNOWN_AGE", "zero")
+	ageRoundingSetting     = newStringSetting("AGE_ROUNDING", "truncate")
+	defaultGenderSetting   = newStringSetting("DEFAULT_GENDER", "")
+	transliterateSetting   = newBoolSetting("TRANSLITERATE_NAMES", false)
+
+	logProviderLatencySetting = newBoolSetting("LOG_PROVIDER_LATENCY", true)
+	logBodiesSetting          = newBoolSetting("LOG_BODIES", false)
+	logBodyLimitSetting       = newIntSetting("LOG_BODY_LIMIT", defaultLogBodyLimit)
+	logRedactFieldsSetting    = newStringSetting("LOG_REDACT_FIELDS", "authorization,password,token")
+	privacyModeSetting        = newBoolSetting("PRIVACY_MODE", false)
+
+	maxPageLimitSetting           = newIntSetting("MAX_PAGE_LIMIT", defaultMaxPageLimit)
+	maxCSVBytesSetting            = newIntSetting("MAX_CSV_BYTES", defaultMaxCSVBytes)
+	randomMaxCountSetting         = newIntSetting("RANDOM_MAX_COUNT", defaultRandomMaxCount)
+	nameMaxLengthSetting          = newIntSetting("NAME_MAX_LENGTH", defaultNameMaxLength)
+	nameScriptsSetting            = newStringSetting("NAME_SCRIPTS", "")
+	duplicateCreateWindowSetting  = newDurationSetting("DUPLICATE_CREATE_WINDOW", defaultDuplicateCreateWindow)
+	idempotentDeleteSetting       = newBoolSetting("IDEMPOTENT_DELETE", true)
+	deleteNoContentSetting        = newBoolSetting("DELETE_NO_CONTENT", false)
+	requireJSONContentTypeSetting = newBoolSetting("REQUIRE_JSON_CONTENT_TYPE", true)
+	importConflictStrategySetting = newStringSetting("IMPORT_CONFLICT_STRATEGY", "fail")
+	responseFieldNamingSetting    = newStringSetting("RESPONSE_FIELD_NAMING", namingDefault)
+	responseCacheSetting          = newBoolSetting("RESPONSE_CACHE", false)
+	responseCacheTTLSetting       = newDurationSetting("RESPONSE_CACHE_TTL", defaultResponseCacheTTL)
+	jobRetentionSetting           = newDurationSetting("JOB_RETENTION", defaultJobRetention)
+	jobWorkersSetting             = newIntSetting("JOB_WORKERS", defaultJobWorkers)
+	jobQueueSizeSetting           = newIntSetting("JOB_QUEUE_SIZE", defaultJobQueueSize)
+	requestIDHeaderSetting        = newStringSetting("REQUEST_ID_HEADER", "X-Request-ID")
+	corsAllowedOriginsSetting     = newStringSetting("CORS_ALLOWED_ORIGINS", "*")
+	corsMaxAgeSetting             = newDurationSetting("CORS_MAX_AGE", defaultCORSMaxAge)
+	featureFlagsSetting           = newStringSetting("FEATURE_FLAGS", "")
+)
+
+// urlSetting is the primary base URL of p, e.g. AGIFY_API
+func (p provider) urlSetting() stringSetting {
+	return stringSetting{name: p.EnvVar}
+}
+
+// fallbackSetting is the comma-separated fallback base URLs of p, e.g. AGIFY_FALLBACK_APIS
+func (p provider) fallbackSetting() stringSetting {
+	return stringSetting{name: p.FallbackEnvVar}
+}
+
+// timeoutSetting bounds one call to p, e.g. AGIFY_TIMEOUT, defaulting to ENRICH_TIMEOUT
+func (p provider) timeoutSetting() durationSetting {
+	return durationSetting{name: strings.ToUpper(p.Name) + "_TIMEOUT", def: enrichTimeoutSetting.get()}
+}
+
+// sourcesSetting lists the name fields fed to p, e.g. GENDERIZE_SOURCES
+func (p provider) sourcesSetting() listSetting {
+	return listSetting{name: strings.ToUpper(p.Name) + "_SOURCES"}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// enrichmentInput holds the parts of a person's name that can feed the providers
+type enrichmentInput struct {
+	Name       string
+	Surname    string
+	Patronymic string
+	// Country is an optional alpha-2 hint sent to Agify and Genderize as country_id
+	Country string
+}
+
+func inputOf(person *Person) enrichmentInput {
+	return enrichmentInput{Name: person.Name, Surname: person.Surname, Patronymic: person.Patronymic, Country: countryHint(person.Nationality)}
+}
+
+// countryHint turns a client-supplied nationality into the country hint when
+// ENRICH_COUNTRY_HINT=true, so a person known to be from a country is enriched
+// with that country's statistics. Without the setting no hint is sent.
+func countryHint(nationality string) string {
+	if nationality == "" || !enrichCountryHintSetting.get() {
+		return ""
+	}
+	hint, _ := convertCountryCode(nationality, nationalityAlpha2)
+	return hint
+}
+
+// key identifies the input for collapsing concurrent lookups. Only the name fields
+// fed to an enabled provider count, so by default everyone sharing a first name
+// shares a lookup whatever their surname.
+func (in enrichmentInput) key() string {
+	used := map[string]bool{}
+	for _, p := range enrichmentProviders {
+		if p.enabled() {
+			for _, field := range p.sourceFields() {
+				used[field] = true
+			}
+		}
+	}
+
+	var parts []string
+	for _, field := range []string{"name", "surname", "patronymic"} {
+		value := ""
+		if used[field] {
+			value = in.field(field)
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(append(parts, in.Country), "\x00")
+}
+
+func (in enrichmentInput) field(name string) string {
+	switch name {
+	case "surname":
+		return in.Surname
+	case "patronymic":
+		return in.Patronymic
+	default:
+		return in.Name
+	}
+}
+
+// sourceFields lists the name fields fed to p, in order of preference, read from
+// e.g. GENDERIZE_SOURCES="patronymic,name". Only the first name is used by default.
+func (p provider) sourceFields() []string {
+	var fields []string
+	for _, field := range p.sourcesSetting().get() {
+		switch field {
+		case "name", "surname", "patronymic":
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return []string{"name"}
+	}
+	return fields
+}
+
+// combineBest reports whether ENRICH_COMBINE=best is set. By default the first
+// source field with an answer wins; with best every field is looked up and the
+// most confident answer is kept.
+func combineBest() bool {
+	return enrichCombineSetting.get() == "best"
+}
+
+// sourceLookup queries a provider for one source field, returning how confident
+// the answer is (0 when the provider had none) and a func that copies it into e
+type sourceLookup func(field, value string) (score float64, apply func(e *enrichment), raw json.RawMessage, err error)
+
+// enrichFrom runs lookup for each source field of p that is set on in and applies
+// the answer picked by the combine strategy. p only counts as failed when no
+// field could be looked up.
+func (e *enrichment) enrichFrom(p provider, in enrichmentInput, lookup sourceLookup) {
+	fields := p.sourceFields()
+
+	var (
+		bestField string
+		bestScore float64
+		bestApply func(e *enrichment)
+		bestRaw   json.RawMessage
+		lastErr   error
+	)
+	for _, field := range fields {
+		value := in.field(field)
+		if value == "" {
+			continue
+		}
+		if query := lookupName(value); query != value {
+			e.decide("%s transliterated to %q for lookup", field, query)
+			value = query
+		}
+
+		score, apply, raw, err := lookup(field, value)
+		if err != nil {
+			lastErr = err
+			e.decide("%s failed for %s: %v", p.Name, field, err)
+			continue
+		}
+		if bestApply == nil || score > bestScore {
+			bestField, bestScore, bestApply, bestRaw = field, score, apply, raw
+		}
+		if score > 0 && !combineBest() {
+			break
+		}
+	}
+
+	if bestApply == nil {
+		if lastErr != nil {
+			e.record(p, nil, lastErr)
+		} else {
+			e.decide("no source field for %s is set", p.Name)
+		}
+		return
+	}
+
+	bestApply(e)
+	e.record(p, bestRaw, nil)
+	if len(fields) > 1 {
+		e.decide("%s answer taken from %s", p.Name, bestField)
+	}
+}
+
+// patronymicGender infers gender from the suffix of a (romanized) Russian patronymic,
+// returning "" when the suffix is not recognized
+func patronymicGender(patronymic string) string {
+	p := strings.ToLower(strings.TrimSpace(transliterate(patronymic)))
+	switch {
+	case strings.HasSuffix(p, "ich"), strings.HasSuffix(p, "ogly"), strings.HasSuffix(p, "uly"):
+		return "male"
+	case strings.HasSuffix(p, "ovna"), strings.HasSuffix(p, "evna"), strings.HasSuffix(p, "ichna"), strings.HasSuffix(p, "kyzy"):
+		return "female"
+	}
+	return ""
+}
+
+// enrichmentTriggered reports whether an update from before to after changed one of
+// the fields listed in REENRICH_ON_FIELDS (default "name"), so unrelated edits such
+// as fixing a patronymic don't spend provider quota
+func enrichmentTriggered(before, after *Person) bool {
+	fields := strings.Split(reenrichOnFieldsSetting.get(), ",")
+	for _, field := range fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if inputOf(before).field(field) != inputOf(after).field(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// forceEnrichment reports whether an update asked to re-enrich person with
+// ?force_enrich=true whatever changed and however fresh the data is. A manually
+// overridden person is only re-enriched when ?force=true is sent as well, which
+// also hands the record back to enrichment.
+func forceEnrichment(r *http.Request, person *Person) bool {
+	if forced, _ := strconv.ParseBool(r.URL.Query().Get("force_enrich")); !forced {
+		return false
+	}
+	if person.ManualOverride {
+		if force, _ := strconv.ParseBool(r.URL.Query().Get("force")); !force {
+			return false
+		}
+		person.ManualOverride = false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// defaultAgeBucket is the histogram bucket width when ?bucket= is omitted
+const defaultAgeBucket = 10
+
+// maxAgeBucket is the widest bucket accepted, beyond which everyone falls in one
+const maxAgeBucket = 120
+
+// AgeBucket counts the people whose age is between From and To inclusive
+type AgeBucket struct {
+	From  int
+	To    int
+	Count int
+}
+
+// getAgeHistogram counts people per ?bucket=-year age range (default 10), grouped
+// in SQL. It takes the same filters as GET /people; people whose age is unknown
+// are left out, and empty buckets are omitted.
+func getAgeHistogram(w http.ResponseWriter, r *http.Request) {
+	bucket := defaultAgeBucket
+	if raw := r.URL.Query().Get("bucket"); raw != "" {
+		var err error
+		bucket, err = strconv.Atoi(raw)
+		if err != nil || bucket < 1 || bucket > maxAgeBucket {
+			respondError(w, http.StatusBadRequest, "Invalid bucket, it must be a whole number of years between 1 and 120")
+			return
+		}
+	}
+
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := applyPeopleFilters(conn, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var rows []struct {
+		Start int
+		Count int
+	}
+	err = query.Model(&Person{}).
+		Select("(age / ?) * ? AS start, count(*) AS count", bucket, bucket).
+		Where("NOT " + unknownAgeCondition()).
+		Group("start").
+		Order("start").
+		Scan(&rows).Error
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to compute age histogram")
+		return
+	}
+
+	histogram := make([]AgeBucket, len(rows))
+	for i, row := range rows {
+		histogram[i] = AgeBucket{From: row.Start, To: row.Start + bucket - 1, Count: row.Count}
+	}
+	respondJSON(w, http.StatusOK, histogram)
+}
+
+// PeopleStats summarizes the people matching the GET /people filters
+type PeopleStats struct {
+	Total int
+	// ByGender counts people per gender, with unknown genders under ""
+	ByGender map[string]int
+	// AverageAge leaves out people whose age is unknown, and is null when every age is
+	AverageAge *float64
+	UnknownAge int
+}
+
+// getPeopleStats reports totals for the people matching the same filters as GET /people
+func getPeopleStats(w http.ResponseWriter, r *http.Request) {
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := applyPeopleFilters(conn, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var genders []struct {
+		Gender string
+		Count  int
+	}
+	if err := query.Model(&Person{}).Select("gender, count(*) AS count").Group("gender").Scan(&genders).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to compute people stats")
+		return
+	}
+	var ages struct {
+		Known      int
+		AverageAge *float64
+	}
+	err = query.Model(&Person{}).
+		Select("count(*) AS known, avg(age) AS average_age").
+		Where("NOT " + unknownAgeCondition()).
+		Scan(&ages).Error
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to compute people stats")
+		return
+	}
+
+	stats := PeopleStats{ByGender: map[string]int{}, AverageAge: ages.AverageAge}
+	for _, g := range genders {
+		stats.ByGender[g.Gender] = g.Count
+		stats.Total += g.Count
+	}
+	stats.UnknownAge = stats.Total - ages.Known
+	respondJSON(w, http.StatusOK, stats)
+}
+
+// NationalityCount is how many people have one nationality
+type NationalityCount struct {
+	Nationality string
+	Count       int
+}
+
+// getNationalityCounts counts people per nationality, most common first, taking
+// the same filters as GET /people. People without a nationality are left out.
+func getNationalityCounts(w http.ResponseWriter, r *http.Request) {
+	conn, done := readDB(r)
+	defer done()
+
+	query, err := applyPeopleFilters(conn, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	counts := []NationalityCount{}
+	err = query.Model(&Person{}).
+		Select("nationality, count(*) AS count").
+		Where("nationality <> ''").
+		Group("nationality").
+		Order("count DESC, nationality").
+		Scan(&counts).Error
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to count nationalities")
+		return
+	}
+	respondJSON(w, http.StatusOK, counts)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAgeHistogramCountsBuckets(t *testing.T) {
+	server, _ := newTestServer(t)
+	for _, age := range []int{0, 5, 9, 10, 19, 25, 61} {
+		age := age
+		if err := db.Create(&Person{Name: "Dmitriy", Age: &age, Nationality: "RU"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Create(&Person{Name: "Ivan", Nationality: "UA"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string][]AgeBucket{
+		// An age of 0 is unknown under UNKNOWN_AGE=zero, so it isn't counted
+		"/people/stats/age-histogram":                {{From: 0, To: 9, Count: 2}, {From: 10, To: 19, Count: 2}, {From: 20, To: 29, Count: 1}, {From: 60, To: 69, Count: 1}},
+		"/people/stats/age-histogram?bucket=20":      {{From: 0, To: 19, Count: 4}, {From: 20, To: 39, Count: 1}, {From: 60, To: 79, Count: 1}},
+		"/people/stats/age-histogram?bucket=120":     {{From: 0, To: 119, Count: 6}},
+		"/people/stats/age-histogram?nationality=UA": {},
+	} {
+		resp, data := call(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s answered %d: %s", path, resp.StatusCode, data)
+			continue
+		}
+		var got []AgeBucket
+		decode(t, data, &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GET %s = %+v, want %+v", path, got, want)
+		}
+	}
+
+	for _, bucket := range []string{"0", "-5", "121", "ten"} {
+		if resp, data := call(t, server, http.MethodGet, "/people/stats/age-histogram?bucket="+bucket, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Bucket %q answered %d: %s", bucket, resp.StatusCode, data)
+		}
+	}
+}
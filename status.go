@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// providerProbeName is the harmless name sent when probing the enrichment APIs
+const providerProbeName = "michael"
+
+// providerStatusTTL is how long a probe result is reused before the providers are hit again
+const providerStatusTTL = 30 * time.Second
+
+// providerProbeTimeout bounds each probe, so a hung provider reports as unreachable quickly
+const providerProbeTimeout = 5 * time.Second
+
+// EndpointStatus describes the health of one URL of an enrichment API
+type EndpointStatus struct {
+	Reachable      bool
+	StatusCode     int
+	LatencyMs      int64
+	RemainingQuota *int
+	Error          string `json:",omitempty"`
+}
+
+// ProviderStatus describes the health of one enrichment API: its primary URL,
+// and each fallback in the order they are tried. Disabled providers aren't probed.
+type ProviderStatus struct {
+	Name    string
+	Enabled bool
+	EndpointStatus
+	Fallbacks []EndpointStatus `json:",omitempty"`
+}
+
+var providerStatusCache struct {
+	sync.Mutex
+	checkedAt time.Time
+	statuses  []ProviderStatus
+}
+
+// providerStatusGroup lets concurrent status requests share one round of probes
+var providerStatusGroup singleflight.Group
+
+func getProviderStatus(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, cachedProviderStatuses(r.Context()))
+}
+
+// cachedProviderStatuses returns the last probe results while they are fresh,
+// otherwise probes again. The lock is only held to read and store the results,
+// so a slow probe doesn't block anything else.
+func cachedProviderStatuses(ctx context.Context) []ProviderStatus {
+	providerStatusCache.Lock()
+	statuses, checkedAt := providerStatusCache.statuses, providerStatusCache.checkedAt
+	providerStatusCache.Unlock()
+	if statuses != nil && time.Since(checkedAt) <= providerStatusTTL {
+		return statuses
+	}
+
+	v, _, _ := providerStatusGroup.Do("probe", func() (interface{}, error) {
+		// The probes are shared with concurrent callers, so one of them going away mustn't cancel them
+		statuses := probeProviders(context.WithoutCancel(ctx))
+		providerStatusCache.Lock()
+		providerStatusCache.statuses, providerStatusCache.checkedAt = statuses, time.Now()
+		providerStatusCache.Unlock()
+		return statuses, nil
+	})
+	return v.([]ProviderStatus)
+}
+
+func probeProviders(ctx context.Context) []ProviderStatus {
+	statuses := make([]ProviderStatus, len(enrichmentProviders))
+
+	var wg sync.WaitGroup
+	for i, p := range enrichmentProviders {
+		statuses[i] = ProviderStatus{Name: p.Name, Enabled: p.enabled()}
+		if !statuses[i].Enabled {
+			statuses[i].Error = errProviderDisabled.Error()
+			continue
+		}
+
+		urls := p.baseURLs()
+		statuses[i].Fallbacks = make([]EndpointStatus, len(urls)-1)
+		for j, baseURL := range urls {
+			endpoint := &statuses[i].EndpointStatus
+			if j > 0 {
+				endpoint = &statuses[i].Fallbacks[j-1]
+			}
+			wg.Add(1)
+			go func(endpoint *EndpointStatus, baseURL string) {
+				defer wg.Done()
+				*endpoint = probeProvider(ctx, baseURL)
+			}(endpoint, baseURL)
+		}
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+func probeProvider(ctx context.Context, baseURL string) EndpointStatus {
+	var status EndpointStatus
+
+	ctx, cancel := context.WithTimeout(ctx, providerProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := client.R().SetContext(ctx).Get(fmt.Sprintf("%s/?name=%s", baseURL, providerProbeName))
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	status.Reachable = true
+	status.StatusCode = resp.StatusCode()
+	status.RemainingQuota = parseQuota(resp.Header()).Remaining
+
+	return status
+}
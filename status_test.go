@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProviderStatus(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv(agifyProvider.FallbackEnvVar, "http://agify-backup.test")
+	adminCall(t, server, http.MethodPost, "/admin/providers/nationalize/disable", "")
+
+	resp, data := call(t, server, http.MethodGet, "/status/providers", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status answered %d: %s", resp.StatusCode, data)
+	}
+	var statuses []ProviderStatus
+	decode(t, data, &statuses)
+	byName := map[string]ProviderStatus{}
+	for _, s := range statuses {
+		byName[s.Name] = s
+	}
+
+	agify := byName[agifyProvider.Name]
+	if !agify.Enabled || !agify.Reachable || agify.StatusCode != http.StatusOK {
+		t.Errorf("agify primary = %+v, want reachable", agify)
+	}
+	if len(agify.Fallbacks) != 1 || agify.Fallbacks[0].Reachable {
+		t.Errorf("agify fallbacks = %+v, want one unreachable", agify.Fallbacks)
+	}
+	if nationalize := byName[nationalizeProvider.Name]; nationalize.Enabled || nationalize.Reachable {
+		t.Errorf("nationalize = %+v, want reported disabled without a probe", nationalize)
+	}
+}
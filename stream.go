@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ndjsonContentType is the media type of newline-delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// streamFlushEvery is how many people are written between flushes while streaming
+const streamFlushEvery = 100
+
+// streamPeople writes the people matched by query one row at a time, so memory
+// use stays flat however many rows there are. Rows form a JSON array, or with
+// ndjson one JSON object per line, with the field naming negotiated for the
+// response; ?pretty=true indents the array. Errors after the header has been
+// sent can only be logged, leaving the output truncated.
+func streamPeople(w http.ResponseWriter, query *gorm.DB, ndjson bool) {
+	rows, err := query.Model(&Person{}).Rows()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+	defer rows.Close()
+
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder, pretty := rowSerializer(w)
+	// Indenting would split an NDJSON row over several lines
+	pretty = pretty && !ndjson
+
+	if !ndjson {
+		w.Write([]byte("["))
+	}
+	for i := 0; rows.Next(); i++ {
+		var person Person
+		if err := query.ScanRows(rows, &person); err != nil {
+			log.Printf("Error scanning streamed person: %v", err)
+			break
+		}
+		// ScanRows skips the gorm callbacks
+		person.AfterFind()
+
+		if i > 0 && !ndjson {
+			w.Write([]byte(","))
+		}
+		if err := encoder.Encode(w, person, pretty); err != nil {
+			log.Printf("Error streaming person: %v", err)
+			break
+		}
+
+		if flusher != nil && (i+1)%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating people: %v", err)
+	}
+	if !ndjson {
+		w.Write([]byte("]\n"))
+	}
+}
+
+// rowSerializer returns the serializer streamed rows are written with and whether
+// to indent them. Rows are always JSON, even when MessagePack was negotiated, but
+// keep the negotiated field naming.
+func rowSerializer(w http.ResponseWriter) (serializer, bool) {
+	jw, ok := w.(*jsonWriter)
+	if !ok {
+		return jsonSerializer{}, false
+	}
+	if n, ok := jw.serializer.(namingSerializer); ok {
+		return withNaming(jsonSerializer{}, n.naming), jw.pretty
+	}
+	return jsonSerializer{}, jw.pretty
+}
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON
+func wantsNDJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
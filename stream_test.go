@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// storePeople inserts n people straight into the test database, without enrichment
+func storePeople(t testing.TB, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := db.Create(&Person{Name: "Dmitriy", Surname: "Ushakov", Nationality: "RU"}).Error; err != nil {
+			t.Fatalf("Error storing person: %v", err)
+		}
+	}
+}
+
+func TestStreamIgnoresPageLimitClamp(t *testing.T) {
+	server, _ := newTestServer(t)
+	t.Setenv("MAX_PAGE_LIMIT", "2")
+	storePeople(t, 5)
+
+	for path, want := range map[string]int{
+		"/people?stream=true":         5,
+		"/people?stream=true&limit=4": 4,
+		"/people?limit=4":             2,
+	} {
+		resp, data := call(t, server, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+		}
+		var people []Person
+		decode(t, data, &people)
+		if len(people) != want {
+			t.Errorf("GET %s returned %d people, want %d", path, len(people), want)
+		}
+	}
+}
+
+// flushRecorder remembers how much had been written at each flush
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (w *flushRecorder) Flush() {
+	w.flushedAt = append(w.flushedAt, w.Body.Len())
+}
+
+func TestStreamWritesRowsAsTheyAreRead(t *testing.T) {
+	newTestServer(t)
+	const people = 3*streamFlushEvery + 1
+	storePeople(t, people)
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	streamPeople(w, db.Order("id"), false)
+
+	// Flushing every streamFlushEvery rows means the array was written while rows
+	// were still being read, rather than after collecting them all
+	if len(w.flushedAt) != people/streamFlushEvery {
+		t.Fatalf("Flushed %d times, want %d", len(w.flushedAt), people/streamFlushEvery)
+	}
+	if w.flushedAt[0] >= w.Body.Len()/2 {
+		t.Errorf("First flush after %d of %d bytes, want it early in the stream", w.flushedAt[0], w.Body.Len())
+	}
+	var streamed []Person
+	decode(t, w.Body.Bytes(), &streamed)
+	if len(streamed) != people {
+		t.Errorf("Streamed %d people, want %d", len(streamed), people)
+	}
+}
+
+func TestNDJSONLinesParseAsPeople(t *testing.T) {
+	server, _ := newTestServer(t)
+	storePeople(t, 3)
+
+	for _, header := range [][]string{nil, {"Accept", ndjsonContentType}} {
+		path := "/people"
+		if header == nil {
+			path += "?format=ndjson"
+		}
+		resp, data := call(t, server, http.MethodGet, path, "", header...)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ndjsonContentType {
+			t.Fatalf("GET %s answered %d as %q: %s", path, resp.StatusCode, resp.Header.Get("Content-Type"), data)
+		}
+
+		lines := 0
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var person Person
+			if err := json.Unmarshal(scanner.Bytes(), &person); err != nil || person.Name != "Dmitriy" {
+				t.Errorf("Line %d = %s, not a person: %v", lines+1, scanner.Bytes(), err)
+			}
+			lines++
+		}
+		if lines != 3 {
+			t.Errorf("GET %s returned %d lines, want 3", path, lines)
+		}
+	}
+}
+
+func TestStreamsFollowResponseNaming(t *testing.T) {
+	server, _ := newTestServer(t)
+	storePeople(t, 2)
+
+	for path, accept := range map[string]string{
+		"/people?stream=true":             "application/json; naming=snake",
+		"/people/export?format=json":      "application/json; naming=snake",
+		"/people?stream=true&pretty=true": "application/json; naming=snake",
+		"/people":                         ndjsonContentType + "; naming=snake",
+	} {
+		resp, data := call(t, server, http.MethodGet, path, "", "Accept", accept)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s answered %d: %s", path, resp.StatusCode, data)
+		}
+
+		var people []map[string]interface{}
+		if strings.HasPrefix(accept, ndjsonContentType) {
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var person map[string]interface{}
+				decode(t, []byte(line), &person)
+				people = append(people, person)
+			}
+		} else {
+			decode(t, data, &people)
+		}
+		if len(people) != 2 || people[0]["surname"] != "Ushakov" || people[0]["Surname"] != nil {
+			t.Errorf("GET %s with %q returned %s, want snake_case people", path, accept, data)
+		}
+		if pretty := strings.Contains(path, "pretty=true"); pretty != strings.Contains(string(data), "\n  ") {
+			t.Errorf("GET %s indented = %v, want %v", path, !pretty, pretty)
+		}
+	}
+}
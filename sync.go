@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// nextCursorHeader carries the cursor of the next page of an incremental sync
+const nextCursorHeader = "X-Next-Cursor"
+
+// getPeopleUpdatedSince answers GET /people?updated_since=<RFC3339> for incremental
+// sync: people updated after the timestamp, oldest change first. Pages are ?limit=
+// long (default 50) and, when another page may follow, X-Next-Cursor holds the
+// value to send as ?cursor= to continue after the last person returned. People
+// deleted since are included as tombstones with DeletedAt set, so a mirror can
+// drop them; deletes bump UpdatedAt for this, see deletePersonWithHistory.
+func getPeopleUpdatedSince(w http.ResponseWriter, r *http.Request, query *gorm.DB) {
+	params := r.URL.Query()
+	query = query.Unscoped()
+
+	since, err := time.Parse(time.RFC3339, params.Get("updated_since"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid updated_since, expected an RFC 3339 timestamp")
+		return
+	}
+	query = query.Where("updated_at > ?", since)
+
+	if raw := params.Get("cursor"); raw != "" {
+		updatedAt, id, err := decodeSyncCursor(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		query = query.Where("(updated_at, id) > (?, ?)", updatedAt, id)
+	}
+
+	limit := defaultQueryLimit
+	if raw := params.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit, expected a positive integer")
+			return
+		}
+		limit = clampLimit(limit)
+	}
+
+	var people []Person
+	if err := query.Order("updated_at, id").Limit(limit).Find(&people).Error; err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load people")
+		return
+	}
+
+	if len(people) == limit {
+		last := people[len(people)-1]
+		w.Header().Set(nextCursorHeader, encodeSyncCursor(last.UpdatedAt, last.ID))
+	}
+	respondJSON(w, http.StatusOK, people)
+}
+
+// encodeSyncCursor makes an opaque cursor pointing just past the person updated at updatedAt with id
+func encodeSyncCursor(updatedAt time.Time, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", updatedAt.Format(time.RFC3339Nano), id)))
+}
+
+func decodeSyncCursor(cursor string) (time.Time, uint, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor %q", decoded)
+	}
+	updatedAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return updatedAt, uint(id), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSyncReportsDeletions(t *testing.T) {
+	server, _ := newTestServer(t)
+	since := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	kept := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	deleted := createTestPerson(t, server, `{"name":"Ivan","surname":"Petrov"}`)
+	if resp, data := call(t, server, http.MethodDelete, fmt.Sprintf("/people/%d", deleted.ID), ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE answered %d: %s", resp.StatusCode, data)
+	}
+
+	resp, data := call(t, server, http.MethodGet, "/people?updated_since="+url.QueryEscape(since), "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Sync answered %d: %s", resp.StatusCode, data)
+	}
+	var people []Person
+	decode(t, data, &people)
+	if len(people) != 2 {
+		t.Fatalf("Sync returned %d people, want 2: %s", len(people), data)
+	}
+	// The delete is the latest change, so the tombstone comes last
+	if people[0].ID != kept.ID || people[0].DeletedAt != nil {
+		t.Errorf("First person = %+v, want %d alive", people[0], kept.ID)
+	}
+	if people[1].ID != deleted.ID || people[1].DeletedAt == nil {
+		t.Errorf("Second person = %+v, want a tombstone for %d", people[1], deleted.ID)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// cyrillicToLatin romanizes Russian letters following the ICAO 9303 scheme used in passports
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie", 'ы': "y", 'ь': "", 'э': "e", 'ю': "iu",
+	'я': "ia",
+}
+
+// transliterationEnabled reports whether TRANSLITERATE_NAMES is on. The providers
+// mostly know Latin spellings, so Cyrillic names are then romanized before lookup;
+// the person is still stored with the name as given.
+func transliterationEnabled() bool {
+	return transliterateSetting.get()
+}
+
+// transliterate romanizes the Cyrillic letters in name, leaving everything else as is
+func transliterate(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		latin, ok := cyrillicToLatin[unicode.ToLower(c)]
+		if !ok {
+			b.WriteRune(c)
+			continue
+		}
+		if unicode.IsUpper(c) && latin != "" {
+			latin = strings.ToUpper(latin[:1]) + latin[1:]
+		}
+		b.WriteString(latin)
+	}
+	return b.String()
+}
+
+// lookupName returns the form of name sent to the providers
+func lookupName(name string) string {
+	if !transliterationEnabled() {
+		return name
+	}
+	return transliterate(name)
+}
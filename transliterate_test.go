@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTransliterate(t *testing.T) {
+	for name, want := range map[string]string{
+		"Дмитрий":     "Dmitrii",
+		"Ёлкина":      "Elkina",
+		"Щукин":       "Shchukin",
+		"Ольга-Мария": "Olga-Mariia",
+		"Dmitriy":     "Dmitriy",
+	} {
+		if got := transliterate(name); got != want {
+			t.Errorf("transliterate(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCyrillicNamesTransliteratedForLookup(t *testing.T) {
+	server, stub := newTestServer(t)
+	enrichCache = noCache{}
+	// Like the real providers, the stub only knows the Latin spelling
+	stub.answer(agifyProvider.Name, func(name string) (int, string) {
+		if name == "Dmitrii" {
+			return http.StatusOK, `{"age":35}`
+		}
+		return http.StatusOK, `{"age":null}`
+	})
+
+	plain := createTestPerson(t, server, `{"name":"Дмитрий"}`)
+	if plain.Age != nil && *plain.Age == 35 {
+		t.Errorf("Created %+v without transliteration, want the Cyrillic name looked up as is", plain)
+	}
+
+	t.Setenv("TRANSLITERATE_NAMES", "true")
+	romanized := createTestPerson(t, server, `{"name":"Дмитрий"}`)
+	if romanized.Age == nil || *romanized.Age != 35 {
+		t.Errorf("Created %+v with transliteration, want the age of Dmitrii", romanized)
+	}
+	if romanized.Name != "Дмитрий" {
+		t.Errorf("Stored name %q, want the original spelling", romanized.Name)
+	}
+}
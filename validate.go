@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultNameMaxLength bounds names when NAME_MAX_LENGTH is unset; the providers reject longer input
+const defaultNameMaxLength = 50
+
+// validatePerson checks the client-supplied fields of person before enrichment,
+// reporting every invalid field at once as validationErrors
+func validatePerson(person *Person) error {
+	var errs validationErrors
+	if strings.TrimSpace(person.Name) == "" {
+		errs = append(errs, fieldError{"Name", "Name is required"})
+	} else if err := validateName("Name", person.Name); err != nil {
+		errs = append(errs, fieldError{"Name", err.Error()})
+	}
+	if person.Surname != "" {
+		if err := validateName("Surname", person.Surname); err != nil {
+			errs = append(errs, fieldError{"Surname", err.Error()})
+		}
+	}
+	if person.Patronymic != "" {
+		if err := validateName("Patronymic", person.Patronymic); err != nil {
+			errs = append(errs, fieldError{"Patronymic", err.Error()})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateName accepts letters, hyphens, apostrophes and spaces up to the configured length.
+// NAME_SCRIPTS restricts letters to the listed Unicode scripts (e.g. "Latin,Cyrillic");
+// by default letters of any script are allowed.
+func validateName(field, value string) error {
+	maxLength := nameMaxLengthSetting.get()
+	if utf8.RuneCountInString(value) > maxLength {
+		return newDomainError(errValidation, "%s must be at most %d characters", field, maxLength)
+	}
+
+	scripts := allowedScripts()
+	for _, c := range value {
+		switch {
+		case c == '-' || c == '\'' || c == ' ':
+		case unicode.IsLetter(c) && (scripts == nil || unicode.IsOneOf(scripts, c)):
+		default:
+			return newDomainError(errValidation, "%s may only contain letters, hyphens, apostrophes and spaces", field)
+		}
+	}
+	return nil
+}
+
+func allowedScripts() []*unicode.RangeTable {
+	raw := nameScriptsSetting.get()
+	if raw == "" {
+		return nil
+	}
+
+	var scripts []*unicode.RangeTable
+	for _, name := range strings.Split(raw, ",") {
+		if table, ok := unicode.Scripts[strings.TrimSpace(name)]; ok {
+			scripts = append(scripts, table)
+		}
+	}
+	return scripts
+}
+
+// putFields are the fields a PUT may set. Keys are matched after putFieldKey, so
+// the field names of every response naming (Name, name, gender_probability,
+// genderProbability) are understood.
+var putFields = map[string]bool{"name": true, "surname": true, "patronymic": true}
+
+// putIgnoredFields are read-only fields a GET returns that a PUT ignores, as they
+// change under the client: identifiers, timestamps and the computed LikelyRegion
+var putIgnoredFields = map[string]bool{
+	"id": true, "publicid": true, "likelyregion": true,
+	"createdat": true, "updatedat": true, "deletedat": true,
+	"enrichedat": true, "ageenrichedat": true, "genderenrichedat": true, "nationalityenrichedat": true,
+}
+
+// putFieldKey normalizes a field name of any response naming for the lists above
+func putFieldKey(field string) string {
+	return strings.ToLower(strings.ReplaceAll(field, "_", ""))
+}
+
+// personFromPut builds the client-supplied fields of a PUT body replacing existing.
+// Any other field of a person, such as Age, is accepted only with the value it
+// already has, so a body just read by GET can be sent back, while an attempt to
+// change it is refused; unknown fields are refused too, so a new field never
+// becomes writable by accident.
+func personFromPut(fields map[string]json.RawMessage, existing *Person) (Person, error) {
+	return personFromFields(fields, existing, Person{}, http.MethodPut)
+}
+
+// personFromPatch applies a JSON Merge Patch (RFC 7386) to the name fields of
+// existing: omitted fields keep their value and null clears one. Other fields
+// are handled as by personFromPut.
+func personFromPatch(fields map[string]json.RawMessage, existing *Person) (Person, error) {
+	return personFromFields(fields, existing, *existing, http.MethodPatch)
+}
+
+// personFromFields sets the name fields in fields on person, refusing changes to any
+// other field of existing as personFromPut describes; method names the request in errors
+func personFromFields(fields map[string]json.RawMessage, existing *Person, person Person, method string) (Person, error) {
+	stored, err := putFieldValues(existing)
+	if err != nil {
+		return Person{}, err
+	}
+
+	for field, raw := range fields {
+		key := putFieldKey(field)
+		if putIgnoredFields[key] {
+			continue
+		}
+		if !putFields[key] {
+			current, known := stored[key]
+			var value interface{}
+			if !known || json.Unmarshal(raw, &value) != nil || !reflect.DeepEqual(normalizePutValue(value), current) {
+				return person, newDomainError(errValidation, "Field %s cannot be set through %s", field, method)
+			}
+			continue
+		}
+
+		// Decoding null leaves value empty, clearing the field
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return person, newDomainError(errValidation, "Invalid value for field %s", field)
+		}
+		switch key {
+		case "name":
+			person.Name = value
+		case "surname":
+			person.Surname = value
+		case "patronymic":
+			person.Patronymic = value
+		}
+	}
+	return person, nil
+}
+
+// putFieldValues returns the fields of person as a GET shows them, normalized by normalizePutValue
+func putFieldValues(person *Person) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(person)
+	if err != nil {
+		return nil, err
+	}
+	var shown map[string]interface{}
+	if err := json.Unmarshal(encoded, &shown); err != nil {
+		return nil, err
+	}
+
+	return normalizePutValue(shown).(map[string]interface{}), nil
+}
+
+// normalizePutValue rekeys the objects within a decoded JSON value by putFieldKey,
+// so nested fields such as the candidates' CountryID compare equal in any naming
+func normalizePutValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for field, value := range v {
+			out[putFieldKey(field)] = normalizePutValue(value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = normalizePutValue(value)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutAcceptsBodyFromGet(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	for _, naming := range []string{namingDefault, namingSnake, namingCamel} {
+		accept := "application/json; naming=" + naming
+		resp, data := call(t, server, http.MethodGet, path, "", "Accept", accept)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET with %q naming answered %d: %s", naming, resp.StatusCode, data)
+		}
+
+		var body map[string]interface{}
+		decode(t, data, &body)
+		surnameKey := "Surname"
+		if naming != namingDefault {
+			surnameKey = "surname"
+		}
+		body[surnameKey] = "Petrov-" + naming
+		edited, _ := json.Marshal(body)
+
+		resp, data = call(t, server, http.MethodPut, path, string(edited), "Accept", accept)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("PUT of the GET body with %q naming answered %d: %s", naming, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestPutRejectsDerivedFieldChanges(t *testing.T) {
+	server, _ := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	for _, body := range []string{
+		`{"name":"Dmitriy","Age":17}`,
+		`{"name":"Dmitriy","gender_probability":0.1}`,
+		`{"name":"Dmitriy","nationality":"FR"}`,
+		`{"name":"Dmitriy","favouriteColour":"green"}`,
+	} {
+		resp, data := call(t, server, http.MethodPut, path, body)
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("PUT %s answered %d: %s", body, resp.StatusCode, data)
+		}
+	}
+
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.Age == nil || *stored.Age != 42 || stored.Nationality != "RU" {
+		t.Errorf("Derived fields changed through PUT: %+v", stored)
+	}
+}
+
+func TestPatchSharesPutFieldHandling(t *testing.T) {
+	server, stub := newTestServer(t)
+	person := createTestPerson(t, server, `{"name":"Dmitriy","surname":"Ushakov","patronymic":"Vasilevich"}`)
+	path := fmt.Sprintf("/people/%d", person.ID)
+
+	for _, body := range []string{`{"Age":17}`, `{"favouriteColour":"green"}`} {
+		if resp, data := call(t, server, http.MethodPatch, path, body); resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("PATCH %s answered %d: %s", body, resp.StatusCode, data)
+		}
+	}
+
+	// Stale enrichment is refreshed by a PATCH as by a PUT, even when the change alone wouldn't trigger it
+	db.Model(&Person{}).Where("id = ?", person.ID).UpdateColumn("enriched_at", time.Now().Add(-2*defaultEnrichmentFreshness))
+	enrichCache = noCache{}
+	calls := stub.callCount(agifyProvider.Name)
+	resp, data := call(t, server, http.MethodPatch, path, `{"Age":42,"surname":"Petrov","patronymic":null}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH answered %d: %s", resp.StatusCode, data)
+	}
+	if stub.callCount(agifyProvider.Name) == calls {
+		t.Error("PATCH of a stale person didn't re-enrich it")
+	}
+
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.Name != "Dmitriy" || stored.Surname != "Petrov" || stored.Patronymic != "" {
+		t.Errorf("PATCH stored %q %q %q, want the name kept, surname set and patronymic cleared", stored.Name, stored.Surname, stored.Patronymic)
+	}
+}
+
+// fieldsReported decodes the "errors" of a validation response into the fields they name
+func fieldsReported(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	var body struct {
+		Errors []struct{ Field, Message string }
+	}
+	decode(t, data, &body)
+	fields := map[string]bool{}
+	for _, e := range body.Errors {
+		fields[e.Field] = true
+	}
+	return fields
+}
+
+func TestEveryInvalidFieldReported(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp, data := call(t, server, http.MethodPost, "/people", `{"name":"D1mitriy","surname":"Ush@kov","patronymic":"Vasil3vich"}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Create of three invalid names answered %d: %s", resp.StatusCode, data)
+	}
+	if fields := fieldsReported(t, data); !fields["Name"] || !fields["Surname"] || !fields["Patronymic"] {
+		t.Errorf("Create reported %v, want Name, Surname and Patronymic", fields)
+	}
+
+	person := createTestPerson(t, server, `{"name":"Dmitriy"}`)
+	path := fmt.Sprintf("/people/%d/override", person.ID)
+	resp, data = call(t, server, http.MethodPatch, path, `{"Age":200,"Gender":"robot","Nationality":"Atlantis"}`)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Override of three invalid fields answered %d: %s", resp.StatusCode, data)
+	}
+	if fields := fieldsReported(t, data); !fields["Age"] || !fields["Gender"] || !fields["Nationality"] {
+		t.Errorf("Override reported %v, want Age, Gender and Nationality", fields)
+	}
+	var stored Person
+	db.First(&stored, person.ID)
+	if stored.ManualOverride || *stored.Age != 42 {
+		t.Errorf("Refused override was stored: %+v", stored)
+	}
+}
+
+func TestNameCharsetAndLength(t *testing.T) {
+	server, stub := newTestServer(t)
+
+	for _, name := range []string{"Dmitriy", "Anne-Marie", "O'Brien", "Мария", "Jean Luc"} {
+		body, _ := json.Marshal(map[string]string{"name": name, "surname": name})
+		if resp, data := call(t, server, http.MethodPost, "/people", string(body)); resp.StatusCode != http.StatusCreated {
+			t.Errorf("Valid name %q answered %d: %s", name, resp.StatusCode, data)
+		}
+	}
+
+	calls := stub.callCount(agifyProvider.Name)
+	for _, name := range []string{"R2D2", "Dmitriy!", "<script>", strings.Repeat("a", defaultNameMaxLength+1)} {
+		body, _ := json.Marshal(map[string]string{"name": name})
+		if resp, data := call(t, server, http.MethodPost, "/people", string(body)); resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("Invalid name %q answered %d: %s", name, resp.StatusCode, data)
+		}
+	}
+	if n := stub.callCount(agifyProvider.Name); n != calls {
+		t.Errorf("Invalid names were enriched: agify called %d more times", n-calls)
+	}
+
+	t.Setenv("NAME_SCRIPTS", "Cyrillic")
+	if resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Dmitriy"}`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Latin name with NAME_SCRIPTS=Cyrillic answered %d: %s", resp.StatusCode, data)
+	}
+	if resp, data := call(t, server, http.MethodPost, "/people", `{"name":"Дмитрий"}`); resp.StatusCode != http.StatusCreated {
+		t.Errorf("Cyrillic name with NAME_SCRIPTS=Cyrillic answered %d: %s", resp.StatusCode, data)
+	}
+}